@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"strconv"
@@ -28,20 +29,44 @@ type Task struct {
 	Arg1      float64 `json:"arg1"`
 	Arg2      float64 `json:"arg2"`
 	Operation string  `json:"operation"`
+	// Args — аргументы встроенной функции; у бинарных операций пусто.
+	Args []float64 `json:"args,omitempty"`
+	// Mode, Precision и Operands заполнены в точных режимах.
+	Mode      string   `json:"mode,omitempty"`
+	Precision int      `json:"precision,omitempty"`
+	Operands  []string `json:"operands,omitempty"`
+	// Mat1 и Mat2 — аргументы матричных операций (matrix.go).
+	Mat1 [][]float64 `json:"mat1,omitempty"`
+	Mat2 [][]float64 `json:"mat2,omitempty"`
+	// Unit — единица результата; аргументы уже приведены к СИ.
+	Unit string `json:"unit,omitempty"`
 }
 
 type Result struct {
-	ID     string  `json:"id"`
-	Result float64 `json:"result"`
+	ID     string      `json:"id"`
+	Result float64     `json:"result"`
+	Value  string      `json:"value,omitempty"`
+	Matrix [][]float64 `json:"matrix,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	// DurationMs — время вычисления задачи вместе с задержкой операции;
+	// по нему сервер оценивает длительность операций.
+	DurationMs int64 `json:"duration_ms,omitempty"`
 }
 
 var orchestratorURL = "http://localhost:8080/internal/task"
 
+// agentID передаётся серверу в заголовке X-Agent-ID, чтобы он учитывал
+// скорость каждого агента отдельно.
+var agentID string
+
 var (
-	timeAdditionMs       int
-	timeSubtractionMs    int
-	timeMultiplicationMs int
-	timeDivisionMs       int
+	timeAdditionMs        int
+	timeSubtractionMs     int
+	timeMultiplicationMs  int
+	timeDivisionMs        int
+	timeExponentiationMs  int
+	timeModuloMs          int
+	timeIntegerDivisionMs int
 )
 
 func init() {
@@ -55,6 +80,18 @@ func init() {
 	timeSubtractionMs = getEnvInt("TIME_SUBTRACTION_MS", 500)
 	timeMultiplicationMs = getEnvInt("TIME_MULTIPLICATIONS_MS", 700)
 	timeDivisionMs = getEnvInt("TIME_DIVISIONS_MS", 1000)
+	timeExponentiationMs = getEnvInt("TIME_EXPONENTIATION_MS", 1200)
+	timeModuloMs = getEnvInt("TIME_MODULO_MS", 1000)
+	timeIntegerDivisionMs = getEnvInt("TIME_INTEGER_DIVISIONS_MS", 1000)
+	timeMatrixMs = getEnvInt("TIME_MATRIX_MS", 1000)
+	timeComparisonsMs = getEnvInt("TIME_COMPARISONS_MS", 300)
+	loadFunctionDelays()
+
+	agentID = os.Getenv("AGENT_ID")
+	if agentID == "" {
+		host, _ := os.Hostname()
+		agentID = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
 }
 
 func getEnvInt(key string, defaultValue int) int {
@@ -82,56 +119,77 @@ func StartAgentLogic() {
 	log.Printf("Используется COMPUTING_POWER = %d", power)
 
 	taskQueue := make(chan Task, power)
+	results := make(chan Result, power)
+	free := make(slots, power)
 
 	for i := 0; i < power; i++ {
-		go worker(taskQueue)
+		go worker(taskQueue, results, free)
 	}
+	go resultSender(results, power)
 
 	for {
-		task, err := fetchTask()
+		// Задач запрашивается столько, сколько свободно вычислителей:
+		// место занимает и вычисляемая задача, и ждущая в очереди. Пока все
+		// заняты, агент ждёт и задачи у сервера не забирает.
+		free.acquire()
+		limit := 1
+		for limit < power && free.tryAcquire() {
+			limit++
+		}
+		batch, err := fetchTasks(limit)
+		for i := len(batch); i < limit; i++ {
+			free.release()
+		}
 		if err != nil {
 			log.Printf("Ошибка получения задачи: %v", err)
 			time.Sleep(2 * time.Second)
 			continue
 		}
 
-		if task.ID == "" {
+		if len(batch) == 0 {
 			log.Println("Ожидание задач от сервера...")
 			time.Sleep(2 * time.Second)
 			continue
 		}
 
-		taskQueue <- task
+		for _, task := range batch {
+			taskQueue <- task
+		}
 	}
 }
 
-func fetchTask() (Task, error) {
-	resp, err := http.Get(orchestratorURL)
+// fetchTasks запрашивает до limit задач одним запросом.
+func fetchTasks(limit int) ([]Task, error) {
+	req, err := http.NewRequest(http.MethodGet, orchestratorURL+"?limit="+strconv.Itoa(limit), nil)
 	if err != nil {
-		log.Printf("Ошибка получения задачи: %v", err)
-		return Task{}, err
+		return nil, err
+	}
+	req.Header.Set("X-Agent-ID", agentID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotFound {
 		log.Println("Сервер ответил: задач нет (404)")
-		return Task{}, nil
+		return nil, nil
 	}
-
 	if resp.StatusCode != http.StatusOK {
 		log.Printf("Ошибка: сервер вернул статус %d", resp.StatusCode)
-		return Task{}, fmt.Errorf("ошибка: %d", resp.StatusCode)
+		return nil, fmt.Errorf("ошибка: %d", resp.StatusCode)
 	}
 
-	var response Task
-	err = json.NewDecoder(resp.Body).Decode(&response)
-	if err != nil {
-		log.Printf("Ошибка декодирования задачи: %v", err)
-		return Task{}, err
+	var response struct {
+		Tasks []Task `json:"tasks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		log.Printf("Ошибка декодирования задач: %v", err)
+		return nil, err
 	}
 
-	log.Printf("Получена задача: %+v", response)
-	return response, nil
+	log.Printf("Получено задач: %d", len(response.Tasks))
+	return response.Tasks, nil
 }
 
 func sendResult(result Result) error {
@@ -142,7 +200,13 @@ func sendResult(result Result) error {
 		return err
 	}
 
-	resp, err := http.Post(orchestratorURL, "application/json", bytes.NewBuffer(data))
+	req, err := http.NewRequest(http.MethodPost, orchestratorURL, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Agent-ID", agentID)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.Printf("Ошибка отправки результата: %v", err)
 		return err
@@ -158,35 +222,163 @@ func sendResult(result Result) error {
 	return nil
 }
 
-func worker(queue chan Task) {
+// sendResults отправляет пакет результатов одним запросом. Сервер
+// принимает результаты пакета независимо и сообщает об отказах по каждому.
+func sendResults(batch []Result) error {
+	if len(batch) == 1 {
+		return sendResult(batch[0])
+	}
+	log.Printf("Отправка результатов: %d", len(batch))
+
+	data, err := json.Marshal(map[string][]Result{"results": batch})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, orchestratorURL, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Agent-ID", agentID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Ошибка: сервер вернул статус %d", resp.StatusCode)
+		return fmt.Errorf("Ошибка: сервер вернул статус %d", resp.StatusCode)
+	}
+
+	var response struct {
+		Results []struct {
+			ID    string `json:"id"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return err
+	}
+	for _, r := range response.Results {
+		if r.Error != nil {
+			log.Printf("Сервер не принял результат задачи %s: %s", r.ID, r.Error.Message)
+		}
+	}
+
+	log.Println("Результаты успешно отправлены!")
+	return nil
+}
+
+// resultSender отправляет результаты воркеров. Всё, что накопилось, пока
+// шла предыдущая отправка, уходит одним пакетом до maxBatch результатов;
+// одиночный результат отправляется сразу, без ожидания пакета.
+func resultSender(results <-chan Result, maxBatch int) {
+	for first := range results {
+		batch := []Result{first}
+	collect:
+		for len(batch) < maxBatch {
+			select {
+			case r, ok := <-results:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, r)
+			default:
+				break collect
+			}
+		}
+		if err := sendResults(batch); err != nil {
+			log.Printf("Ошибка отправки результатов: %v", err)
+		}
+	}
+}
+
+// slots — семафор вычислителей агента: место занято с получения задачи от
+// сервера до отправки её результата в results.
+type slots chan struct{}
+
+func (s slots) acquire() { s <- struct{}{} }
+
+func (s slots) tryAcquire() bool {
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s slots) release() { <-s }
+
+// worker вычисляет задачи из очереди и освобождает место в free после
+// каждого результата.
+func worker(queue chan Task, results chan<- Result, free slots) {
 	for task := range queue {
-		log.Printf("Обработка задачи: %f %s %f", task.Arg1, task.Operation, task.Arg2)
+		log.Printf("Обработка задачи: %f %s %f %s", task.Arg1, task.Operation, task.Arg2, task.Unit)
 
+		start := time.Now()
 		delay := getOperationDelay(task.Operation)
 		log.Printf("Ожидание %d мс перед выполнением операции %s", delay, task.Operation)
 		time.Sleep(time.Duration(delay) * time.Millisecond)
 
-		value, err := compute(task.Arg1, task.Arg2, task.Operation)
+		res, err := execute(task)
+		res.DurationMs = time.Since(start).Milliseconds()
 		if err != nil {
 			log.Printf("Ошибка вычисления: %v", err)
-			continue
+			res = Result{ID: task.ID, Error: err.Error()}
+		} else {
+			log.Printf("Результат вычисления: %f %s", res.Result, res.Value)
 		}
+		results <- res
+		free.release()
+	}
+}
 
-		res := Result{ID: task.ID, Result: value}
-		log.Printf("Результат вычисления: %f", value)
+// execute вычисляет задачу: матричную операцию, в точном режиме, вызов
+// встроенной функции или бинарную операцию.
+func execute(task Task) (Result, error) {
+	if matrixOperations[task.Operation] {
+		m, err := computeMatrix(task)
+		return Result{ID: task.ID, Matrix: m}, err
+	}
+	if task.Mode != "" {
+		text, approx, err := computeExact(task)
+		return Result{ID: task.ID, Result: approx, Value: text}, err
+	}
 
-		if err := sendResult(res); err != nil {
-			log.Printf("Ошибка отправки результата: %v", err)
-		} else {
-			log.Println("Результат успешно отправлен!")
-		}
+	var value float64
+	var err error
+	if _, ok := functions[task.Operation]; ok {
+		value, err = callFunction(task.Operation, task.Args)
+	} else {
+		value, err = compute(task.Arg1, task.Arg2, task.Operation)
 	}
+	return Result{ID: task.ID, Result: value}, err
 }
 
 func compute(arg1, arg2 float64, op string) (float64, error) {
 	log.Printf("Вычисление: %f %s %f", arg1, op, arg2)
 
+	value, err := applyOperation(arg1, arg2, op)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("результат %g %s %g не является конечным числом", arg1, op, arg2)
+	}
+	return value, nil
+}
+
+func applyOperation(arg1, arg2 float64, op string) (float64, error) {
 	switch op {
+	case "&&", "||":
+		return float64(boolValue(logical(op, arg1 != 0, arg2 != 0))), nil
+	case "==", "!=", "<", "<=", ">", ">=":
+		return float64(boolValue(compare(op, cmpFloat(arg1, arg2)))), nil
 	case "+":
 		return arg1 + arg2, nil
 	case "-":
@@ -198,6 +390,18 @@ func compute(arg1, arg2 float64, op string) (float64, error) {
 			return 0, fmt.Errorf("деление на 0")
 		}
 		return arg1 / arg2, nil
+	case "//":
+		if arg2 == 0 {
+			return 0, fmt.Errorf("целочисленное деление на 0")
+		}
+		return math.Floor(arg1 / arg2), nil
+	case "%":
+		if arg2 == 0 {
+			return 0, fmt.Errorf("остаток от деления на 0")
+		}
+		return math.Mod(arg1, arg2), nil
+	case "^":
+		return math.Pow(arg1, arg2), nil
 	default:
 		return 0, fmt.Errorf("неизвестная операция: %s", op)
 	}
@@ -213,7 +417,25 @@ func getOperationDelay(op string) int {
 		return timeMultiplicationMs
 	case "/":
 		return timeDivisionMs
+	case "//":
+		return timeIntegerDivisionMs
+	case "%":
+		return timeModuloMs
+	case "^":
+		return timeExponentiationMs
 	default:
+		if matrixOperations[op] {
+			return timeMatrixMs
+		}
+		if isComparison(op) {
+			return timeComparisonsMs
+		}
+		if f, ok := functions[op]; ok {
+			return f.delayMs
+		}
+		if _, ok := complexFunctions[op]; ok {
+			return timeFunctionsMs
+		}
 		return 500
 	}
 }
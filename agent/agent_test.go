@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestCompute(t *testing.T) {
@@ -19,6 +20,12 @@ func TestCompute(t *testing.T) {
 		{6, 7, "*", 42, false},
 		{8, 2, "/", 4, false},
 		{5, 0, "/", 0, true},
+		{2, 10, "^", 1024, false},
+		{-7, 2, "%", -1, false},
+		{7, 0, "%", 0, true},
+		{-7, 2, "//", -4, false},
+		{7, 0, "//", 0, true},
+		{-8, 0.5, "^", 0, true},
 		{2, 3, "unknown", 0, true}, 
 	}
 
@@ -43,18 +50,19 @@ func TestFetchTask(t *testing.T) {
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]Task{"task": task})
+		json.NewEncoder(w).Encode(map[string][]Task{"tasks": {task}})
 	}))
 	defer server.Close()
 
 	orchestratorURL = server.URL
 
-	receivedTask, err := fetchTask()
-	if err != nil {
-		t.Fatalf("fetchTask() вернул ошибку: %v", err)
+	batch, err := fetchTasks(1)
+	if err != nil || len(batch) != 1 {
+		t.Fatalf("fetchTasks() вернул %v, ошибка: %v", batch, err)
 	}
+	receivedTask := batch[0]
 	if receivedTask.ID != task.ID || receivedTask.Arg1 != task.Arg1 || receivedTask.Arg2 != task.Arg2 || receivedTask.Operation != task.Operation {
-		t.Errorf("fetchTask() получено %v, ожидается %v", receivedTask, task)
+		t.Errorf("fetchTasks() получено %v, ожидается %v", receivedTask, task)
 	}
 }
 
@@ -95,26 +103,92 @@ func TestWorker(t *testing.T) {
 		Operation: "*",
 	}
 
+	taskQueue := make(chan Task, 1)
+	results := make(chan Result, 1)
+	free := make(slots, 1)
+	free.acquire()
+	go worker(taskQueue, results, free)
+
+	taskQueue <- task
+	close(taskQueue)
+
+	if received := <-results; received.ID != task.ID || received.Result != 42 {
+		t.Errorf("worker() отправил %v, ожидается %v", received.Result, 42)
+	}
+	select {
+	case free <- struct{}{}:
+	case <-time.After(time.Second):
+		t.Errorf("worker() не освободил место после результата")
+	}
+}
+
+func TestAgentReportsIdentityAndDuration(t *testing.T) {
+	saved := timeAdditionMs
+	timeAdditionMs = 20
+	defer func() { timeAdditionMs = saved }()
+
+	done := make(chan Result, 1)
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var received Result
-		err := json.NewDecoder(r.Body).Decode(&received)
-		if err != nil {
-			t.Fatalf("Ошибка при декодировании JSON: %v", err)
+		if got := r.Header.Get("X-Agent-ID"); got == "" || got != agentID {
+			t.Errorf("Ожидался заголовок X-Agent-ID %q, получено %q", agentID, got)
 		}
-
-		if received.Result != 42 {
-			t.Errorf("worker() отправил %v, ожидается %v", received.Result, 42)
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(map[string][]Task{"tasks": {{ID: "task-1", Arg1: 1, Arg2: 2, Operation: "+"}}})
+			return
 		}
-
-		w.WriteHeader(http.StatusOK)
+		var received Result
+		json.NewDecoder(r.Body).Decode(&received)
+		done <- received
 	}))
 	defer server.Close()
-
 	orchestratorURL = server.URL
 
-	taskQueue := make(chan Task, 1)
-	go worker(taskQueue)
+	batch, err := fetchTasks(1)
+	if err != nil || len(batch) != 1 {
+		t.Fatalf("fetchTasks() вернул %v, ошибка: %v", batch, err)
+	}
+	task := batch[0]
+	queue, results, free := make(chan Task, 1), make(chan Result, 1), make(slots, 1)
+	free.acquire()
+	go worker(queue, results, free)
+	go resultSender(results, 1)
+	queue <- task
+	close(queue)
+
+	if received := <-done; received.Result != 3 || received.DurationMs < 20 {
+		t.Errorf("Ожидался результат 3 за не менее 20 мс, получено %v за %d мс", received.Result, received.DurationMs)
+	}
+}
 
-	taskQueue <- task
-	close(taskQueue)
+func TestFetchAndSendBatches(t *testing.T) {
+	batch := []Task{{ID: "t1", Arg1: 1, Arg2: 2, Operation: "+"}, {ID: "t2", Arg1: 3, Arg2: 4, Operation: "+"}}
+	var received []Result
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			if limit := r.URL.Query().Get("limit"); limit != "2" {
+				t.Errorf("Ожидался limit=2, получено %q", limit)
+			}
+			json.NewEncoder(w).Encode(map[string][]Task{"tasks": batch})
+			return
+		}
+		var body struct {
+			Results []Result `json:"results"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		received = body.Results
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": []map[string]string{{"id": "t1", "status": "done"}, {"id": "t2", "status": "done"}}})
+	}))
+	defer server.Close()
+	orchestratorURL = server.URL
+
+	tasks, err := fetchTasks(2)
+	if err != nil || len(tasks) != 2 || tasks[1].ID != "t2" {
+		t.Fatalf("fetchTasks() вернул %v, %v", tasks, err)
+	}
+	if err := sendResults([]Result{{ID: "t1", Result: 3}, {ID: "t2", Result: 7}}); err != nil {
+		t.Fatalf("sendResults() вернул ошибку: %v", err)
+	}
+	if len(received) != 2 || received[1].Result != 7 {
+		t.Errorf("Сервер получил %v, ожидались два результата одним запросом", received)
+	}
 }
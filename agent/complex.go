@@ -0,0 +1,134 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strconv"
+)
+
+const modeComplex = "complex"
+
+// complexFunctions — функции режима complex. Функции, возвращающие
+// действительное число (abs, re, im, arg), дают комплексное с нулевой
+// мнимой частью.
+var complexFunctions = map[string]func(complex128) complex128{
+	"sqrt":  cmplx.Sqrt,
+	"abs":   func(z complex128) complex128 { return complex(cmplx.Abs(z), 0) },
+	"exp":   cmplx.Exp,
+	"ln":    cmplx.Log,
+	"log":   cmplx.Log,
+	"log10": cmplx.Log10,
+	"sin":   cmplx.Sin,
+	"cos":   cmplx.Cos,
+	"tan":   cmplx.Tan,
+	"asin":  cmplx.Asin,
+	"acos":  cmplx.Acos,
+	"atan":  cmplx.Atan,
+	"sinh":  cmplx.Sinh,
+	"cosh":  cmplx.Cosh,
+	"tanh":  cmplx.Tanh,
+	"conj":  cmplx.Conj,
+	"re":    func(z complex128) complex128 { return complex(real(z), 0) },
+	"im":    func(z complex128) complex128 { return complex(imag(z), 0) },
+	"arg":   func(z complex128) complex128 { return complex(cmplx.Phase(z), 0) },
+}
+
+// computeComplex вычисляет задачу режима complex. Операнды и результат
+// записываются так, как их понимает strconv.ParseComplex: "(3+4i)".
+func computeComplex(task Task) (string, float64, error) {
+	args := make([]complex128, len(task.Operands))
+	for i, text := range task.Operands {
+		z, err := strconv.ParseComplex(text, 128)
+		if err != nil {
+			return "", 0, fmt.Errorf("некорректный комплексный операнд %q", text)
+		}
+		args[i] = z
+	}
+
+	z, err := applyComplex(task.Operation, args)
+	if err != nil {
+		return "", 0, err
+	}
+	if cmplx.IsNaN(z) || cmplx.IsInf(z) {
+		return "", 0, fmt.Errorf("результат %s%v не является конечным числом", task.Operation, task.Operands)
+	}
+	return strconv.FormatComplex(z, 'g', -1, 128), real(z), nil
+}
+
+func applyComplex(op string, args []complex128) (complex128, error) {
+	if op == "sum" || op == "product" {
+		if len(args) == 0 {
+			return 0, fmt.Errorf("%s: нужен хотя бы один аргумент", op)
+		}
+		acc := args[0]
+		for _, z := range args[1:] {
+			if op == "sum" {
+				acc += z
+			} else {
+				acc *= z
+			}
+		}
+		return acc, nil
+	}
+	if fn, ok := complexFunctions[op]; ok {
+		switch {
+		case op == "log" && len(args) == 2:
+			if args[1] == 0 || args[1] == 1 {
+				return 0, fmt.Errorf("некорректное основание логарифма: %v", args[1])
+			}
+			return cmplx.Log(args[0]) / cmplx.Log(args[1]), nil
+		case len(args) != 1:
+			return 0, fmt.Errorf("%s: ожидается 1 аргумент, передано %d", op, len(args))
+		}
+		return fn(args[0]), nil
+	}
+	if _, ok := functions[op]; ok {
+		return 0, fmt.Errorf("функция %s недоступна в режиме %s", op, modeComplex)
+	}
+	if len(args) != 2 {
+		return 0, fmt.Errorf("операция %s ожидает 2 аргумента, передано %d", op, len(args))
+	}
+
+	a, b := args[0], args[1]
+	switch op {
+	case "&&", "||":
+		return complex(float64(boolValue(logical(op, a != 0, b != 0))), 0), nil
+	case "==", "!=":
+		// Комплексные числа не упорядочены, доступно только равенство.
+		return complex(float64(boolValue((a == b) == (op == "=="))), 0), nil
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return 0, fmt.Errorf("деление на 0")
+		}
+		return a / b, nil
+	case "^":
+		// Целые степени считаются умножением, чтобы (1+2i)^2 давало ровно -3+4i.
+		if imag(b) == 0 && real(b) == math.Trunc(real(b)) && math.Abs(real(b)) <= 64 {
+			return powComplexInt(a, int(real(b))), nil
+		}
+		return cmplx.Pow(a, b), nil
+	default:
+		return 0, fmt.Errorf("операция %s недоступна в режиме %s", op, modeComplex)
+	}
+}
+
+func powComplexInt(z complex128, n int) complex128 {
+	if n < 0 {
+		return 1 / powComplexInt(z, -n)
+	}
+	result := complex(1, 0)
+	for ; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			result *= z
+		}
+		z *= z
+	}
+	return result
+}
@@ -0,0 +1,37 @@
+package agent
+
+import "testing"
+
+func TestComputeComplex(t *testing.T) {
+	tests := []struct {
+		op        string
+		operands  []string
+		expected  string
+		expectErr bool
+	}{
+		{"*", []string{"(3+4i)", "(1-2i)"}, "(11-2i)", false},
+		{"+", []string{"3", "4i"}, "(3+4i)", false},
+		{"/", []string{"(1+1i)", "1i"}, "(1-1i)", false},
+		{"/", []string{"1", "0"}, "", true},
+		{"^", []string{"(1+2i)", "2"}, "(-3+4i)", false},
+		{"sqrt", []string{"-1"}, "(0+1i)", false},
+		{"abs", []string{"(3+4i)"}, "(5+0i)", false},
+		{"conj", []string{"(3+4i)"}, "(3-4i)", false},
+		{"im", []string{"(3+4i)"}, "(4+0i)", false},
+		{"%", []string{"3", "2"}, "", true},
+		{"max", []string{"1", "2"}, "", true},
+		{"sum", []string{"(1+1i)", "2", "3i"}, "(3+4i)", false},
+		{"product", []string{"1i", "1i", "2"}, "(-2+0i)", false},
+	}
+
+	for _, tt := range tests {
+		text, _, err := computeExact(Task{Mode: modeComplex, Operation: tt.op, Operands: tt.operands})
+		if (err != nil) != tt.expectErr {
+			t.Errorf("computeExact(complex %s %v) ожидает ошибку: %v, получено: %v", tt.op, tt.operands, tt.expectErr, err)
+			continue
+		}
+		if text != tt.expected {
+			t.Errorf("computeExact(complex %s %v) = %q, ожидается %q", tt.op, tt.operands, text, tt.expected)
+		}
+	}
+}
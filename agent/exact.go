@@ -0,0 +1,308 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+)
+
+// Точные режимы вычислений. Операнды и результат передаются строками:
+// в decimal — десятичная запись, округлённая до Precision значащих цифр,
+// в rational — несократимая дробь вида "1/3", в int64 и bigint — целое.
+const (
+	modeDecimal  = "decimal"
+	modeRational = "rational"
+	modeInt64    = "int64"
+	modeBigInt   = "bigint"
+)
+
+// maxExactExponent ограничивает показатель степени, чтобы числитель и
+// знаменатель не разрастались бесконечно.
+const maxExactExponent = 10000
+
+// computeExact вычисляет задачу точного режима и возвращает результат
+// строкой вместе с приближением float64.
+func computeExact(task Task) (string, float64, error) {
+	log.Printf("Точное вычисление (%s): %s %v", task.Mode, task.Operation, task.Operands)
+
+	switch task.Mode {
+	case modeDecimal, modeRational:
+	case modeInt64, modeBigInt:
+		return computeInteger(task)
+	case modeComplex:
+		return computeComplex(task)
+	default:
+		return "", 0, fmt.Errorf("неизвестный числовой режим: %s", task.Mode)
+	}
+	args := make([]*big.Rat, len(task.Operands))
+	for i, text := range task.Operands {
+		r, ok := new(big.Rat).SetString(text)
+		if !ok {
+			return "", 0, fmt.Errorf("некорректный операнд %q", text)
+		}
+		args[i] = r
+	}
+
+	r, err := applyExact(task.Mode, task.Precision, task.Operation, args)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var text string
+	if task.Mode == modeDecimal {
+		r, text = roundDecimal(r, task.Precision)
+	} else {
+		text = r.RatString()
+	}
+	approx, _ := r.Float64()
+	return text, approx, nil
+}
+
+func applyExact(mode string, precision int, op string, args []*big.Rat) (*big.Rat, error) {
+	if _, ok := functions[op]; ok {
+		return applyExactFunction(mode, precision, op, args)
+	}
+	if len(args) != 2 {
+		return nil, fmt.Errorf("операция %s ожидает 2 аргумента, передано %d", op, len(args))
+	}
+
+	a, b := args[0], args[1]
+	result := new(big.Rat)
+	switch op {
+	case "&&", "||":
+		return result.SetInt64(boolValue(logical(op, a.Sign() != 0, b.Sign() != 0))), nil
+	case "==", "!=", "<", "<=", ">", ">=":
+		return result.SetInt64(boolValue(compare(op, a.Cmp(b)))), nil
+	case "+":
+		return result.Add(a, b), nil
+	case "-":
+		return result.Sub(a, b), nil
+	case "*":
+		return result.Mul(a, b), nil
+	case "/":
+		if b.Sign() == 0 {
+			return nil, fmt.Errorf("деление на 0")
+		}
+		return result.Quo(a, b), nil
+	case "//":
+		if b.Sign() == 0 {
+			return nil, fmt.Errorf("целочисленное деление на 0")
+		}
+		return result.SetInt(floorRat(result.Quo(a, b))), nil
+	case "%":
+		if b.Sign() == 0 {
+			return nil, fmt.Errorf("остаток от деления на 0")
+		}
+		// Как math.Mod: знак результата совпадает со знаком делимого.
+		q := new(big.Rat).SetInt(truncRat(new(big.Rat).Quo(a, b)))
+		return result.Sub(a, q.Mul(q, b)), nil
+	case "^":
+		return powRat(a, b)
+	default:
+		return nil, fmt.Errorf("неизвестная операция: %s", op)
+	}
+}
+
+func applyExactFunction(mode string, precision int, name string, args []*big.Rat) (*big.Rat, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s: нужен хотя бы один аргумент", name)
+	}
+	if !isVariadic(name) && len(args) != 1 {
+		return nil, fmt.Errorf("%s: ожидается 1 аргумент, передано %d", name, len(args))
+	}
+
+	x := args[0]
+	result := new(big.Rat)
+	switch name {
+	case "abs":
+		return result.Abs(x), nil
+	case "sign":
+		return result.SetInt64(int64(x.Sign())), nil
+	case "floor":
+		return result.SetInt(floorRat(x)), nil
+	case "ceil":
+		return result.SetInt(ceilRat(x)), nil
+	case "trunc":
+		return result.SetInt(truncRat(x)), nil
+	case "round":
+		// Как math.Round: половина округляется от нуля.
+		half := big.NewRat(1, 2)
+		if x.Sign() < 0 {
+			return result.SetInt(ceilRat(result.Sub(x, half))), nil
+		}
+		return result.SetInt(floorRat(result.Add(x, half))), nil
+	case "min", "max":
+		result.Set(x)
+		for _, v := range args[1:] {
+			if (name == "min" && v.Cmp(result) < 0) || (name == "max" && v.Cmp(result) > 0) {
+				result.Set(v)
+			}
+		}
+		return result, nil
+	case "sum", "product":
+		result.Set(x)
+		for _, v := range args[1:] {
+			if name == "sum" {
+				result.Add(result, v)
+			} else {
+				result.Mul(result, v)
+			}
+		}
+		return result, nil
+	case "dev", "sqdev":
+		d := new(big.Rat)
+		for _, v := range args[1:] {
+			d.Sub(v, x)
+			if name == "sqdev" {
+				d.Mul(d, d)
+			}
+			result.Add(result, d)
+		}
+		return result, nil
+	case "sqrt":
+		return sqrtRat(mode, precision, x)
+	default:
+		return nil, fmt.Errorf("функция %s недоступна в режиме %s", name, mode)
+	}
+}
+
+// floorRat — наибольшее целое, не превосходящее x. Знаменатель big.Rat
+// всегда положителен, поэтому евклидово деление совпадает с округлением вниз.
+func floorRat(x *big.Rat) *big.Int {
+	q, m := new(big.Int), new(big.Int)
+	q.DivMod(x.Num(), x.Denom(), m)
+	return q
+}
+
+func ceilRat(x *big.Rat) *big.Int {
+	neg := new(big.Rat).Neg(x)
+	return new(big.Int).Neg(floorRat(neg))
+}
+
+func truncRat(x *big.Rat) *big.Int {
+	return new(big.Int).Quo(x.Num(), x.Denom())
+}
+
+func powRat(base, exp *big.Rat) (*big.Rat, error) {
+	if !exp.IsInt() {
+		return nil, fmt.Errorf("в точном режиме показатель степени должен быть целым, получено %s", exp.RatString())
+	}
+	if exp.Num().CmpAbs(big.NewInt(maxExactExponent)) > 0 {
+		return nil, fmt.Errorf("показатель степени по модулю больше %d", maxExactExponent)
+	}
+	n := new(big.Int).Abs(exp.Num())
+	num := new(big.Int).Exp(base.Num(), n, nil)
+	den := new(big.Int).Exp(base.Denom(), n, nil)
+	if exp.Sign() < 0 {
+		if base.Sign() == 0 {
+			return nil, fmt.Errorf("деление на 0")
+		}
+		num, den = den, num
+	}
+	return new(big.Rat).SetFrac(num, den), nil
+}
+
+func sqrtRat(mode string, precision int, x *big.Rat) (*big.Rat, error) {
+	if x.Sign() < 0 {
+		return nil, fmt.Errorf("квадратный корень из отрицательного числа %s", x.RatString())
+	}
+
+	if mode == modeRational {
+		num, den := new(big.Int).Sqrt(x.Num()), new(big.Int).Sqrt(x.Denom())
+		if new(big.Int).Mul(num, num).Cmp(x.Num()) != 0 || new(big.Int).Mul(den, den).Cmp(x.Denom()) != 0 {
+			return nil, fmt.Errorf("квадратный корень из %s не является рациональным числом", x.RatString())
+		}
+		return new(big.Rat).SetFrac(num, den), nil
+	}
+
+	// Двоичной мантиссы с запасом хватает, чтобы после округления до
+	// precision десятичных цифр результат был верным.
+	bits := uint(precision)*4 + 64
+	f := new(big.Float).SetPrec(bits).SetRat(x)
+	r, _ := new(big.Float).SetPrec(bits).Sqrt(f).Rat(nil)
+	return r, nil
+}
+
+// roundDecimal округляет x до digits значащих десятичных цифр (половина —
+// к чётному) и возвращает округлённое значение и его десятичную запись.
+func roundDecimal(x *big.Rat, digits int) (*big.Rat, string) {
+	if x.Sign() == 0 {
+		return new(big.Rat), "0"
+	}
+
+	abs := new(big.Rat).Abs(x)
+	upper := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)
+	lower := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits-1)), nil)
+
+	// scale — число цифр после запятой, при котором у abs ровно digits
+	// значащих цифр. Начальная оценка по длине числителя и знаменателя.
+	scale := digits - (len(abs.Num().String()) - len(abs.Denom().String()))
+	var n *big.Int
+	for {
+		n = roundHalfEven(scaleRat(abs, scale))
+		switch {
+		case n.Cmp(upper) >= 0:
+			scale--
+			continue
+		case n.Cmp(lower) < 0:
+			scale++
+			continue
+		}
+		break
+	}
+
+	text := formatScaled(n, scale)
+	if x.Sign() < 0 {
+		text = "-" + text
+	}
+	rounded, _ := new(big.Rat).SetString(text)
+	return rounded, text
+}
+
+// scaleRat возвращает x * 10^scale.
+func scaleRat(x *big.Rat, scale int) *big.Rat {
+	pow := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(scale))), nil)
+	factor := new(big.Rat).SetInt(pow)
+	if scale < 0 {
+		return new(big.Rat).Quo(x, factor)
+	}
+	return new(big.Rat).Mul(x, factor)
+}
+
+func roundHalfEven(x *big.Rat) *big.Int {
+	q, m := new(big.Int), new(big.Int)
+	q.DivMod(x.Num(), x.Denom(), m)
+	switch new(big.Int).Lsh(m, 1).Cmp(x.Denom()) {
+	case 1:
+		q.Add(q, big.NewInt(1))
+	case 0:
+		if q.Bit(0) == 1 {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
+
+// formatScaled записывает n * 10^-scale без экспоненты и без незначащих нулей.
+func formatScaled(n *big.Int, scale int) string {
+	digits := n.String()
+	if scale <= 0 {
+		return digits + strings.Repeat("0", -scale)
+	}
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	whole, frac := digits[:len(digits)-scale], strings.TrimRight(digits[len(digits)-scale:], "0")
+	if frac == "" {
+		return whole
+	}
+	return whole + "." + frac
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
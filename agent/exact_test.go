@@ -0,0 +1,49 @@
+package agent
+
+import "testing"
+
+func TestComputeExact(t *testing.T) {
+	tests := []struct {
+		mode      string
+		precision int
+		op        string
+		operands  []string
+		expected  string
+		expectErr bool
+	}{
+		{modeDecimal, 34, "+", []string{"0.1", "0.2"}, "0.3", false},
+		{modeDecimal, 10, "/", []string{"1", "3"}, "0.3333333333", false},
+		{modeDecimal, 10, "/", []string{"2", "3"}, "0.6666666667", false},
+		{modeDecimal, 3, "*", []string{"1.005", "1000"}, "1000", false},
+		{modeDecimal, 2, "+", []string{"0.125", "0"}, "0.12", false},
+		{modeDecimal, 34, "-", []string{"1e-30", "1"}, "-0.999999999999999999999999999999", false},
+		{modeDecimal, 5, "^", []string{"2", "-3"}, "0.125", false},
+		{modeDecimal, 20, "sqrt", []string{"2"}, "1.4142135623730950488", false},
+		{modeDecimal, 34, "//", []string{"-7", "2"}, "-4", false},
+		{modeDecimal, 34, "%", []string{"-7", "2"}, "-1", false},
+		{modeDecimal, 34, "^", []string{"2", "0.5"}, "", true},
+		{modeRational, 0, "/", []string{"1", "3"}, "1/3", false},
+		{modeRational, 0, "+", []string{"1/3", "1/6"}, "1/2", false},
+		{modeRational, 0, "sqrt", []string{"9/4"}, "3/2", false},
+		{modeRational, 0, "sqrt", []string{"2"}, "", true},
+		{modeRational, 0, "round", []string{"-5/2"}, "-3", false},
+		{modeRational, 0, "max", []string{"1/3", "0.34", "1/4"}, "17/50", false},
+		{modeRational, 0, "/", []string{"1", "0"}, "", true},
+		{modeRational, 0, "sum", []string{"1/3", "1/6", "0.5"}, "1", false},
+		{modeDecimal, 34, "product", []string{"0.1", "0.2", "3"}, "0.06", false},
+		{modeRational, 0, "sqdev", []string{"1/2", "1", "0"}, "1/2", false},
+		{modeRational, 0, "sin", []string{"1"}, "", true},
+	}
+
+	for _, tt := range tests {
+		task := Task{Mode: tt.mode, Precision: tt.precision, Operation: tt.op, Operands: tt.operands}
+		text, _, err := computeExact(task)
+		if (err != nil) != tt.expectErr {
+			t.Errorf("computeExact(%s %s %v) ожидает ошибку: %v, получено: %v", tt.mode, tt.op, tt.operands, tt.expectErr, err)
+			continue
+		}
+		if text != tt.expected {
+			t.Errorf("computeExact(%s %s %v) = %q, ожидается %q", tt.mode, tt.op, tt.operands, text, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+)
+
+// function — встроенная функция, которую агент умеет вычислять.
+type function struct {
+	apply func(args []float64) (float64, error)
+	// delayMs — время выполнения, настраивается переменной TIME_FUNC_<ИМЯ>_MS.
+	delayMs int
+}
+
+// timeFunctionsMs — задержка функций без собственной переменной, в том числе
+// функций только режима complex (conj, re, im, arg).
+var timeFunctionsMs int
+
+var functions = map[string]*function{
+	"sqrt":  unary(math.Sqrt),
+	"cbrt":  unary(math.Cbrt),
+	"abs":   unary(math.Abs),
+	"sign":  unary(sign),
+	"round": unary(math.Round),
+	"floor": unary(math.Floor),
+	"ceil":  unary(math.Ceil),
+	"trunc": unary(math.Trunc),
+	"exp":   unary(math.Exp),
+	"ln":    unary(math.Log),
+	"log":   {apply: logarithm},
+	"log2":  unary(math.Log2),
+	"log10": unary(math.Log10),
+	"sin":   unary(math.Sin),
+	"cos":   unary(math.Cos),
+	"tan":   unary(math.Tan),
+	"asin":  unary(math.Asin),
+	"acos":  unary(math.Acos),
+	"atan":  unary(math.Atan),
+	"atan2": binary(math.Atan2),
+	"sinh":  unary(math.Sinh),
+	"cosh":  unary(math.Cosh),
+	"tanh":  unary(math.Tanh),
+	"hypot": binary(math.Hypot),
+	"min":   {apply: reduce(math.Min)},
+	"max":   {apply: reduce(math.Max)},
+
+	"sum":     {apply: reduce(func(a, b float64) float64 { return a + b })},
+	"product": {apply: reduce(func(a, b float64) float64 { return a * b })},
+	"dev":     {apply: deviations(func(d float64) float64 { return d })},
+	"sqdev":   {apply: deviations(func(d float64) float64 { return d * d })},
+}
+
+// loadFunctionDelays читает задержки функций; без переменной для конкретной
+// функции используется общая TIME_FUNCTIONS_MS.
+func loadFunctionDelays() {
+	timeFunctionsMs = getEnvInt("TIME_FUNCTIONS_MS", 800)
+	for name, f := range functions {
+		f.delayMs = getEnvInt("TIME_FUNC_"+strings.ToUpper(name)+"_MS", timeFunctionsMs)
+	}
+}
+
+func unary(fn func(float64) float64) *function {
+	return &function{apply: func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("ожидается 1 аргумент, передано %d", len(args))
+		}
+		return fn(args[0]), nil
+	}}
+}
+
+func binary(fn func(float64, float64) float64) *function {
+	return &function{apply: func(args []float64) (float64, error) {
+		if len(args) != 2 {
+			return 0, fmt.Errorf("ожидается 2 аргумента, передано %d", len(args))
+		}
+		return fn(args[0], args[1]), nil
+	}}
+}
+
+func reduce(fn func(float64, float64) float64) func(args []float64) (float64, error) {
+	return func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, fmt.Errorf("нужен хотя бы один аргумент")
+		}
+		acc := args[0]
+		for _, v := range args[1:] {
+			acc = fn(acc, v)
+		}
+		return acc, nil
+	}
+}
+
+// deviations — служебные функции stddev: dev(k, x...) = Σ(x-k),
+// sqdev(k, x...) = Σ(x-k)², где fn(x-k) — слагаемое.
+func deviations(fn func(float64) float64) func(args []float64) (float64, error) {
+	return func(args []float64) (float64, error) {
+		if len(args) < 2 {
+			return 0, fmt.Errorf("нужны сдвиг и хотя бы одно значение")
+		}
+		var acc float64
+		for _, v := range args[1:] {
+			acc += fn(v - args[0])
+		}
+		return acc, nil
+	}
+}
+
+func sign(x float64) float64 {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// logarithm: log(x) — натуральный логарифм, log(x, base) — по основанию base.
+func logarithm(args []float64) (float64, error) {
+	switch len(args) {
+	case 1:
+		return math.Log(args[0]), nil
+	case 2:
+		if args[1] <= 0 || args[1] == 1 {
+			return 0, fmt.Errorf("некорректное основание логарифма: %g", args[1])
+		}
+		return math.Log(args[0]) / math.Log(args[1]), nil
+	default:
+		return 0, fmt.Errorf("ожидается 1 или 2 аргумента, передано %d", len(args))
+	}
+}
+
+// callFunction вычисляет встроенную функцию по реестру.
+func callFunction(name string, args []float64) (float64, error) {
+	log.Printf("Вычисление: %s%v", name, args)
+
+	f, ok := functions[name]
+	if !ok {
+		return 0, fmt.Errorf("неизвестная функция: %s", name)
+	}
+	value, err := f.apply(args)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", name, err)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("результат %s%v не является конечным числом", name, args)
+	}
+	return value, nil
+}
+
+// isVariadic — функция принимает произвольное число аргументов.
+func isVariadic(name string) bool {
+	switch name {
+	case "min", "max", "sum", "product", "dev", "sqdev":
+		return true
+	}
+	return false
+}
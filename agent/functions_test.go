@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCallFunction(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []float64
+		expected  float64
+		expectErr bool
+	}{
+		{"sqrt", []float64{16}, 4, false},
+		{"sqrt", []float64{-1}, 0, true},
+		{"abs", []float64{-2.5}, 2.5, false},
+		{"round", []float64{2.5}, 3, false},
+		{"floor", []float64{-1.5}, -2, false},
+		{"ceil", []float64{1.2}, 2, false},
+		{"min", []float64{3, -1, 2}, -1, false},
+		{"max", []float64{3, -1, 2}, 3, false},
+		{"log", []float64{8, 2}, 3, false},
+		{"log", []float64{8, 1}, 0, true},
+		{"ln", []float64{0}, 0, true},
+		{"exp", []float64{0}, 1, false},
+		{"hypot", []float64{3, 4}, 5, false},
+		{"sqrt", []float64{1, 2}, 0, true},
+		{"sum", []float64{1, 2, 3.5}, 6.5, false},
+		{"product", []float64{2, 3, 4}, 24, false},
+		{"dev", []float64{10, 9, 12}, 1, false},
+		{"sqdev", []float64{10, 9, 12}, 5, false},
+		{"sqdev", []float64{10}, 0, true},
+		{"unknown", []float64{1}, 0, true},
+	}
+
+	for _, tt := range tests {
+		result, err := callFunction(tt.name, tt.args)
+		if (err != nil) != tt.expectErr {
+			t.Errorf("callFunction(%s, %v) ожидает ошибку: %v, получено: %v", tt.name, tt.args, tt.expectErr, err)
+		}
+		if math.Abs(result-tt.expected) > 1e-12 {
+			t.Errorf("callFunction(%s, %v) = %f, ожидается %f", tt.name, tt.args, result, tt.expected)
+		}
+	}
+}
+
+func TestFunctionDelays(t *testing.T) {
+	t.Setenv("TIME_FUNCTIONS_MS", "300")
+	t.Setenv("TIME_FUNC_SQRT_MS", "50")
+	loadFunctionDelays()
+
+	if delay := getOperationDelay("sqrt"); delay != 50 {
+		t.Errorf("задержка sqrt = %d, ожидается 50", delay)
+	}
+	if delay := getOperationDelay("max"); delay != 300 {
+		t.Errorf("задержка max = %d, ожидается 300", delay)
+	}
+}
+
+func TestExecuteDispatchesFunctions(t *testing.T) {
+	res, err := execute(Task{Operation: "max", Args: []float64{2, 4}})
+	if err != nil || res.Result != 4 {
+		t.Errorf("execute(max) = %f, %v, ожидается 4", res.Result, err)
+	}
+	res, err = execute(Task{Operation: "*", Arg1: 3, Arg2: 4})
+	if err != nil || res.Result != 12 {
+		t.Errorf("execute(*) = %f, %v, ожидается 12", res.Result, err)
+	}
+}
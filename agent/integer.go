@@ -0,0 +1,144 @@
+package agent
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// computeInteger вычисляет задачу целочисленного режима. Все вычисления идут
+// в big.Int; в режиме int64 результат, не помещающийся в 64 бита, считается
+// переполнением, а не заворачивается и не превращается в float.
+func computeInteger(task Task) (string, float64, error) {
+	args := make([]*big.Int, len(task.Operands))
+	for i, text := range task.Operands {
+		r, ok := new(big.Rat).SetString(text)
+		if !ok || !r.IsInt() {
+			return "", 0, fmt.Errorf("операнд %q не является целым числом", text)
+		}
+		args[i] = new(big.Int).Set(r.Num())
+	}
+
+	n, err := applyInteger(task.Operation, args)
+	if err != nil {
+		return "", 0, err
+	}
+	if task.Mode == modeInt64 && !n.IsInt64() {
+		return "", 0, fmt.Errorf("переполнение int64: %s %v", task.Operation, task.Operands)
+	}
+	approx, _ := new(big.Float).SetInt(n).Float64()
+	return n.String(), approx, nil
+}
+
+func applyInteger(op string, args []*big.Int) (*big.Int, error) {
+	if _, ok := functions[op]; ok {
+		return applyIntegerFunction(op, args)
+	}
+	if len(args) != 2 {
+		return nil, fmt.Errorf("операция %s ожидает 2 аргумента, передано %d", op, len(args))
+	}
+
+	a, b := args[0], args[1]
+	result := new(big.Int)
+	switch op {
+	case "&&", "||":
+		return result.SetInt64(boolValue(logical(op, a.Sign() != 0, b.Sign() != 0))), nil
+	case "==", "!=", "<", "<=", ">", ">=":
+		return result.SetInt64(boolValue(compare(op, a.Cmp(b)))), nil
+	case "+":
+		return result.Add(a, b), nil
+	case "-":
+		return result.Sub(a, b), nil
+	case "*":
+		return result.Mul(a, b), nil
+	case "/":
+		if b.Sign() == 0 {
+			return nil, fmt.Errorf("деление на 0")
+		}
+		rem := new(big.Int)
+		result.QuoRem(a, b, rem)
+		if rem.Sign() != 0 {
+			return nil, fmt.Errorf("%s / %s не делится нацело, используйте //", a, b)
+		}
+		return result, nil
+	case "//":
+		if b.Sign() == 0 {
+			return nil, fmt.Errorf("целочисленное деление на 0")
+		}
+		return floorRat(new(big.Rat).SetFrac(a, b)), nil
+	case "%":
+		if b.Sign() == 0 {
+			return nil, fmt.Errorf("остаток от деления на 0")
+		}
+		// Rem, как и math.Mod, сохраняет знак делимого.
+		return result.Rem(a, b), nil
+	case "^":
+		if b.Sign() < 0 {
+			return nil, fmt.Errorf("отрицательный показатель степени %s в целочисленном режиме", b)
+		}
+		if b.Cmp(big.NewInt(maxExactExponent)) > 0 {
+			return nil, fmt.Errorf("показатель степени больше %d", maxExactExponent)
+		}
+		return result.Exp(a, b, nil), nil
+	default:
+		return nil, fmt.Errorf("неизвестная операция: %s", op)
+	}
+}
+
+func applyIntegerFunction(name string, args []*big.Int) (*big.Int, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s: нужен хотя бы один аргумент", name)
+	}
+	if !isVariadic(name) && len(args) != 1 {
+		return nil, fmt.Errorf("%s: ожидается 1 аргумент, передано %d", name, len(args))
+	}
+
+	x := args[0]
+	result := new(big.Int)
+	switch name {
+	case "abs":
+		return result.Abs(x), nil
+	case "sign":
+		return result.SetInt64(int64(x.Sign())), nil
+	case "round", "floor", "ceil", "trunc":
+		return result.Set(x), nil
+	case "min", "max":
+		result.Set(x)
+		for _, v := range args[1:] {
+			if (name == "min" && v.Cmp(result) < 0) || (name == "max" && v.Cmp(result) > 0) {
+				result.Set(v)
+			}
+		}
+		return result, nil
+	case "sum", "product":
+		result.Set(x)
+		for _, v := range args[1:] {
+			if name == "sum" {
+				result.Add(result, v)
+			} else {
+				result.Mul(result, v)
+			}
+		}
+		return result, nil
+	case "dev", "sqdev":
+		d := new(big.Int)
+		for _, v := range args[1:] {
+			d.Sub(v, x)
+			if name == "sqdev" {
+				d.Mul(d, d)
+			}
+			result.Add(result, d)
+		}
+		return result, nil
+	case "sqrt":
+		if x.Sign() < 0 {
+			return nil, fmt.Errorf("квадратный корень из отрицательного числа %s", x)
+		}
+		result.Sqrt(x)
+		if new(big.Int).Mul(result, result).Cmp(x) != 0 {
+			return nil, fmt.Errorf("квадратный корень из %s не является целым числом", x)
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("функция %s недоступна в целочисленном режиме", name)
+	}
+}
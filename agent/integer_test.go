@@ -0,0 +1,44 @@
+package agent
+
+import "testing"
+
+func TestComputeInteger(t *testing.T) {
+	tests := []struct {
+		mode      string
+		op        string
+		operands  []string
+		expected  string
+		expectErr bool
+	}{
+		{modeInt64, "+", []string{"9223372036854775806", "1"}, "9223372036854775807", false},
+		{modeInt64, "+", []string{"9223372036854775807", "1"}, "", true},
+		{modeInt64, "*", []string{"-4611686018427387904", "2"}, "-9223372036854775808", false},
+		{modeInt64, "^", []string{"2", "64"}, "", true},
+		{modeBigInt, "^", []string{"2", "64"}, "18446744073709551616", false},
+		{modeBigInt, "/", []string{"12", "4"}, "3", false},
+		{modeBigInt, "/", []string{"7", "2"}, "", true},
+		{modeBigInt, "//", []string{"-7", "2"}, "-4", false},
+		{modeBigInt, "//", []string{"7", "-2"}, "-4", false},
+		{modeBigInt, "%", []string{"-7", "2"}, "-1", false},
+		{modeBigInt, "^", []string{"2", "-1"}, "", true},
+		{modeBigInt, "sqrt", []string{"144"}, "12", false},
+		{modeBigInt, "sqrt", []string{"2"}, "", true},
+		{modeBigInt, "max", []string{"3", "-1", "7"}, "7", false},
+		{modeBigInt, "product", []string{"4294967296", "4294967296"}, "18446744073709551616", false},
+		{modeInt64, "product", []string{"4294967296", "4294967296"}, "", true},
+		{modeInt64, "dev", []string{"5", "3", "9"}, "2", false},
+		{modeBigInt, "+", []string{"1.5", "1"}, "", true},
+		{modeBigInt, "+", []string{"1e3", "1"}, "1001", false},
+	}
+
+	for _, tt := range tests {
+		text, _, err := computeExact(Task{Mode: tt.mode, Operation: tt.op, Operands: tt.operands})
+		if (err != nil) != tt.expectErr {
+			t.Errorf("computeExact(%s %s %v) ожидает ошибку: %v, получено: %v", tt.mode, tt.op, tt.operands, tt.expectErr, err)
+			continue
+		}
+		if text != tt.expected {
+			t.Errorf("computeExact(%s %s %v) = %q, ожидается %q", tt.mode, tt.op, tt.operands, text, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package agent
+
+// Сравнения и логические операции. Результат — 1 (истина) или 0 (ложь);
+// логические операции считают истинным любое ненулевое значение.
+
+// timeComparisonsMs — задержка сравнений и логических операций,
+// настраивается TIME_COMPARISONS_MS.
+var timeComparisonsMs int
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "&&", "||":
+		return true
+	}
+	return false
+}
+
+// compare вычисляет сравнение по результату cmp (-1, 0, 1), как у Cmp в math/big.
+func compare(op string, cmp int) bool {
+	switch op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// logical вычисляет && и || по истинности аргументов.
+func logical(op string, a, b bool) bool {
+	if op == "&&" {
+		return a && b
+	}
+	return a || b
+}
+
+func boolValue(v bool) int64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
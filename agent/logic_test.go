@@ -0,0 +1,56 @@
+package agent
+
+import "testing"
+
+func TestComparisonsFloat(t *testing.T) {
+	tests := []struct {
+		a, b     float64
+		op       string
+		expected float64
+	}{
+		{1, 2, "<", 1},
+		{2, 2, "<=", 1},
+		{3, 2, ">", 1},
+		{1, 2, ">=", 0},
+		{2, 2, "==", 1},
+		{2, 2, "!=", 0},
+		{5, 0, "&&", 0},
+		{5, -1, "&&", 1},
+		{0, 0, "||", 0},
+		{0, 0.5, "||", 1},
+	}
+	for _, tt := range tests {
+		result, err := compute(tt.a, tt.b, tt.op)
+		if err != nil || result != tt.expected {
+			t.Errorf("compute(%v %s %v) = %v, %v; ожидается %v", tt.a, tt.op, tt.b, result, err, tt.expected)
+		}
+	}
+}
+
+func TestComparisonsExactModes(t *testing.T) {
+	tests := []struct {
+		mode     string
+		op       string
+		operands []string
+		expected string
+	}{
+		{modeRational, "<", []string{"1/3", "0.3334"}, "1"},
+		{modeRational, "==", []string{"2/4", "0.5"}, "1"},
+		{modeDecimal, ">", []string{"0.1", "0.10000000000000000001"}, "0"},
+		{modeBigInt, ">=", []string{"18446744073709551616", "18446744073709551615"}, "1"},
+		{modeInt64, "&&", []string{"3", "0"}, "0"},
+		{modeComplex, "==", []string{"(1+2i)", "(1+2i)"}, "(1+0i)"},
+		{modeComplex, "!=", []string{"(1+2i)", "(1+2i)"}, "(0+0i)"},
+		{modeComplex, "||", []string{"0", "1i"}, "(1+0i)"},
+	}
+	for _, tt := range tests {
+		text, _, err := computeExact(Task{Mode: tt.mode, Precision: 34, Operation: tt.op, Operands: tt.operands})
+		if err != nil || text != tt.expected {
+			t.Errorf("computeExact(%s %s %v) = %q, %v; ожидается %q", tt.mode, tt.op, tt.operands, text, err, tt.expected)
+		}
+	}
+
+	if _, _, err := computeExact(Task{Mode: modeComplex, Operation: "<", Operands: []string{"1", "2"}}); err == nil {
+		t.Errorf("computeExact(complex <) должен вернуть ошибку")
+	}
+}
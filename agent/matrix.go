@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+// Операции над матрицами. Аргументы приходят в Mat1 и Mat2; если один из
+// аргументов поэлементной операции — число, он передаётся в Arg1 или Arg2
+// и применяется ко всем элементам матрицы.
+var matrixOperations = map[string]bool{
+	"matmul": true, "madd": true, "msub": true, "mscale": true, "mdiv": true, "transpose": true,
+}
+
+// timeMatrixMs — задержка одной матричной задачи, настраивается TIME_MATRIX_MS.
+var timeMatrixMs int
+
+func computeMatrix(task Task) ([][]float64, error) {
+	log.Printf("Матричная операция %s", task.Operation)
+
+	var (
+		out [][]float64
+		err error
+	)
+	switch task.Operation {
+	case "matmul":
+		out, err = matMul(task.Mat1, task.Mat2)
+	case "transpose":
+		out, err = transpose(task.Mat1)
+	case "madd":
+		out, err = elementwise(task, func(a, b float64) (float64, error) { return a + b, nil })
+	case "msub":
+		out, err = elementwise(task, func(a, b float64) (float64, error) { return a - b, nil })
+	case "mscale":
+		out, err = elementwise(task, func(a, b float64) (float64, error) { return a * b, nil })
+	case "mdiv":
+		if task.Mat2 != nil || task.Arg2 == 0 {
+			return nil, fmt.Errorf("деление матрицы возможно только на ненулевое число")
+		}
+		out, err = elementwise(task, func(a, b float64) (float64, error) { return a / b, nil })
+	default:
+		return nil, fmt.Errorf("неизвестная матричная операция: %s", task.Operation)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range out {
+		for _, v := range row {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return nil, fmt.Errorf("результат операции %s содержит неконечные значения", task.Operation)
+			}
+		}
+	}
+	return out, nil
+}
+
+func matMul(a, b [][]float64) ([][]float64, error) {
+	if len(a) == 0 || len(b) == 0 || len(a[0]) != len(b) {
+		return nil, fmt.Errorf("несогласованные размеры матриц для умножения")
+	}
+	out := make([][]float64, len(a))
+	for i, row := range a {
+		out[i] = make([]float64, len(b[0]))
+		for k, v := range row {
+			for j, w := range b[k] {
+				out[i][j] += v * w
+			}
+		}
+	}
+	return out, nil
+}
+
+func transpose(m [][]float64) ([][]float64, error) {
+	if len(m) == 0 {
+		return nil, fmt.Errorf("transpose: нужна матрица")
+	}
+	out := make([][]float64, len(m[0]))
+	for j := range out {
+		out[j] = make([]float64, len(m))
+		for i, row := range m {
+			out[j][i] = row[j]
+		}
+	}
+	return out, nil
+}
+
+// elementwise применяет fn к соответствующим элементам аргументов; число
+// вместо матрицы повторяется для каждого элемента.
+func elementwise(task Task, fn func(a, b float64) (float64, error)) ([][]float64, error) {
+	shape := task.Mat1
+	if shape == nil {
+		shape = task.Mat2
+	}
+	if shape == nil {
+		return nil, fmt.Errorf("%s: нужна хотя бы одна матрица", task.Operation)
+	}
+	if task.Mat1 != nil && task.Mat2 != nil && (len(task.Mat1) != len(task.Mat2) || len(task.Mat1[0]) != len(task.Mat2[0])) {
+		return nil, fmt.Errorf("%s: размеры матриц не совпадают", task.Operation)
+	}
+
+	at := func(m [][]float64, scalar float64, i, j int) float64 {
+		if m == nil {
+			return scalar
+		}
+		return m[i][j]
+	}
+	out := make([][]float64, len(shape))
+	for i, row := range shape {
+		out[i] = make([]float64, len(row))
+		for j := range row {
+			v, err := fn(at(task.Mat1, task.Arg1, i, j), at(task.Mat2, task.Arg2, i, j))
+			if err != nil {
+				return nil, err
+			}
+			out[i][j] = v
+		}
+	}
+	return out, nil
+}
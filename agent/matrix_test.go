@@ -0,0 +1,49 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComputeMatrix(t *testing.T) {
+	a := [][]float64{{1, 2}, {3, 4}}
+	b := [][]float64{{5, 6}, {7, 8}}
+
+	tests := []struct {
+		task      Task
+		expected  [][]float64
+		expectErr bool
+	}{
+		{Task{Operation: "matmul", Mat1: a, Mat2: b}, [][]float64{{19, 22}, {43, 50}}, false},
+		{Task{Operation: "matmul", Mat1: [][]float64{{1, 2, 3}}, Mat2: [][]float64{{1}, {1}, {1}}}, [][]float64{{6}}, false},
+		{Task{Operation: "matmul", Mat1: a, Mat2: [][]float64{{1, 2, 3}}}, nil, true},
+		{Task{Operation: "madd", Mat1: a, Mat2: b}, [][]float64{{6, 8}, {10, 12}}, false},
+		{Task{Operation: "msub", Arg1: 10, Mat2: a}, [][]float64{{9, 8}, {7, 6}}, false},
+		{Task{Operation: "mscale", Mat1: a, Arg2: -1}, [][]float64{{-1, -2}, {-3, -4}}, false},
+		{Task{Operation: "mdiv", Mat1: a, Arg2: 2}, [][]float64{{0.5, 1}, {1.5, 2}}, false},
+		{Task{Operation: "mdiv", Mat1: a, Arg2: 0}, nil, true},
+		{Task{Operation: "transpose", Mat1: [][]float64{{1, 2, 3}}}, [][]float64{{1}, {2}, {3}}, false},
+		{Task{Operation: "madd", Mat1: a, Mat2: [][]float64{{1}}}, nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := computeMatrix(tt.task)
+		if (err != nil) != tt.expectErr {
+			t.Errorf("computeMatrix(%s) ожидает ошибку: %v, получено: %v", tt.task.Operation, tt.expectErr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("computeMatrix(%s) = %v, ожидается %v", tt.task.Operation, got, tt.expected)
+		}
+	}
+}
+
+func TestExecuteReturnsMatrix(t *testing.T) {
+	res, err := execute(Task{ID: "m", Operation: "transpose", Mat1: [][]float64{{1, 2}}})
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if !reflect.DeepEqual(res.Matrix, [][]float64{{1}, {2}}) || res.Value != "" {
+		t.Errorf("execute(transpose) = %+v, ожидается матрица [[1] [2]]", res)
+	}
+}
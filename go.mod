@@ -2,4 +2,4 @@ module project2
 
 go 1.23.0
 
-require github.com/joho/godotenv v1.5.1 // indirect
+require github.com/joho/godotenv v1.5.1
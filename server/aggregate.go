@@ -0,0 +1,78 @@
+package server
+
+// Агрегатные функции над списками: sum([...]), product, avg, stddev.
+// При разборе список превращается в дерево свёртки: элементы делятся на
+// части по listChunkSize, каждую часть сворачивает одна задача агента,
+// а частичные результаты сворачиваются так же, пока не останется одна
+// задача. Задачи одного уровня независимы и выполняются параллельно.
+
+// listChunkSize — сколько значений сворачивает одна задача агента.
+var listChunkSize int
+
+// chunkSize не даёт свёртке зациклиться при LIST_CHUNK_SIZE меньше 2.
+func chunkSize() int {
+	return max(listChunkSize, 2)
+}
+
+// expandAggregate строит дерево свёртки для агрегатной функции name.
+func expandAggregate(name token, elems []*Node) *Node {
+	pos := name.pos
+	count := &Node{Kind: NodeNumber, Value: float64(len(elems)), Text: formatNumber(float64(len(elems))), Pos: pos}
+	switch name.text {
+	case "product":
+		return reduceList("product", elems, pos)
+	case "avg":
+		return &Node{Kind: NodeBinary, Op: "/", Args: []*Node{reduceList("sum", elems, pos), count}, Pos: pos}
+	case "stddev":
+		return expandStddev(elems, count, pos)
+	default:
+		return reduceList("sum", elems, pos)
+	}
+}
+
+// reduceList сворачивает значения операцией op частями по listChunkSize.
+func reduceList(op string, nodes []*Node, pos int) *Node {
+	size := chunkSize()
+	for len(nodes) > size {
+		var partials []*Node
+		for i := 0; i < len(nodes); i += size {
+			chunk := nodes[i:min(i+size, len(nodes))]
+			if len(chunk) == 1 {
+				partials = append(partials, chunk[0])
+				continue
+			}
+			partials = append(partials, &Node{Kind: NodeCall, Op: op, Args: chunk, Pos: pos})
+		}
+		nodes = partials
+	}
+	return &Node{Kind: NodeCall, Op: op, Args: nodes, Pos: pos}
+}
+
+// expandStddev строит стандартное отклонение генеральной совокупности.
+// Отклонения считаются от первого элемента k, а не от среднего: тогда
+// каждой части не нужно ждать среднего всего списка, а сдвиг сохраняет
+// точность float64 для больших значений с малым разбросом:
+//
+//	stddev = sqrt(n * Σ(x-k)² - (Σ(x-k))²) / n
+func expandStddev(elems []*Node, count *Node, pos int) *Node {
+	k := elems[0]
+	size := chunkSize()
+	var devs, sqdevs []*Node
+	for i := 0; i < len(elems); i += size {
+		chunk := elems[i:min(i+size, len(elems))]
+		devs = append(devs, &Node{Kind: NodeCall, Op: "dev", Args: append([]*Node{k}, chunk...), Pos: pos})
+		sqdevs = append(sqdevs, &Node{Kind: NodeCall, Op: "sqdev", Args: append([]*Node{k}, chunk...), Pos: pos})
+	}
+
+	s1, s2 := devs[0], sqdevs[0]
+	if len(devs) > 1 {
+		s1, s2 = reduceList("sum", devs, pos), reduceList("sum", sqdevs, pos)
+	}
+	two := &Node{Kind: NodeNumber, Value: 2, Text: "2", Pos: pos}
+	spread := &Node{Kind: NodeBinary, Op: "-", Pos: pos, Args: []*Node{
+		{Kind: NodeBinary, Op: "*", Args: []*Node{count, s2}, Pos: pos},
+		{Kind: NodeBinary, Op: "^", Args: []*Node{s1, two}, Pos: pos},
+	}}
+	root := &Node{Kind: NodeCall, Op: "sqrt", Args: []*Node{spread}, Pos: pos}
+	return &Node{Kind: NodeBinary, Op: "/", Args: []*Node{root, count}, Pos: pos}
+}
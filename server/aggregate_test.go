@@ -0,0 +1,127 @@
+package server
+
+import (
+	"math"
+	"testing"
+)
+
+func setListChunkSize(t *testing.T, size int) {
+	t.Helper()
+	saved := listChunkSize
+	listChunkSize = size
+	t.Cleanup(func() { listChunkSize = saved })
+}
+
+func TestAggregateReductionTree(t *testing.T) {
+	setListChunkSize(t, 3)
+
+	root, err := parseExpressionAST("sum([1, 2, 3, 4, 5, 6, 7])")
+	if err != nil {
+		t.Fatalf("❌ Неожиданная ошибка: %v", err)
+	}
+	// 7 значений по 3: sum(1,2,3), sum(4,5,6) и 7, затем их сумма.
+	if got, want := canonical(root), "(sum (sum 1 2 3) (sum 4 5 6) 7)"; got != want {
+		t.Errorf("❌ Ожидалось дерево %s, получено %s", want, got)
+	}
+
+	flat, _ := parseExpressionAST("sum(1, 2, 3)")
+	list, _ := parseExpressionAST("sum([1, 2, 3])")
+	if canonical(flat) != canonical(list) {
+		t.Errorf("❌ sum(1, 2, 3) и sum([1, 2, 3]) должны совпадать: %s и %s", canonical(flat), canonical(list))
+	}
+	// Сложение float не ассоциативно: при другом порядке слагаемых сумма
+	// может округлиться иначе, поэтому ключи различаются.
+	reordered, _ := parseExpressionAST("sum([3, 2, 1])")
+	if canonical(flat) == canonical(reordered) {
+		t.Errorf("❌ sum(1, 2, 3) и sum([3, 2, 1]) не должны совпадать: %s", canonical(flat))
+	}
+
+	avg, _ := parseExpressionAST("avg([x, y])")
+	if got, want := canonical(avg), "(/ (sum x y) 2)"; got != want {
+		t.Errorf("❌ avg: ожидалось %s, получено %s", want, got)
+	}
+}
+
+func TestAggregateEvaluation(t *testing.T) {
+	isolateState(t)
+	setListChunkSize(t, 4)
+
+	tests := []struct {
+		body     string
+		expected float64
+	}{
+		{`{"expression": "sum([1, 2, 3, 4, 5, 6, 7, 8, 9, 10])"}`, 55},
+		{`{"expression": "product([1, 2, 3, 4, 5, 6])"}`, 720},
+		{`{"expression": "avg([x, x * 2, 9])", "variables": {"x": 3}}`, 6},
+		{`{"expression": "stddev([2, 4, 4, 4, 5, 5, 7, 9])"}`, 2},
+		{`{"expression": "stddev([1000000002, 1000000004, 1000000004, 1000000004, 1000000005, 1000000005, 1000000007, 1000000009])"}`, 2},
+		{`{"expression": "sum([1 km, 500 m]) / 1 h"}`, 1500.0 / 3600},
+	}
+	ids := make([]string, len(tests))
+	for i, tt := range tests {
+		ids[i] = responseID(submitBody(tt.body))
+	}
+	runAgent(t)
+
+	for i, tt := range tests {
+		status, result := expressionResult(t, ids[i])
+		if status != StatusDone || math.Abs(result-tt.expected) > 1e-9 {
+			t.Errorf("❌ %s: ожидалось done %v, получено %s %v", tt.body, tt.expected, status, result)
+		}
+	}
+}
+
+func TestAggregateTasksRunInParallel(t *testing.T) {
+	isolateState(t)
+	setListChunkSize(t, 4)
+
+	submitBody(`{"expression": "sum([1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12])"}`)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(tasks) != 3 {
+		t.Fatalf("❌ Ожидалось 3 независимые задачи по 4 значения, в очереди %d", len(tasks))
+	}
+	for _, task := range tasks {
+		if task.Operation != "sum" || len(task.Args) != 4 {
+			t.Errorf("❌ Ожидалась задача sum из 4 значений, получено %s %v", task.Operation, task.Args)
+		}
+	}
+}
+
+func TestAggregateExactMode(t *testing.T) {
+	isolateState(t)
+	setListChunkSize(t, 2)
+
+	id := responseID(submitBody(`{"expression": "sum([0.1, 0.2, 0.3])", "mode": "rational"}`))
+	runAgent(t)
+
+	mutex.Lock()
+	expr := store[id]
+	mutex.Unlock()
+	if expr.Status != StatusDone || expr.Value != "3/5" {
+		t.Errorf("❌ Ожидалось done 3/5, получено %s %q", expr.Status, expr.Value)
+	}
+}
+
+func TestAggregateErrors(t *testing.T) {
+	isolateState(t)
+
+	tests := []struct {
+		body    string
+		message string
+	}{
+		{`{"expression": "sum([])"}`, "пустой список"},
+		{`{"expression": "sum([1, 2)"}`, "ожидалась запятая или закрывающая квадратная скобка"},
+		{`{"expression": "sqdev(1, 2)"}`, "неизвестная функция"},
+		{`{"expression": "sum([1 m, 2 s])"}`, "несовместимые размерности: m и s"},
+		{`{"expression": "stddev([1, 2i])", "mode": "complex"}`, "функция stddev недоступна в режиме complex"},
+		{`{"expression": "sum([[1, 2], 3])"}`, "функция sum не применяется к матрицам"},
+	}
+	for _, tt := range tests {
+		apiErr := decodeAPIError(t, submitBody(tt.body))
+		if apiErr.Code != ErrCodeInvalidExpression || apiErr.Message != tt.message {
+			t.Errorf("❌ %s: ожидалось %q, получено %s %q", tt.body, tt.message, apiErr.Code, apiErr.Message)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/http"
+)
+
+type cacheEntry struct {
+	key   string
+	value Value
+}
+
+// lruCache хранит результаты задач по каноническому ключу подвыражения.
+// Защищён общим mutex сервера.
+type lruCache struct {
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{capacity: capacity, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *lruCache) get(key string) (Value, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		return Value{}, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*cacheEntry).value, true
+}
+
+func (c *lruCache) put(key string, value Value) {
+	if c.capacity <= 0 {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		el.Value.(*cacheEntry).value = value
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry{key: key, value: value})
+	for c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *lruCache) len() int {
+	return c.ll.Len()
+}
+
+type cacheStats struct {
+	Hits         int `json:"hits"`
+	InflightHits int `json:"inflight_hits"`
+	Misses       int `json:"misses"`
+	Deduplicated int `json:"deduplicated"`
+}
+
+var (
+	memo  = newLRUCache(10000)
+	stats cacheStats
+)
+
+func getCacheStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	mutex.Lock()
+	s := stats
+	size, capacity := memo.len(), memo.capacity
+	mutex.Unlock()
+
+	hitRate := 0.0
+	if lookups := s.Hits + s.InflightHits + s.Misses; lookups > 0 {
+		hitRate = float64(s.Hits+s.InflightHits) / float64(lookups)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"size":          size,
+		"capacity":      capacity,
+		"hits":          s.Hits,
+		"inflight_hits": s.InflightHits,
+		"misses":        s.Misses,
+		"deduplicated":  s.Deduplicated,
+		"hit_rate":      hitRate,
+	})
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"math/big"
+	"slices"
+	"strconv"
+)
+
+// opIf — задача условного выражения. Её выполняет сервер: сначала ждёт
+// задачу условия, затем строит задачи только выбранной ветви и ждёт их
+// результата. Невыбранная ветвь агентам не отправляется.
+const opIf = "if"
+
+// pendingBranch — ветви условного выражения, которые ещё не построены.
+type pendingBranch struct {
+	builder   *taskBuilder
+	then      *Node
+	otherwise *Node
+}
+
+// branches: ID задачи if -> её ветви. Защищён mutex.
+var branches = make(map[string]*pendingBranch)
+
+// buildIf вызывается из build. Если условие уже известно (литерал или кэш),
+// задача if не нужна: сразу строится выбранная ветвь.
+func (b *taskBuilder) buildIf(n *Node, key string) (Value, string) {
+	cond, condID := b.build(n.Args[0])
+	if condID == "" {
+		return b.build(chooseBranch(b.mode, cond, n.Args[1], n.Args[2]))
+	}
+
+	task := Task{ID: generateID(), Operation: opIf, Key: key, Arg1Task: condID, Status: TaskWaiting, Unit: b.dims[n].String()}
+	if b.plan {
+		// В плане строятся обе ветви: какая понадобится, станет известно
+		// только после условия.
+		task.ArgTasks = make([]string, 2)
+		_, task.ArgTasks[0] = b.build(n.Args[1])
+		_, task.ArgTasks[1] = b.build(n.Args[2])
+	} else {
+		branches[task.ID] = &pendingBranch{builder: b, then: n.Args[1], otherwise: n.Args[2]}
+	}
+	b.register(key, task.ID)
+	b.add(task)
+	return Value{}, task.ID
+}
+
+func chooseBranch(mode string, cond Value, then, otherwise *Node) *Node {
+	if truthy(mode, cond) {
+		return then
+	}
+	return otherwise
+}
+
+// truthy — истинно ли значение условия. В точных режимах проверяется точное
+// значение: приближение float64 очень малого числа может оказаться нулём.
+func truthy(mode string, v Value) bool {
+	switch {
+	case v.Text == "":
+		return v.Float != 0
+	case mode == ModeComplex:
+		c, _ := strconv.ParseComplex(v.Text, 128)
+		return c != 0
+	default:
+		r, ok := new(big.Rat).SetString(v.Text)
+		return ok && r.Sign() != 0
+	}
+}
+
+// expandBranch вызывается под mutex, когда задача if стала готова: после
+// условия строит выбранную ветвь, после ветви — завершается её значением.
+func expandBranch(exprID string, t Task) {
+	expr := store[exprID]
+	if t.Arg2Task != "" {
+		value := expr.Tasks[taskIndex(expr, t.Arg2Task)].value()
+		resolveTask(exprID, t.ID, value)
+		completeShared(t, value)
+		return
+	}
+
+	pending, ok := branches[t.ID]
+	if !ok {
+		return
+	}
+	delete(branches, t.ID)
+
+	b := pending.builder
+	cond := expr.Tasks[taskIndex(expr, t.Arg1Task)].value()
+	b.tasks = nil
+	value, id := b.build(chooseBranch(b.mode, cond, pending.then, pending.otherwise))
+	if id == "" {
+		resolveTask(exprID, t.ID, value)
+		completeShared(t, value)
+		return
+	}
+
+	// Задачи ветви вставляются перед задачей if, чтобы корневая задача
+	// выражения оставалась последней.
+	i := taskIndex(expr, t.ID)
+	expr.Tasks = slices.Insert(expr.Tasks, i, b.tasks...)
+	ifIndex := i + len(b.tasks)
+	expr.Tasks[ifIndex].Arg2Task = id
+	expr.Tasks[ifIndex].Status = TaskWaiting
+	// Ранги пересчитываются, чтобы учесть задачи ветви, в том числе у копий
+	// задач выражения, которые уже стоят в очереди.
+	rankTasks(expr.Tasks)
+	updateQueuedRanks(expr.Tasks)
+	for _, nt := range expr.Tasks[i:ifIndex] {
+		taskOwner[nt.ID] = exprID
+		if nt.Status == TaskReady {
+			tasks = append(tasks, nt)
+		}
+	}
+	i = ifIndex
+	store[exprID] = expr
+
+	// Ветвь могла совпасть с уже вычисленной задачей выражения.
+	if isReady(expr, expr.Tasks[i]) {
+		expr.Tasks[i].Status = TaskReady
+		store[exprID] = expr
+		expandBranch(exprID, expr.Tasks[i])
+	}
+}
+
+// value возвращает результат выполненной задачи.
+func (t Task) value() Value {
+	v := Value{Text: t.Value, Matrix: t.Matrix}
+	if t.Result != nil {
+		v.Float = *t.Result
+	}
+	return v
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func boolFloat(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func TestComparisonPrecedence(t *testing.T) {
+	tests := []struct {
+		expression string
+		canonical  string
+	}{
+		{"1 + 2 > 2 && 3 < 4", "(&& (< 3 4) (> (+ 1 2) 2))"},
+		{"x == 1 || y != 2 && z >= 3", "(|| (&& (!= 2 y) (>= z 3)) (== 1 x))"},
+		{"-2 ^ 2 <= 2 * 3", "(<= (- 0 (^ 2 2)) (* 2 3))"},
+	}
+	for _, tt := range tests {
+		root, err := parseExpressionAST(tt.expression)
+		if err != nil {
+			t.Errorf("❌ %q: неожиданная ошибка %v", tt.expression, err)
+			continue
+		}
+		if got := canonical(root); got != tt.canonical {
+			t.Errorf("❌ %q: ожидалось %s, получено %s", tt.expression, tt.canonical, got)
+		}
+	}
+
+	if _, err := parseExpressionAST("1 ! 2"); err == nil {
+		t.Errorf("❌ Одиночный ! не является оператором")
+	}
+}
+
+func TestOnlyChosenBranchDispatched(t *testing.T) {
+	isolateState(t)
+
+	tests := []struct {
+		x        float64
+		expected float64
+		skipped  string
+	}{
+		{20, 40, "/"},
+		{4, 2, "*"},
+	}
+	for _, tt := range tests {
+		id := responseID(submitBody(`{"expression": "if(x > 10, x * 2, x / 2)", "variables": {"x": ` + formatNumber(tt.x) + `}}`))
+
+		mutex.Lock()
+		before := len(store[id].Tasks)
+		mutex.Unlock()
+		if before != 2 {
+			t.Fatalf("❌ До вычисления условия должно быть 2 задачи (> и if), получено %d", before)
+		}
+
+		if executed := runAgent(t); executed != 2 {
+			t.Errorf("❌ x=%v: агентам должны уйти только условие и выбранная ветвь, выполнено %d", tt.x, executed)
+		}
+
+		mutex.Lock()
+		expr := store[id]
+		mutex.Unlock()
+		if expr.Status != StatusDone || expr.Result == nil || *expr.Result != tt.expected {
+			t.Fatalf("❌ x=%v: ожидалось done %v, получено %s %v", tt.x, tt.expected, expr.Status, expr.Result)
+		}
+		for _, task := range expr.Tasks {
+			if task.Operation == tt.skipped {
+				t.Errorf("❌ x=%v: невыбранная ветвь %s не должна строиться", tt.x, tt.skipped)
+			}
+		}
+		if last := expr.Tasks[len(expr.Tasks)-1]; last.Operation != opIf {
+			t.Errorf("❌ Корневой должна остаться задача if, получено %s", last.Operation)
+		}
+	}
+	if len(branches) != 0 {
+		t.Errorf("❌ После вычисления не должно остаться отложенных ветвей, осталось %d", len(branches))
+	}
+}
+
+func TestKnownConditionBuildsBranchDirectly(t *testing.T) {
+	isolateState(t)
+
+	id := responseID(submitBody(`{"expression": "if(1 == 1, 2 + 3, 4 * 5)"}`))
+	mutex.Lock()
+	expr := store[id]
+	mutex.Unlock()
+	if len(expr.Tasks) != 2 || expr.Tasks[1].Operation != opIf {
+		t.Fatalf("❌ Ожидались задачи == и if, получено %v", expr.Tasks)
+	}
+
+	// Условие из литералов вычисляется задачей, а условие-литерал — нет.
+	id = responseID(submitBody(`{"expression": "if(c, 2 + 3, 4 * 5)", "variables": {"c": 0}}`))
+	mutex.Lock()
+	expr = store[id]
+	mutex.Unlock()
+	if len(expr.Tasks) != 1 || expr.Tasks[0].Operation != "*" {
+		t.Fatalf("❌ При известном условии должна строиться только ветвь 4 * 5, получено %v", expr.Tasks)
+	}
+}
+
+func TestBranchReusesComputedTask(t *testing.T) {
+	isolateState(t)
+
+	id := responseID(submitBody(`{"expression": "if(x + 1 > 0, x + 1, 0) * 2", "variables": {"x": 3}}`))
+	if executed := runAgent(t); executed != 3 {
+		t.Errorf("❌ x + 1 из условия должна переиспользоваться в ветви: ожидалось 3 задачи, выполнено %d", executed)
+	}
+	if status, result := expressionResult(t, id); status != StatusDone || result != 8 {
+		t.Fatalf("❌ Ожидалось done 8, получено %s %v", status, result)
+	}
+}
+
+func TestCancelDropsPendingBranches(t *testing.T) {
+	isolateState(t)
+
+	id := responseID(submitBody(`{"expression": "if(x > 1, x * 2, x / 2)", "variables": {"x": 3}}`))
+	rr := httptest.NewRecorder()
+	expressionHandler(rr, httptest.NewRequest(http.MethodPost, "/api/v1/expressions/"+id+"/cancel", bytes.NewBufferString("")))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("❌ Не удалось отменить выражение: %d %s", rr.Code, rr.Body.String())
+	}
+	if len(branches) != 0 {
+		t.Errorf("❌ Отмена должна удалять отложенные ветви, осталось %d", len(branches))
+	}
+}
+
+func TestConditionalErrors(t *testing.T) {
+	isolateState(t)
+
+	tests := []struct {
+		body    string
+		message string
+	}{
+		{`{"expression": "if(1 m, 2, 3)"}`, "условие if должно быть безразмерным, получено m"},
+		{`{"expression": "if(x > 1, 2 m, 3 s)", "variables": {"x": 1}}`, "несовместимые размерности ветвей if: m и s"},
+		{`{"expression": "2 m > 3 s"}`, "несовместимые размерности: m и s"},
+		{`{"expression": "1i < 2", "mode": "complex"}`, "операция < недоступна в режиме complex"},
+		{`{"expression": "if(1, 2)"}`, "функция if ожидает аргументов: 3, передано 2"},
+	}
+	for _, tt := range tests {
+		rr := submitBody(tt.body)
+		apiErr := decodeAPIError(t, rr)
+		if apiErr.Code != ErrCodeInvalidExpression || apiErr.Message != tt.message {
+			t.Errorf("❌ %s: ожидалось %q, получено %d %s %q", tt.body, tt.message, rr.Code, apiErr.Code, apiErr.Message)
+		}
+	}
+}
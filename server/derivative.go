@@ -0,0 +1,456 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Производная строится по дереву разбора до подстановки переменных:
+// правила дифференцирования применяются рекурсивно, нулевые и единичные
+// множители и слагаемые убираются сразу, затем результат проходит ту же
+// оптимизацию, что и выражения с optimize. Функции, постоянные на
+// промежутках (round, floor, sign, сравнения и т.п.), имеют производную 0
+// всюду, кроме точек разрыва. Производная stddev не строится: её запись
+// содержала бы служебные функции dev и sqdev.
+
+// Derivative — ответ на запрос производной. Variables — переменные,
+// от которых зависит производная: их значения нужны для вычисления.
+type Derivative struct {
+	Expression string   `json:"expression"`
+	Variable   string   `json:"variable"`
+	Derivative string   `json:"derivative"`
+	Variables  []string `json:"variables"`
+}
+
+func integerNode(v int64, pos int) *Node {
+	return &Node{Kind: NodeNumber, Value: float64(v), Text: strconv.FormatInt(v, 10), Pos: pos}
+}
+
+func isZero(n *Node) bool { return isConstant(n, 0) }
+func isOne(n *Node) bool  { return isConstant(n, 1) }
+
+func binaryNode(op string, a, b *Node) *Node {
+	return &Node{Kind: NodeBinary, Op: op, Args: []*Node{a, b}, Pos: a.Pos}
+}
+
+func callNode(name string, pos int, args ...*Node) *Node {
+	return &Node{Kind: NodeCall, Op: name, Args: args, Pos: pos}
+}
+
+func plus(a, b *Node) *Node {
+	switch {
+	case isZero(a):
+		return b
+	case isZero(b):
+		return a
+	}
+	return binaryNode("+", a, b)
+}
+
+func minus(a, b *Node) *Node {
+	switch {
+	case a == b:
+		return integerNode(0, a.Pos)
+	case isZero(b):
+		return a
+	case isZero(a):
+		return negate(b)
+	}
+	return binaryNode("-", a, b)
+}
+
+// negate строит унарный минус так же, как парсер: у литерала меняется
+// знак, остальное записывается как 0 - x.
+func negate(a *Node) *Node {
+	switch {
+	case isZero(a):
+		return a
+	case isLiteral(a):
+		return &Node{Kind: NodeNumber, Value: -a.Value, Text: negateText(exactText(a)), Dim: a.Dim, Pos: a.Pos}
+	case a.Kind == NodeBinary && isNegation(a):
+		return a.Args[1]
+	}
+	return binaryNode("-", &Node{Kind: NodeNumber, Pos: a.Pos}, a)
+}
+
+func times(a, b *Node) *Node {
+	switch {
+	case isZero(a) || isZero(b):
+		return integerNode(0, a.Pos)
+	case isOne(a):
+		return b
+	case isOne(b):
+		return a
+	case isConstant(a, -1):
+		return negate(b)
+	case isConstant(b, -1):
+		return negate(a)
+	}
+	return binaryNode("*", a, b)
+}
+
+func divide(a, b *Node) *Node {
+	switch {
+	case isZero(a):
+		return a
+	case isOne(b):
+		return a
+	}
+	return binaryNode("/", a, b)
+}
+
+func square(a *Node) *Node {
+	return binaryNode("^", a, integerNode(2, a.Pos))
+}
+
+// dependsOn сообщает, входит ли переменная в поддерево.
+func dependsOn(n *Node, name string) bool {
+	if n.Kind == NodeVariable {
+		return n.Name == name
+	}
+	for _, arg := range n.Args {
+		if dependsOn(arg, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// differentiate возвращает производную дерева по переменной name.
+func differentiate(n *Node, name string) (*Node, error) {
+	if !dependsOn(n, name) {
+		if n.Kind == NodeMatrix {
+			return nil, &ParseError{Message: "производная матричного выражения не поддерживается", Token: "[", Position: n.Pos}
+		}
+		return integerNode(0, n.Pos), nil
+	}
+
+	switch n.Kind {
+	case NodeVariable:
+		return integerNode(1, n.Pos), nil
+	case NodeCall:
+		return differentiateCall(n, name)
+	}
+
+	a, b := n.Args[0], n.Args[1]
+	da, err := differentiate(a, name)
+	if err != nil {
+		return nil, err
+	}
+	db, err := differentiate(b, name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.Op {
+	case "+":
+		return plus(da, db), nil
+	case "-":
+		return minus(da, db), nil
+	case "*":
+		return plus(times(da, b), times(a, db)), nil
+	case "/":
+		if !dependsOn(b, name) {
+			return divide(da, b), nil
+		}
+		return divide(minus(times(da, b), times(a, db)), square(b)), nil
+	case "^":
+		if !dependsOn(b, name) {
+			// (a^b)' = b * a^(b-1) * a'
+			return times(times(b, binaryNode("^", a, minus(b, integerNode(1, b.Pos)))), da), nil
+		}
+		// (a^b)' = a^b * (b' * ln(a) + b * a' / a)
+		return times(n, plus(times(db, callNode("ln", a.Pos, a)), divide(times(b, da), a))), nil
+	case "//", "==", "!=", "<", "<=", ">", ">=", "&&", "||":
+		return integerNode(0, n.Pos), nil
+	}
+	return nil, &ParseError{Message: fmt.Sprintf("производная операции %s не поддерживается", n.Op), Token: n.Op, Position: n.Pos}
+}
+
+// unaryDerivatives — производные функций одного аргумента по этому
+// аргументу; по правилу цепочки они умножаются на производную аргумента.
+var unaryDerivatives = map[string]func(u *Node) *Node{
+	"sqrt": func(u *Node) *Node {
+		return divide(integerNode(1, u.Pos), times(integerNode(2, u.Pos), callNode("sqrt", u.Pos, u)))
+	},
+	"cbrt": func(u *Node) *Node {
+		return divide(integerNode(1, u.Pos), times(integerNode(3, u.Pos), square(callNode("cbrt", u.Pos, u))))
+	},
+	"abs": func(u *Node) *Node { return callNode("sign", u.Pos, u) },
+	"exp": func(u *Node) *Node { return callNode("exp", u.Pos, u) },
+	"ln":  func(u *Node) *Node { return divide(integerNode(1, u.Pos), u) },
+	"log2": func(u *Node) *Node {
+		return divide(integerNode(1, u.Pos), times(u, callNode("ln", u.Pos, integerNode(2, u.Pos))))
+	},
+	"log10": func(u *Node) *Node {
+		return divide(integerNode(1, u.Pos), times(u, callNode("ln", u.Pos, integerNode(10, u.Pos))))
+	},
+	"sin": func(u *Node) *Node { return callNode("cos", u.Pos, u) },
+	"cos": func(u *Node) *Node { return negate(callNode("sin", u.Pos, u)) },
+	"tan": func(u *Node) *Node { return divide(integerNode(1, u.Pos), square(callNode("cos", u.Pos, u))) },
+	"asin": func(u *Node) *Node {
+		return divide(integerNode(1, u.Pos), callNode("sqrt", u.Pos, minus(integerNode(1, u.Pos), square(u))))
+	},
+	"acos": func(u *Node) *Node {
+		return negate(divide(integerNode(1, u.Pos), callNode("sqrt", u.Pos, minus(integerNode(1, u.Pos), square(u)))))
+	},
+	"atan": func(u *Node) *Node { return divide(integerNode(1, u.Pos), plus(integerNode(1, u.Pos), square(u))) },
+	"sinh": func(u *Node) *Node { return callNode("cosh", u.Pos, u) },
+	"cosh": func(u *Node) *Node { return callNode("sinh", u.Pos, u) },
+	"tanh": func(u *Node) *Node { return divide(integerNode(1, u.Pos), square(callNode("cosh", u.Pos, u))) },
+
+	"sign":  func(u *Node) *Node { return integerNode(0, u.Pos) },
+	"round": func(u *Node) *Node { return integerNode(0, u.Pos) },
+	"floor": func(u *Node) *Node { return integerNode(0, u.Pos) },
+	"ceil":  func(u *Node) *Node { return integerNode(0, u.Pos) },
+	"trunc": func(u *Node) *Node { return integerNode(0, u.Pos) },
+}
+
+func differentiateCall(n *Node, name string) (*Node, error) {
+	derivs := make([]*Node, len(n.Args))
+	for i, arg := range n.Args {
+		d, err := differentiate(arg, name)
+		if err != nil {
+			return nil, err
+		}
+		derivs[i] = d
+	}
+
+	if rule, ok := unaryDerivatives[n.Op]; ok && len(n.Args) == 1 {
+		return times(rule(n.Args[0]), derivs[0]), nil
+	}
+
+	switch n.Op {
+	case "log":
+		if len(n.Args) == 1 {
+			return divide(derivs[0], n.Args[0]), nil
+		}
+		// log(u, b) = ln(u) / ln(b)
+		return differentiate(divide(callNode("ln", n.Pos, n.Args[0]), callNode("ln", n.Pos, n.Args[1])), name)
+	case "if":
+		if isZero(derivs[1]) && isZero(derivs[2]) {
+			return integerNode(0, n.Pos), nil
+		}
+		return callNode("if", n.Pos, n.Args[0], derivs[1], derivs[2]), nil
+	case "min", "max":
+		// max(a, b, ...)' = if(a >= max(b, ...), a', max(b, ...)')
+		if len(n.Args) == 1 {
+			return derivs[0], nil
+		}
+		rest := n.Args[1]
+		if len(n.Args) > 2 {
+			rest = callNode(n.Op, n.Pos, n.Args[1:]...)
+		}
+		dRest, err := differentiate(rest, name)
+		if err != nil {
+			return nil, err
+		}
+		cmp := ">="
+		if n.Op == "min" {
+			cmp = "<="
+		}
+		return callNode("if", n.Pos, binaryNode(cmp, n.Args[0], rest), derivs[0], dRest), nil
+	case "hypot":
+		// hypot(a, b)' = (a * a' + b * b') / hypot(a, b)
+		return divide(plus(times(n.Args[0], derivs[0]), times(n.Args[1], derivs[1])), n), nil
+	case "atan2":
+		// atan2(y, x)' = (x * y' - y * x') / (x^2 + y^2)
+		y, x := n.Args[0], n.Args[1]
+		return divide(minus(times(x, derivs[0]), times(y, derivs[1])), plus(square(x), square(y))), nil
+	case "sum":
+		return sumOf(n.Pos, derivs), nil
+	case "product":
+		// Производная произведения — сумма произведений, в каждом из
+		// которых один множитель заменён его производной.
+		terms := make([]*Node, len(n.Args))
+		for i := range n.Args {
+			factors := append(append([]*Node{}, n.Args[:i]...), derivs[i])
+			factors = append(factors, n.Args[i+1:]...)
+			terms[i] = productOf(n.Pos, factors)
+		}
+		return sumOf(n.Pos, terms), nil
+	}
+	return nil, unsupportedFunction(n)
+}
+
+func unsupportedFunction(n *Node) error {
+	name := publicName(n.Op)
+	return &ParseError{Message: fmt.Sprintf("производная функции %s не поддерживается", name), Token: name, Position: n.Pos}
+}
+
+// checkPublicFunctions отклоняет производную, в которой остались служебные
+// функции разложения stddev: такую запись нельзя отправить как выражение.
+// Стандартное отклонение литералов к этому моменту уже свёрнуто.
+func checkPublicFunctions(n *Node) error {
+	if n.Kind == NodeCall && functions[n.Op].internal != "" {
+		return unsupportedFunction(n)
+	}
+	for _, arg := range n.Args {
+		if err := checkPublicFunctions(arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sumOf складывает ненулевые слагаемые одним вызовом sum.
+func sumOf(pos int, terms []*Node) *Node {
+	var nonzero []*Node
+	for _, t := range terms {
+		if !isZero(t) {
+			nonzero = append(nonzero, t)
+		}
+	}
+	switch len(nonzero) {
+	case 0:
+		return integerNode(0, pos)
+	case 1:
+		return nonzero[0]
+	}
+	return callNode("sum", pos, nonzero...)
+}
+
+// productOf перемножает множители одним вызовом product, опуская единицы.
+func productOf(pos int, factors []*Node) *Node {
+	var rest []*Node
+	for _, f := range factors {
+		if isZero(f) {
+			return integerNode(0, pos)
+		}
+		if !isOne(f) {
+			rest = append(rest, f)
+		}
+	}
+	switch len(rest) {
+	case 0:
+		return integerNode(1, pos)
+	case 1:
+		return rest[0]
+	}
+	return callNode("product", pos, rest...)
+}
+
+func derivativeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	var req struct {
+		Expression  string    `json:"expression"`
+		Variable    string    `json:"variable"`
+		Variables   Variables `json:"variables"`
+		Mode        string    `json:"mode"`
+		Precision   int       `json:"precision"`
+		CallbackURL string    `json:"callback_url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJSON, "некорректный JSON", map[string]interface{}{"reason": err.Error()})
+		return
+	}
+	if v, err := parseExpressionAST(req.Variable); err != nil || v.Kind != NodeVariable {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidVariables, "переменная дифференцирования должна быть именем переменной",
+			map[string]interface{}{"variable": req.Variable})
+		return
+	}
+	if err := validateVariables(req.Variables); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidVariables, err.Error(), nil)
+		return
+	}
+	mode, precision, err := parseNumericMode(req.Mode, req.Precision)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidMode, err.Error(), nil)
+		return
+	}
+	if req.CallbackURL != "" {
+		if err := validateCallbackURL(req.CallbackURL); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidCallback, err.Error(), nil)
+			return
+		}
+	}
+
+	// Исходное выражение проверяется без подстановки: переменные безразмерны.
+	parsed, err := parseExpressionAST(req.Expression)
+	if err == nil {
+		err = checkModeFunctions(parsed, mode)
+	}
+	if err == nil {
+		err = checkModeLiterals(parsed, mode)
+	}
+	if err == nil {
+		_, err = inferShapes(parsed)
+	}
+	if err == nil {
+		_, err = inferDimensions(parsed)
+	}
+	var derived *Node
+	if err == nil {
+		derived, err = differentiate(parsed, req.Variable)
+	}
+	if err == nil {
+		derived, _ = optimize(derived, mode)
+		err = checkModeFunctions(derived, mode)
+	}
+	if err == nil {
+		err = checkPublicFunctions(derived)
+	}
+	if err != nil {
+		writeExpressionError(w, err)
+		return
+	}
+
+	result := Derivative{
+		Expression: req.Expression,
+		Variable:   req.Variable,
+		Derivative: formatExpression(derived),
+		Variables:  collectVariables(derived),
+	}
+	if req.Variables == nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"derivative": result})
+		return
+	}
+
+	// Вычисление производной — обычное выражение в общей очереди.
+	root, err := bindVariables(derived, req.Variables, nil)
+	if err == nil {
+		err = checkModeLiterals(root, mode)
+	}
+	var dims map[*Node]dimension
+	if err == nil {
+		dims, err = inferDimensions(root)
+	}
+	if err != nil {
+		writeExpressionError(w, err)
+		return
+	}
+	root, unit, err := applyResultUnit(root, dims[root], "", mode)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidUnit, err.Error(), nil)
+		return
+	}
+
+	id := generateID()
+	mutex.Lock()
+	startExpression(Expression{
+		ID:     id,
+		Expr:   result.Derivative,
+		Status: StatusQueued,
+
+		Mode:      mode,
+		Precision: precision,
+		Unit:      unit,
+		Variables: req.Variables,
+
+		CreatedAt:   time.Now(),
+		CallbackURL: req.CallbackURL,
+	}, root)
+	mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"derivative": result, "id": id})
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func derivativeBody(body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	derivativeHandler(rr, httptest.NewRequest(http.MethodPost, "/api/v1/derivative", bytes.NewBufferString(body)))
+	return rr
+}
+
+func TestDifferentiateRules(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x ^ 2", "2 * x"},
+		{"x ^ 3 + 2 * x + 1", "3 * x ^ 2 + 2"},
+		{"x * y", "y"},
+		{"y", "0"},
+		{"sin(x) * x", "cos(x) * x + sin(x)"},
+		{"cos(x)", "-sin(x)"},
+		{"1 / x", "-1 / x ^ 2"},
+		{"exp(2 * x)", "exp(2 * x) * 2"},
+		{"log(x, 2)", "1 / x / ln(2)"},
+		{"2 ^ x", "2 ^ x * ln(2)"},
+		{"-x ^ 2", "-(2 * x)"},
+		{"max(x, 2)", "if(x >= 2, 1, 0)"},
+		{"if(x > 0, x ^ 2, -x)", "if(x > 0, 2 * x, -1)"},
+		{"sum([x, x ^ 2, 3])", "sum(1, 2 * x)"},
+		{"avg([x, 2 * x, 3])", "1"},
+		{"product([x, 2, 3])", "6"},
+		{"cos(-x)", "sin(-x)"},
+		{"x * stddev([1, 2, 3])", "sqrt(6) / 3"},
+		{"floor(x) + x", "1"},
+	}
+	for _, tt := range tests {
+		root, err := parseExpressionAST(tt.input)
+		if err != nil {
+			t.Fatalf("❌ %s: неожиданная ошибка %v", tt.input, err)
+		}
+		derived, err := differentiate(root, "x")
+		if err != nil {
+			t.Fatalf("❌ %s: неожиданная ошибка %v", tt.input, err)
+		}
+		derived, _ = optimize(derived, ModeFloat)
+		if got := formatExpression(derived); got != tt.expected {
+			t.Errorf("❌ (%s)': ожидалось %q, получено %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestDerivativeEndpoint(t *testing.T) {
+	isolateState(t)
+
+	rr := derivativeBody(`{"expression": "x ^ 2 * y + z", "variable": "x"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("❌ Ожидался статус 200, получен %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Derivative Derivative `json:"derivative"`
+		ID         string     `json:"id"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Derivative.Derivative != "2 * x * y" || !reflect.DeepEqual(resp.Derivative.Variables, []string{"x", "y"}) || resp.ID != "" {
+		t.Errorf("❌ Неожиданный ответ %+v", resp)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(store) != 0 || len(tasks) != 0 {
+		t.Errorf("❌ Без variables производная не должна вычисляться: выражений %d, задач %d", len(store), len(tasks))
+	}
+}
+
+func TestDerivativeEvaluation(t *testing.T) {
+	isolateState(t)
+
+	tests := []struct {
+		body     string
+		expected float64
+	}{
+		{`{"expression": "x ^ 3 + sqrt(x)", "variable": "x", "variables": {"x": 4}}`, 48.25},
+		{`{"expression": "a * b / (a + b)", "variable": "a", "variables": {"a": 1, "b": 3}}`, 9.0 / 16},
+		{`{"expression": "avg([x, x ^ 2])", "variable": "x", "variables": {"x": 3}}`, 3.5},
+	}
+	ids := make([]string, len(tests))
+	for i, tt := range tests {
+		rr := derivativeBody(tt.body)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("❌ %s: ожидался статус 201, получен %d: %s", tt.body, rr.Code, rr.Body.String())
+		}
+		ids[i] = responseID(rr)
+	}
+	runAgent(t)
+
+	for i, tt := range tests {
+		status, result := expressionResult(t, ids[i])
+		if status != StatusDone || math.Abs(result-tt.expected) > 1e-9 {
+			t.Errorf("❌ %s: ожидалось done %v, получено %s %v", tt.body, tt.expected, status, result)
+		}
+	}
+}
+
+func TestDerivativeErrors(t *testing.T) {
+	isolateState(t)
+
+	tests := []struct {
+		body    string
+		code    string
+		message string
+	}{
+		{`{"expression": "x ^ 2", "variable": ""}`, ErrCodeInvalidVariables, "переменная дифференцирования должна быть именем переменной"},
+		{`{"expression": "x ^ 2", "variable": "2 * x"}`, ErrCodeInvalidVariables, "переменная дифференцирования должна быть именем переменной"},
+		{`{"expression": "x % 3", "variable": "x"}`, ErrCodeInvalidExpression, "производная операции % не поддерживается"},
+		{`{"expression": "re(x)", "variable": "x", "mode": "complex"}`, ErrCodeInvalidExpression, "производная функции re не поддерживается"},
+		{`{"expression": "stddev([x, 2, 3])", "variable": "x"}`, ErrCodeInvalidExpression, "производная функции stddev не поддерживается"},
+		{`{"expression": "y * stddev([x, 2])", "variable": "y"}`, ErrCodeInvalidExpression, "производная функции stddev не поддерживается"},
+		{`{"expression": "[[1, 2]] * x", "variable": "x"}`, ErrCodeInvalidExpression, "производная матричного выражения не поддерживается"},
+		{`{"expression": "x + 1 m", "variable": "x"}`, ErrCodeInvalidExpression, "несовместимые размерности: безразмерная величина и m"},
+		{`{"expression": "x * y", "variable": "x", "variables": {"x": 1}}`, ErrCodeInvalidExpression, "не заданы значения переменных: y"},
+	}
+	for _, tt := range tests {
+		apiErr := decodeAPIError(t, derivativeBody(tt.body))
+		if apiErr.Code != tt.code || apiErr.Message != tt.message {
+			t.Errorf("❌ %s: ожидалось %s %q, получено %s %q", tt.body, tt.code, tt.message, apiErr.Code, apiErr.Message)
+		}
+	}
+}
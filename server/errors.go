@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	ErrCodeMethodNotAllowed      = "method_not_allowed"
+	ErrCodeInvalidJSON           = "invalid_json"
+	ErrCodeInvalidExpression     = "invalid_expression"
+	ErrCodeInvalidCallback       = "invalid_callback_url"
+	ErrCodeInvalidVariables      = "invalid_variables"
+	ErrCodeInvalidMode           = "invalid_numeric_mode"
+	ErrCodeInvalidUnit           = "invalid_unit"
+	ErrCodeInvalidQuery          = "invalid_query"
+	ErrCodeInvalidIdempotencyKey = "invalid_idempotency_key"
+	ErrCodeIdempotencyConflict   = "idempotency_key_reused"
+	ErrCodeInvalidFormulaName    = "invalid_formula_name"
+	ErrCodeFormulaExists         = "formula_exists"
+	ErrCodeBatchTooLarge         = "batch_too_large"
+	ErrCodeExpressionNotFound    = "expression_not_found"
+	ErrCodeFormulaNotFound       = "formula_not_found"
+	ErrCodeTaskNotFound          = "task_not_found"
+	ErrCodeNoTasks               = "no_tasks_available"
+	ErrCodeInvalidTransition     = "invalid_state_transition"
+	ErrCodeInvalidResult         = "invalid_result"
+	ErrCodeStreamingUnsupported  = "streaming_unsupported"
+)
+
+type APIError struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// ParseError описывает ошибку разбора выражения с позицией токена.
+// Position — смещение в символах от начала выражения, -1 если неприменимо.
+type ParseError struct {
+	Message  string
+	Token    string
+	Position int
+}
+
+func (e *ParseError) Error() string {
+	if e.Position < 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%s (позиция %d)", e.Message, e.Position)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string, details map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]APIError{
+		"error": {Code: code, Message: message, Details: details},
+	})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed,
+		"метод не поддерживается", map[string]interface{}{"method": r.Method})
+}
+
+func writeExpressionError(w http.ResponseWriter, err error) {
+	message, details := describeExpressionError(err)
+	writeError(w, http.StatusBadRequest, ErrCodeInvalidExpression, message, details)
+}
+
+// describeExpressionError возвращает сообщение и подробности ошибки
+// разбора или подстановки переменных.
+func describeExpressionError(err error) (string, map[string]interface{}) {
+	var unbound *UnboundVariablesError
+	if errors.As(err, &unbound) {
+		return unbound.Error(), map[string]interface{}{
+			"variables": unbound.Names,
+			"token":     unbound.Token,
+			"position":  unbound.Position,
+		}
+	}
+
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		return err.Error(), nil
+	}
+
+	details := map[string]interface{}{}
+	if perr.Token != "" {
+		details["token"] = perr.Token
+	}
+	if perr.Position >= 0 {
+		details["position"] = perr.Position
+	}
+	if len(details) == 0 {
+		details = nil
+	}
+	return perr.Message, details
+}
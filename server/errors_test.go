@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func decodeAPIError(t *testing.T, rr *httptest.ResponseRecorder) APIError {
+	t.Helper()
+
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Ожидался Content-Type application/json, получен %q", ct)
+	}
+	var body struct {
+		Error APIError `json:"error"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("Ответ не является JSON-ошибкой: %v", err)
+	}
+	return body.Error
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		path    string
+		body    string
+		status  int
+		code    string
+	}{
+		{"calculate wrong method", addExpression, http.MethodGet, "/api/v1/calculate", "", http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+		{"calculate invalid json", addExpression, http.MethodPost, "/api/v1/calculate", "{", http.StatusBadRequest, ErrCodeInvalidJSON},
+		{"calculate invalid expression", addExpression, http.MethodPost, "/api/v1/calculate", `{"expression": "2 + x"}`, http.StatusBadRequest, ErrCodeInvalidExpression},
+		{"calculate invalid callback", addExpression, http.MethodPost, "/api/v1/calculate", `{"expression": "2 + 2", "callback_url": "nope"}`, http.StatusBadRequest, ErrCodeInvalidCallback},
+		{"expression not found", getExpression, http.MethodGet, "/api/v1/expressions/unknown", "", http.StatusNotFound, ErrCodeExpressionNotFound},
+		{"deliveries not found", expressionHandler, http.MethodGet, "/api/v1/expressions/unknown/deliveries", "", http.StatusNotFound, ErrCodeExpressionNotFound},
+		{"task wrong method", internalTaskHandler, http.MethodPut, "/internal/task", "", http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+		{"task invalid json", completeTask, http.MethodPost, "/internal/task", "{", http.StatusUnprocessableEntity, ErrCodeInvalidJSON},
+		{"task not found", completeTask, http.MethodPost, "/internal/task", `{"id": "no_such_task", "result": 1}`, http.StatusNotFound, ErrCodeTaskNotFound},
+		{"events wrong method", getAllEvents, http.MethodPost, "/api/v1/events", "", http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+		rr := httptest.NewRecorder()
+
+		tt.handler(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("❌ [%s] Ожидался статус %d, но получен %d", tt.name, tt.status, rr.Code)
+			continue
+		}
+		apiErr := decodeAPIError(t, rr)
+		if apiErr.Code != tt.code || apiErr.Message == "" {
+			t.Errorf("❌ [%s] Ожидался код %s, получено %+v", tt.name, tt.code, apiErr)
+		}
+	}
+
+	fmt.Println("[TestErrorEnvelope] прошел успешно!")
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	tests := []struct {
+		expression string
+		token      string
+		position   float64
+	}{
+		{"2 + abc", "abc", 4},
+		{"10 / 0", "/", 3},
+		{"1  *  * 2", "*", 6},
+		{"1 + 2 -", "-", 6},
+		{"√ + 1", "√", 0},
+		{"π + 1 +", "+", 6},
+	}
+
+	for _, tt := range tests {
+		body := fmt.Sprintf(`{"expression": %q}`, tt.expression)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBufferString(body))
+		rr := httptest.NewRecorder()
+
+		addExpression(rr, req)
+
+		apiErr := decodeAPIError(t, rr)
+		if apiErr.Details["token"] != tt.token || apiErr.Details["position"] != tt.position {
+			t.Errorf("❌ %q: ожидался токен %q на позиции %v, получено %+v", tt.expression, tt.token, tt.position, apiErr.Details)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EventExpressionCreated = "expression_created"
+	EventTaskDispatched    = "task_dispatched"
+	EventTaskCompleted     = "task_completed"
+	// EventExpressionFinished — выражение перешло в конечный статус;
+	// итог (done, error, cancelled, timeout) передаётся в поле Status.
+	EventExpressionFinished = "expression_finished"
+	EventSnapshot           = "snapshot"
+)
+
+type Event struct {
+	Seq          uint64      `json:"seq"`
+	Type         string      `json:"type"`
+	ExpressionID string      `json:"expression_id"`
+	TaskID       string      `json:"task_id,omitempty"`
+	Status       string      `json:"status,omitempty"`
+	Result       *float64    `json:"result,omitempty"`
+	Value        string      `json:"value,omitempty"`
+	Matrix       [][]float64 `json:"matrix,omitempty"`
+	Time         string      `json:"time"`
+}
+
+type subscriber struct {
+	exprID string
+	ch     chan Event
+}
+
+type eventBroker struct {
+	mu          sync.Mutex
+	seq         uint64
+	subscribers map[*subscriber]struct{}
+}
+
+var events = &eventBroker{subscribers: make(map[*subscriber]struct{})}
+
+// subscribe регистрирует слушателя; пустой exprID означает все выражения.
+func (b *eventBroker) subscribe(exprID string) *subscriber {
+	sub := &subscriber{exprID: exprID, ch: make(chan Event, 64)}
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+func (b *eventBroker) unsubscribe(sub *subscriber) {
+	b.mu.Lock()
+	delete(b.subscribers, sub)
+	b.mu.Unlock()
+}
+
+// publish не блокируется: медленный слушатель теряет события, а не тормозит сервер.
+func (b *eventBroker) publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	e.Seq = b.seq
+	if e.Time == "" {
+		e.Time = time.Now().Format(time.RFC3339Nano)
+	}
+
+	for sub := range b.subscribers {
+		if sub.exprID != "" && sub.exprID != e.ExpressionID {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			fmt.Printf("Слушатель событий не успевает, событие %d пропущено\n", e.Seq)
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, data)
+	return err
+}
+
+func streamEvents(w http.ResponseWriter, r *http.Request, exprID string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, ErrCodeStreamingUnsupported, "потоковая передача не поддерживается", nil)
+		return
+	}
+
+	sub := events.subscribe(exprID)
+	defer events.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if exprID != "" {
+		mutex.Lock()
+		expr := store[exprID]
+		mutex.Unlock()
+
+		snapshot := Event{Type: EventSnapshot, ExpressionID: expr.ID, Status: string(expr.Status), Result: expr.Result, Value: expr.Value, Matrix: expr.Matrix}
+		if err := writeEvent(w, snapshot); err != nil {
+			return
+		}
+		if expr.Status.IsTerminal() {
+			flusher.Flush()
+			return
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-sub.ch:
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+			flusher.Flush()
+			if exprID != "" && (e.Type == EventExpressionFinished) {
+				return
+			}
+		}
+	}
+}
+
+func getAllEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+	streamEvents(w, r, "")
+}
+
+func getExpressionEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/expressions/"), "/events")
+	mutex.Lock()
+	_, exists := store[id]
+	mutex.Unlock()
+	if !exists {
+		writeError(w, http.StatusNotFound, ErrCodeExpressionNotFound, "выражение не найдено", map[string]interface{}{"id": id})
+		return
+	}
+
+	streamEvents(w, r, id)
+}
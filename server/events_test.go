@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readEvents(t *testing.T, scanner *bufio.Scanner, n int) []Event {
+	t.Helper()
+
+	var received []Event
+	for len(received) < n && scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+			t.Fatalf("Ошибка декодирования события %q: %v", line, err)
+		}
+		received = append(received, e)
+	}
+	return received
+}
+
+func TestGlobalEventStream(t *testing.T) {
+	testName := "TestGlobalEventStream"
+
+	srv := httptest.NewServer(http.HandlerFunc(getAllEvents))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("❌ [%s] Ошибка подключения к потоку: %v", testName, err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("❌ [%s] Ожидался Content-Type text/event-stream, получен %s", testName, ct)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBufferString(`{"expression": "1 + 1"}`))
+	rr := httptest.NewRecorder()
+	addExpression(rr, req)
+
+	var created map[string]string
+	json.NewDecoder(rr.Body).Decode(&created)
+
+	received := readEvents(t, bufio.NewScanner(resp.Body), 1)
+	if len(received) != 1 {
+		t.Fatalf("❌ [%s] Событие не получено", testName)
+	}
+	if received[0].Type != EventExpressionCreated || received[0].ExpressionID != created["id"] {
+		t.Fatalf("❌ [%s] Получено неожиданное событие: %+v", testName, received[0])
+	}
+
+	fmt.Printf("[%s] прошел успешно!\n", testName)
+}
+
+func TestExpressionEventStream(t *testing.T) {
+	testName := "TestExpressionEventStream"
+
+	mutex.Lock()
+	saveNewExpression(Expression{
+		ID:     "sse_expr",
+		Expr:   "7 - 2",
+		Status: StatusQueued,
+		Tasks:  []Task{{ID: "sse_task", Arg1: 7, Arg2: 2, Operation: "-", Status: TaskDispatched}},
+	})
+	mutex.Unlock()
+
+	srv := httptest.NewServer(http.HandlerFunc(expressionHandler))
+	defer srv.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(srv.URL + "/api/v1/expressions/sse_expr/events")
+	if err != nil {
+		t.Fatalf("❌ [%s] Ошибка подключения к потоку: %v", testName, err)
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	snapshot := readEvents(t, scanner, 1)
+	if len(snapshot) != 1 || snapshot[0].Type != EventSnapshot || snapshot[0].Status != string(StatusQueued) {
+		t.Fatalf("❌ [%s] Ожидался снимок состояния, получено %+v", testName, snapshot)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBufferString(`{"id": "sse_task", "result": 5}`))
+	rr := httptest.NewRecorder()
+	completeTask(rr, req)
+
+	received := readEvents(t, scanner, 2)
+	if len(received) != 2 {
+		t.Fatalf("❌ [%s] Ожидалось 2 события, получено %d", testName, len(received))
+	}
+	if received[0].Type != EventTaskCompleted || received[0].TaskID != "sse_task" {
+		t.Fatalf("❌ [%s] Ожидалось %s, получено %+v", testName, EventTaskCompleted, received[0])
+	}
+	if received[1].Type != EventExpressionFinished || received[1].Status != string(StatusDone) || received[1].Result == nil || *received[1].Result != 5 {
+		t.Fatalf("❌ [%s] Ожидалось %s со статусом done и результатом 5, получено %+v", testName, EventExpressionFinished, received[1])
+	}
+
+	if rest := readEvents(t, scanner, 1); len(rest) != 0 {
+		t.Fatalf("❌ [%s] Поток должен закрыться после завершения выражения", testName)
+	}
+
+	fmt.Printf("[%s] прошел успешно!\n", testName)
+}
+
+func TestExpressionEventStreamNotFound(t *testing.T) {
+	testName := "TestExpressionEventStreamNotFound"
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/missing/events", nil)
+	rr := httptest.NewRecorder()
+
+	expressionHandler(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("❌ [%s] Ожидался статус %d, но получен %d", testName, http.StatusNotFound, rr.Code)
+	}
+
+	fmt.Printf("[%s] прошел успешно!\n", testName)
+}
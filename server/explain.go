@@ -0,0 +1,290 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Explain разбирает выражение и строит план его выполнения, ничего не ставя
+// в очередь: дерево разбора, граф задач и оценку времени по задержкам
+// агентов TIME_*_MS. План строится без учёта кэша и задач других выражений,
+// то есть для выполнения с нуля.
+
+// astNode — узел дерева разбора в ответе explain.
+type astNode struct {
+	Type   string      `json:"type"`
+	Op     string      `json:"op,omitempty"`
+	Name   string      `json:"name,omitempty"`
+	Value  *float64    `json:"value,omitempty"`
+	Text   string      `json:"text,omitempty"`
+	Imag   bool        `json:"imag,omitempty"`
+	Unit   string      `json:"unit,omitempty"`
+	Matrix [][]float64 `json:"matrix,omitempty"`
+	Args   []astNode   `json:"args,omitempty"`
+}
+
+var nodeTypes = map[NodeKind]string{
+	NodeNumber:   "number",
+	NodeBinary:   "binary",
+	NodeCall:     "call",
+	NodeVariable: "variable",
+	NodeMatrix:   "matrix",
+}
+
+func describeNode(n *Node) astNode {
+	out := astNode{Type: nodeTypes[n.Kind], Op: n.Op, Name: n.Name, Matrix: n.Matrix}
+	if n.Kind == NodeNumber {
+		value := n.Value
+		out.Value, out.Text, out.Imag, out.Unit = &value, exactText(n), n.Imag, n.Unit
+	}
+	for _, arg := range n.Args {
+		out.Args = append(out.Args, describeNode(arg))
+	}
+	return out
+}
+
+// planTask — задача плана. ID в плане — порядковые t1, t2, ...: настоящие
+// идентификаторы задачи получат только при отправке выражения. Start и
+// Finish отсчитываются от начала выполнения при неограниченном числе
+// агентов.
+type planTask struct {
+	ID        string       `json:"id"`
+	Operation string       `json:"operation"`
+	Executor  string       `json:"executor"`
+	DependsOn []string     `json:"depends_on,omitempty"`
+	Branches  []string     `json:"branches,omitempty"`
+	Block     *MatrixBlock `json:"block,omitempty"`
+	Unit      string       `json:"unit,omitempty"`
+	Duration  int          `json:"duration_ms"`
+	Start     int          `json:"start_ms"`
+	Finish    int          `json:"finish_ms"`
+	Critical  bool         `json:"critical,omitempty"`
+}
+
+// Plan — ответ explain. EstimatedMs — длина критического пути, то есть
+// время выполнения при достаточном числе агентов; TotalWorkMs — сумма
+// времени всех задач агентов, включая обе ветви условий. При optimize
+// Optimized — выражение после оптимизации, Optimizations — выполненные
+// преобразования.
+type Plan struct {
+	Expression    string     `json:"expression"`
+	Mode          string     `json:"mode"`
+	Precision     int        `json:"precision,omitempty"`
+	Unit          string     `json:"unit,omitempty"`
+	AST           astNode    `json:"ast"`
+	Optimized     string     `json:"optimized,omitempty"`
+	Optimizations []string   `json:"optimizations,omitempty"`
+	Tasks         []planTask `json:"tasks"`
+	CriticalPath  []string   `json:"critical_path"`
+	EstimatedMs   int        `json:"estimated_ms"`
+	TotalWorkMs   int        `json:"total_work_ms"`
+}
+
+// operationDelay — время задачи на агенте. Читаются те же переменные
+// окружения с теми же значениями по умолчанию, что и у агента.
+func operationDelay(op string) int {
+	switch op {
+	case "+":
+		return getEnvInt("TIME_ADDITION_MS", 500)
+	case "-":
+		return getEnvInt("TIME_SUBTRACTION_MS", 500)
+	case "*":
+		return getEnvInt("TIME_MULTIPLICATIONS_MS", 700)
+	case "/":
+		return getEnvInt("TIME_DIVISIONS_MS", 1000)
+	case "//":
+		return getEnvInt("TIME_INTEGER_DIVISIONS_MS", 1000)
+	case "%":
+		return getEnvInt("TIME_MODULO_MS", 1000)
+	case "^":
+		return getEnvInt("TIME_EXPONENTIATION_MS", 1200)
+	}
+	switch {
+	case op == opIf || op == opAssemble:
+		return 0
+	case isMatrixOperation(op):
+		return getEnvInt("TIME_MATRIX_MS", 1000)
+	case binaryOps[op].prec > 0:
+		return getEnvInt("TIME_COMPARISONS_MS", 300)
+	}
+	common := getEnvInt("TIME_FUNCTIONS_MS", 800)
+	if functions[op].complexOnly {
+		return common
+	}
+	return getEnvInt("TIME_FUNC_"+strings.ToUpper(op)+"_MS", common)
+}
+
+// explainTasks строит план выполнения выражения с уже проверенным деревом.
+func explainTasks(expr Expression, root *Node) []planTask {
+	b := newTaskBuilder(expr, root)
+	b.plan = true
+	if _, id := b.build(root); id == "" {
+		return []planTask{}
+	}
+	b.tasks[len(b.tasks)-1].Unit = expr.Unit
+
+	ids := make(map[string]string, len(b.tasks))
+	index := make(map[string]int, len(b.tasks))
+	for i, t := range b.tasks {
+		ids[t.ID] = "t" + strconv.Itoa(i+1)
+		index[t.ID] = i
+	}
+
+	// Задачи ветвей условия начинаются не раньше, чем вычислено условие.
+	gate := make(map[int]int)
+	for _, t := range b.tasks {
+		if t.Operation != opIf {
+			continue
+		}
+		cond := index[t.Arg1Task]
+		for _, branch := range t.ArgTasks {
+			if branch != "" {
+				gateSubtree(b.tasks, index, index[branch], cond, gate)
+			}
+		}
+	}
+
+	plan := make([]planTask, len(b.tasks))
+	pred := make([]int, len(b.tasks))
+	for i, t := range b.tasks {
+		p := planTask{ID: ids[t.ID], Operation: t.Operation, Executor: "agent", Block: t.Block, Unit: t.Unit, Duration: operationDelay(t.Operation)}
+		if isServerTask(t) {
+			p.Executor = "server"
+		}
+		// pred — задача, которая завершается последней среди тех, чьего
+		// результата ждёт эта; по ним восстанавливается критический путь.
+		pred[i] = -1
+		if cond, ok := gate[i]; ok {
+			p.Start, pred[i] = plan[cond].Finish, cond
+		}
+		for _, dep := range t.dependencies() {
+			j := index[dep]
+			if t.Operation == opIf && dep != t.Arg1Task {
+				p.Branches = append(p.Branches, ids[dep])
+			} else {
+				p.DependsOn = append(p.DependsOn, ids[dep])
+			}
+			if pred[i] < 0 || plan[j].Finish > p.Start {
+				p.Start, pred[i] = plan[j].Finish, j
+			}
+		}
+		p.Finish = p.Start + p.Duration
+		plan[i] = p
+	}
+
+	for i := len(plan) - 1; i >= 0; i = pred[i] {
+		plan[i].Critical = true
+	}
+	return plan
+}
+
+// gateSubtree отмечает задачи ветви, которые не нужны для условия cond:
+// они будут построены только после него.
+func gateSubtree(all []Task, index map[string]int, i, cond int, gate map[int]int) {
+	if prev, ok := gate[i]; i <= cond || (ok && prev >= cond) {
+		return
+	}
+	gate[i] = cond
+	for _, dep := range all[i].dependencies() {
+		gateSubtree(all, index, index[dep], cond, gate)
+	}
+}
+
+func explainExpression(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	var req struct {
+		Expression string                 `json:"expression"`
+		Variables  Variables              `json:"variables"`
+		Matrices   map[string][][]float64 `json:"matrices"`
+		Mode       string                 `json:"mode"`
+		Precision  int                    `json:"precision"`
+		Unit       string                 `json:"unit"`
+		Optimize   bool                   `json:"optimize"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJSON, "некорректный JSON", map[string]interface{}{"reason": err.Error()})
+		return
+	}
+
+	err := validateVariables(req.Variables)
+	if err == nil {
+		err = validateMatrices(req.Matrices, req.Variables)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidVariables, err.Error(), nil)
+		return
+	}
+	mode, precision, err := parseNumericMode(req.Mode, req.Precision)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidMode, err.Error(), nil)
+		return
+	}
+
+	parsed, err := parseExpressionAST(req.Expression)
+	if err == nil {
+		err = checkModeFunctions(parsed, mode)
+	}
+	var root *Node
+	if err == nil {
+		root, err = bindVariables(parsed, req.Variables, req.Matrices)
+	}
+	if err == nil {
+		err = checkModeLiterals(root, mode)
+	}
+	if err == nil {
+		_, err = inferShapes(root)
+	}
+	var dims map[*Node]dimension
+	if err == nil {
+		dims, err = inferDimensions(root)
+	}
+	if err != nil {
+		writeExpressionError(w, err)
+		return
+	}
+	root, unit, err := applyResultUnit(root, dims[root], req.Unit, mode)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidUnit, err.Error(), map[string]interface{}{"unit": req.Unit})
+		return
+	}
+
+	var optimizations []string
+	if req.Optimize {
+		root, optimizations = optimize(root, mode)
+	}
+
+	tasks := explainTasks(Expression{Mode: mode, Precision: precision, Unit: unit}, root)
+	plan := Plan{
+		Expression:    req.Expression,
+		Mode:          mode,
+		Precision:     precision,
+		Unit:          unit,
+		AST:           describeNode(parsed),
+		Optimizations: optimizations,
+		Tasks:         tasks,
+		CriticalPath:  []string{},
+	}
+	if req.Optimize {
+		plan.Optimized = formatExpression(root)
+	}
+	for _, t := range tasks {
+		if t.Critical {
+			plan.CriticalPath = append(plan.CriticalPath, t.ID)
+		}
+		if t.Executor == "agent" {
+			plan.TotalWorkMs += t.Duration
+		}
+	}
+	if len(tasks) > 0 {
+		plan.EstimatedMs = tasks[len(tasks)-1].Finish
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"plan": plan})
+}
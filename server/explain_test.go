@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func explainBody(t *testing.T, body string) Plan {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	explainExpression(rr, httptest.NewRequest(http.MethodPost, "/api/v1/explain", bytes.NewBufferString(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("❌ Ожидался статус 200, получен %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Plan Plan `json:"plan"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("❌ Некорректный ответ: %v", err)
+	}
+	return resp.Plan
+}
+
+func setDelays(t *testing.T) {
+	t.Setenv("TIME_ADDITION_MS", "100")
+	t.Setenv("TIME_MULTIPLICATIONS_MS", "300")
+	t.Setenv("TIME_DIVISIONS_MS", "500")
+	t.Setenv("TIME_COMPARISONS_MS", "50")
+	t.Setenv("TIME_FUNCTIONS_MS", "1000")
+	t.Setenv("TIME_FUNC_SQRT_MS", "200")
+}
+
+func TestExplainCriticalPath(t *testing.T) {
+	isolateState(t)
+	setDelays(t)
+
+	plan := explainBody(t, `{"expression": "(a + b) * (a + b) + sqrt(c) / 2", "variables": {"a": 1, "b": 2, "c": 9}}`)
+
+	// t1 = a+b (повторное вхождение переиспользуется), t2 = t1*t1,
+	// t3 = sqrt(c), t4 = t3/2, t5 = t2+t4.
+	expected := []planTask{
+		{ID: "t1", Operation: "+", Executor: "agent", Duration: 100, Start: 0, Finish: 100},
+		{ID: "t2", Operation: "*", Executor: "agent", DependsOn: []string{"t1", "t1"}, Duration: 300, Start: 100, Finish: 400},
+		{ID: "t3", Operation: "sqrt", Executor: "agent", Duration: 200, Start: 0, Finish: 200, Critical: true},
+		{ID: "t4", Operation: "/", Executor: "agent", DependsOn: []string{"t3"}, Duration: 500, Start: 200, Finish: 700, Critical: true},
+		{ID: "t5", Operation: "+", Executor: "agent", DependsOn: []string{"t2", "t4"}, Duration: 100, Start: 700, Finish: 800, Critical: true},
+	}
+	if !reflect.DeepEqual(plan.Tasks, expected) {
+		t.Fatalf("❌ Неожиданный план:\n%+v\nожидалось:\n%+v", plan.Tasks, expected)
+	}
+	if plan.EstimatedMs != 800 || plan.TotalWorkMs != 1200 {
+		t.Errorf("❌ Ожидалось 800 мс по критическому пути и 1200 мс работы, получено %d и %d", plan.EstimatedMs, plan.TotalWorkMs)
+	}
+	if !reflect.DeepEqual(plan.CriticalPath, []string{"t3", "t4", "t5"}) {
+		t.Errorf("❌ Неожиданный критический путь %v", plan.CriticalPath)
+	}
+	if plan.AST.Type != "binary" || plan.AST.Op != "+" || plan.AST.Args[0].Args[0].Args[0].Name != "a" {
+		t.Errorf("❌ В дереве разбора должны остаться имена переменных, получено %+v", plan.AST)
+	}
+}
+
+func TestExplainDoesNotEnqueue(t *testing.T) {
+	isolateState(t)
+
+	explainBody(t, `{"expression": "2 + 3 * 4"}`)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(store) != 0 || len(tasks) != 0 || len(inflight) != 0 || stats != (cacheStats{}) {
+		t.Errorf("❌ Explain не должен менять состояние: выражений %d, задач %d, в работе %d, статистика %+v",
+			len(store), len(tasks), len(inflight), stats)
+	}
+}
+
+func TestExplainConditionalWaitsForCondition(t *testing.T) {
+	isolateState(t)
+	setDelays(t)
+
+	plan := explainBody(t, `{"expression": "if(x > 1, x * 2, x / 2)", "variables": {"x": 5}}`)
+	if len(plan.Tasks) != 4 {
+		t.Fatalf("❌ Ожидалось 4 задачи (условие, две ветви, if), получено %+v", plan.Tasks)
+	}
+	cond, then, otherwise, branch := plan.Tasks[0], plan.Tasks[1], plan.Tasks[2], plan.Tasks[3]
+	if then.Start != cond.Finish || otherwise.Start != cond.Finish {
+		t.Errorf("❌ Ветви должны начинаться после условия: %+v %+v", then, otherwise)
+	}
+	if branch.Executor != "server" || !reflect.DeepEqual(branch.DependsOn, []string{"t1"}) || !reflect.DeepEqual(branch.Branches, []string{"t2", "t3"}) {
+		t.Errorf("❌ Неожиданная задача if: %+v", branch)
+	}
+	if plan.EstimatedMs != 550 || !reflect.DeepEqual(plan.CriticalPath, []string{"t1", "t3", "t4"}) {
+		t.Errorf("❌ Оценка должна идти по более долгой ветви: %d %v", plan.EstimatedMs, plan.CriticalPath)
+	}
+}
+
+func TestExplainMatrixBlocks(t *testing.T) {
+	isolateState(t)
+	setDelays(t)
+	t.Setenv("TIME_MATRIX_MS", "400")
+	saved := matrixBlockSize
+	matrixBlockSize = 1
+	t.Cleanup(func() { matrixBlockSize = saved })
+
+	plan := explainBody(t, `{"expression": "[[1, 2], [3, 4]] * [[5, 6], [7, 8]]"}`)
+	if len(plan.Tasks) != 5 || plan.Tasks[4].Operation != opAssemble || plan.Tasks[0].Block == nil {
+		t.Fatalf("❌ Ожидались 4 блока и сборка, получено %+v", plan.Tasks)
+	}
+	if plan.EstimatedMs != 400 || plan.TotalWorkMs != 1600 {
+		t.Errorf("❌ Блоки выполняются параллельно: ожидалось 400 и 1600 мс, получено %d и %d", plan.EstimatedMs, plan.TotalWorkMs)
+	}
+}
+
+func TestExplainErrors(t *testing.T) {
+	isolateState(t)
+
+	tests := []struct {
+		body string
+		code string
+	}{
+		{`{"expression": "2 +"}`, ErrCodeInvalidExpression},
+		{`{"expression": "x + 1"}`, ErrCodeInvalidExpression},
+		{`{"expression": "1 + 2", "mode": "octal"}`, ErrCodeInvalidMode},
+		{`{"expression": "5 km / 2 h", "unit": "kg"}`, ErrCodeInvalidUnit},
+		{`{"expression": `, ErrCodeInvalidJSON},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		explainExpression(rr, httptest.NewRequest(http.MethodPost, "/api/v1/explain", bytes.NewBufferString(tt.body)))
+		if apiErr := decodeAPIError(t, rr); apiErr.Code != tt.code {
+			t.Errorf("❌ %s: ожидался код %s, получен %d %s", tt.body, tt.code, rr.Code, apiErr.Code)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import "strings"
+
+// formatExpression записывает дерево в инфиксной форме с минимумом скобок.
+// Разность с нулевым левым аргументом, которую парсер строит для унарного
+// минуса, записывается как -x. Числа с единицами измерения записываются
+// в СИ без единицы, подставленные значения переменных — их именами.
+func formatExpression(n *Node) string {
+	var sb strings.Builder
+	writeNode(&sb, n)
+	return sb.String()
+}
+
+// nodePrec — приоритет узла при записи: у чисел, переменных и вызовов он
+// выше любого оператора, у унарного минуса и отрицательного числа — unaryPrec.
+func nodePrec(n *Node) int {
+	switch {
+	case n.Kind == NodeBinary && isNegation(n):
+		return unaryPrec
+	case n.Kind == NodeBinary:
+		return binaryOps[n.Op].prec
+	case n.Kind == NodeNumber && n.Name == "" && strings.HasPrefix(exactText(n), "-"):
+		return unaryPrec
+	}
+	return unaryPrec + 2
+}
+
+func isNegation(n *Node) bool {
+	zero := n.Args[0]
+	return n.Op == "-" && zero.Kind == NodeNumber && zero.Value == 0 && zero.Text == "" && zero.Name == "" && !zero.Imag
+}
+
+func writeNode(sb *strings.Builder, n *Node) {
+	switch {
+	case n.Name != "":
+		sb.WriteString(n.Name)
+	case n.Kind == NodeNumber:
+		sb.WriteString(exactText(n))
+	case n.Kind == NodeMatrix:
+		sb.WriteString("[")
+		for i, row := range n.Matrix {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString("[")
+			for j, v := range row {
+				if j > 0 {
+					sb.WriteString(", ")
+				}
+				sb.WriteString(formatNumber(v))
+			}
+			sb.WriteString("]")
+		}
+		sb.WriteString("]")
+	case n.Kind == NodeCall:
+		sb.WriteString(n.Op + "(")
+		for i, arg := range n.Args {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			writeNode(sb, arg)
+		}
+		sb.WriteString(")")
+	case n.Kind == NodeBinary:
+		if isNegation(n) {
+			sb.WriteString("-")
+			writeOperand(sb, n.Args[1], nodePrec(n.Args[1]) <= unaryPrec)
+			return
+		}
+		info := binaryOps[n.Op]
+		left, right := nodePrec(n.Args[0]), nodePrec(n.Args[1])
+		writeOperand(sb, n.Args[0], left < info.prec || (left == info.prec && info.rightAssoc))
+		sb.WriteString(" " + n.Op + " ")
+		writeOperand(sb, n.Args[1], right < info.prec || (right == info.prec && !info.rightAssoc))
+	}
+}
+
+func writeOperand(sb *strings.Builder, n *Node, parens bool) {
+	if parens {
+		sb.WriteString("(")
+	}
+	writeNode(sb, n)
+	if parens {
+		sb.WriteString(")")
+	}
+}
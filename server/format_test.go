@@ -0,0 +1,38 @@
+package server
+
+import "testing"
+
+func TestFormatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"2 + 3 * 4", "2 + 3 * 4"},
+		{"(2 + 3) * 4", "(2 + 3) * 4"},
+		{"2 - (3 - 4)", "2 - (3 - 4)"},
+		{"(2 - 3) - 4", "2 - 3 - 4"},
+		{"2 ^ 3 ^ 2", "2 ^ 3 ^ 2"},
+		{"(2 ^ 3) ^ 2", "(2 ^ 3) ^ 2"},
+		{"-x ^ 2", "-x ^ 2"},
+		{"(-x) ^ 2", "(-x) ^ 2"},
+		{"-(a + b)", "-(a + b)"},
+		{"(-2) ^ 2", "(-2) ^ 2"},
+		{"max(a, b + 1) / [[1, 2]]", "max(a, b + 1) / [[1, 2]]"},
+		{"a < b && c || d", "a < b && c || d"},
+	}
+	for _, tt := range tests {
+		root, err := parseExpressionAST(tt.input)
+		if err != nil {
+			t.Fatalf("❌ %s: неожиданная ошибка %v", tt.input, err)
+		}
+		got := formatExpression(root)
+		if got != tt.expected {
+			t.Errorf("❌ %s: ожидалось %q, получено %q", tt.input, tt.expected, got)
+		}
+		// Запись должна разбираться в то же дерево.
+		again, err := parseExpressionAST(got)
+		if err != nil || canonical(again) != canonical(root) {
+			t.Errorf("❌ %s: запись %q разбирается иначе: %v", tt.input, got, err)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Formula — сохранённое выражение с переменными, которое вычисляется
+// с разными значениями без повторного разбора.
+type Formula struct {
+	Name       string    `json:"name"`
+	Expression string    `json:"expression"`
+	Variables  []string  `json:"variables"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	root *Node
+}
+
+var (
+	// formulas: имя -> формула. Защищён mutex.
+	formulas          = make(map[string]Formula)
+	formulaBatchLimit int
+)
+
+var formulaNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// collectVariables возвращает отсортированные имена переменных дерева.
+func collectVariables(n *Node) []string {
+	seen := make(map[string]bool)
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if n.Kind == NodeVariable {
+			seen[n.Name] = true
+		}
+		for _, arg := range n.Args {
+			walk(arg)
+		}
+	}
+	walk(n)
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func formulasHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listFormulas(w, r)
+	case http.MethodPost:
+		createFormula(w, r)
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+func formulaHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/api/v1/formulas/")
+	if strings.HasSuffix(name, "/evaluate") {
+		if r.Method != http.MethodPost {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+		evaluateFormula(w, r, strings.TrimSuffix(name, "/evaluate"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		getFormula(w, name)
+	case http.MethodDelete:
+		deleteFormula(w, name)
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+func createFormula(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name       string `json:"name"`
+		Expression string `json:"expression"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJSON, "некорректный JSON", map[string]interface{}{"reason": err.Error()})
+		return
+	}
+	if !formulaNamePattern.MatchString(req.Name) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidFormulaName,
+			"имя формулы должно состоять из 1–64 латинских букв, цифр и символов _ . -", map[string]interface{}{"name": req.Name})
+		return
+	}
+
+	root, err := parseExpressionAST(req.Expression)
+	if err != nil {
+		writeExpressionError(w, err)
+		return
+	}
+
+	formula := Formula{
+		Name:       req.Name,
+		Expression: req.Expression,
+		Variables:  collectVariables(root),
+		CreatedAt:  time.Now(),
+		root:       root,
+	}
+
+	mutex.Lock()
+	if _, exists := formulas[req.Name]; exists {
+		mutex.Unlock()
+		writeError(w, http.StatusConflict, ErrCodeFormulaExists, "формула с таким именем уже существует", map[string]interface{}{"name": req.Name})
+		return
+	}
+	formulas[req.Name] = formula
+	mutex.Unlock()
+
+	fmt.Printf("Сохранена формула %s: %s\n", formula.Name, formula.Expression)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]Formula{"formula": formula})
+}
+
+func listFormulas(w http.ResponseWriter, r *http.Request) {
+	mutex.Lock()
+	list := make([]Formula, 0, len(formulas))
+	for _, f := range formulas {
+		list = append(list, f)
+	}
+	mutex.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]Formula{"formulas": list})
+}
+
+func getFormula(w http.ResponseWriter, name string) {
+	mutex.Lock()
+	formula, exists := formulas[name]
+	mutex.Unlock()
+	if !exists {
+		writeError(w, http.StatusNotFound, ErrCodeFormulaNotFound, "формула не найдена", map[string]interface{}{"name": name})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]Formula{"formula": formula})
+}
+
+func deleteFormula(w http.ResponseWriter, name string) {
+	mutex.Lock()
+	_, exists := formulas[name]
+	delete(formulas, name)
+	mutex.Unlock()
+	if !exists {
+		writeError(w, http.StatusNotFound, ErrCodeFormulaNotFound, "формула не найдена", map[string]interface{}{"name": name})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// evaluateFormula создаёт выражения по формуле: одно для "variables" или по
+// одному на каждый набор из "bindings"; матрицы из "matrices" общие для всех
+// наборов. Пакет принимается целиком: если хотя бы один набор некорректен,
+// не создаётся ни одного выражения.
+func evaluateFormula(w http.ResponseWriter, r *http.Request, name string) {
+	var req struct {
+		Variables   Variables              `json:"variables"`
+		Bindings    []Variables            `json:"bindings"`
+		Matrices    map[string][][]float64 `json:"matrices"`
+		Mode        string                 `json:"mode"`
+		Precision   int                    `json:"precision"`
+		Unit        string                 `json:"unit"`
+		Optimize    bool                   `json:"optimize"`
+		CallbackURL string                 `json:"callback_url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJSON, "некорректный JSON", map[string]interface{}{"reason": err.Error()})
+		return
+	}
+	mode, precision, err := parseNumericMode(req.Mode, req.Precision)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidMode, err.Error(), nil)
+		return
+	}
+	if req.CallbackURL != "" {
+		if err := validateCallbackURL(req.CallbackURL); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidCallback, err.Error(), nil)
+			return
+		}
+	}
+
+	bulk := req.Bindings != nil
+	bindings := req.Bindings
+	if !bulk {
+		bindings = []Variables{req.Variables}
+	}
+	if len(bindings) == 0 || len(bindings) > formulaBatchLimit {
+		writeError(w, http.StatusBadRequest, ErrCodeBatchTooLarge,
+			fmt.Sprintf("пакет должен содержать от 1 до %d наборов переменных", formulaBatchLimit),
+			map[string]interface{}{"size": len(bindings), "limit": formulaBatchLimit})
+		return
+	}
+
+	mutex.Lock()
+	formula, exists := formulas[name]
+	mutex.Unlock()
+	if !exists {
+		writeError(w, http.StatusNotFound, ErrCodeFormulaNotFound, "формула не найдена", map[string]interface{}{"name": name})
+		return
+	}
+	if err := checkModeFunctions(formula.root, mode); err != nil {
+		writeExpressionError(w, err)
+		return
+	}
+
+	roots := make([]*Node, len(bindings))
+	resultUnits := make([]string, len(bindings))
+	for i, vars := range bindings {
+		err := validateVariables(vars)
+		if err == nil {
+			err = validateMatrices(req.Matrices, vars)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidVariables, err.Error(), map[string]interface{}{"index": i})
+			return
+		}
+		roots[i], err = bindVariables(formula.root, vars, req.Matrices)
+		if err == nil {
+			err = checkModeLiterals(roots[i], mode)
+		}
+		if err == nil {
+			_, err = inferShapes(roots[i])
+		}
+		var dims map[*Node]dimension
+		if err == nil {
+			dims, err = inferDimensions(roots[i])
+		}
+		if err != nil {
+			message, details := describeExpressionError(err)
+			if details == nil {
+				details = map[string]interface{}{}
+			}
+			details["index"] = i
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidExpression, message, details)
+			return
+		}
+		if roots[i], resultUnits[i], err = applyResultUnit(roots[i], dims[roots[i]], req.Unit, mode); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidUnit, err.Error(), map[string]interface{}{"unit": req.Unit, "index": i})
+			return
+		}
+		if req.Optimize {
+			roots[i], _ = optimize(roots[i], mode)
+		}
+	}
+
+	now := time.Now()
+	ids := make([]string, len(bindings))
+	mutex.Lock()
+	for i, vars := range bindings {
+		ids[i] = generateID()
+		startExpression(Expression{
+			ID:     ids[i],
+			Expr:   formula.Expression,
+			Status: StatusQueued,
+
+			Mode:      mode,
+			Precision: precision,
+			Optimize:  req.Optimize,
+			Unit:      resultUnits[i],
+			Formula:   formula.Name,
+			Variables: vars,
+			Matrices:  req.Matrices,
+
+			CreatedAt:   now,
+			CallbackURL: req.CallbackURL,
+		}, roots[i])
+	}
+	mutex.Unlock()
+
+	fmt.Printf("По формуле %s создано выражений: %d\n", name, len(ids))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if bulk {
+		json.NewEncoder(w).Encode(map[string][]string{"ids": ids})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id": ids[0]})
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func formulaRequest(method, path, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	if path == "/api/v1/formulas" {
+		formulasHandler(rr, req)
+	} else {
+		formulaHandler(rr, req)
+	}
+	return rr
+}
+
+func TestCreateFormula(t *testing.T) {
+	isolateState(t)
+
+	rr := formulaRequest(http.MethodPost, "/api/v1/formulas", `{"name": "total", "expression": "price * (1 + tax) - discount"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("❌ Ожидался статус %d, получен %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Formula Formula `json:"formula"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if want := []string{"discount", "price", "tax"}; !reflect.DeepEqual(resp.Formula.Variables, want) {
+		t.Errorf("❌ Ожидались переменные %v, получено %v", want, resp.Formula.Variables)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"duplicate", `{"name": "total", "expression": "a + b"}`, http.StatusConflict, ErrCodeFormulaExists},
+		{"bad name", `{"name": "has space", "expression": "a + b"}`, http.StatusBadRequest, ErrCodeInvalidFormulaName},
+		{"bad expression", `{"name": "broken", "expression": "a +"}`, http.StatusBadRequest, ErrCodeInvalidExpression},
+	}
+	for _, tt := range tests {
+		rr := formulaRequest(http.MethodPost, "/api/v1/formulas", tt.body)
+		if rr.Code != tt.status {
+			t.Errorf("❌ [%s] Ожидался статус %d, получен %d", tt.name, tt.status, rr.Code)
+			continue
+		}
+		if apiErr := decodeAPIError(t, rr); apiErr.Code != tt.code {
+			t.Errorf("❌ [%s] Ожидался код %s, получено %+v", tt.name, tt.code, apiErr)
+		}
+	}
+
+	if rr := formulaRequest(http.MethodGet, "/api/v1/formulas/total", ""); rr.Code != http.StatusOK {
+		t.Errorf("❌ Формула должна быть доступна, получен статус %d", rr.Code)
+	}
+	if rr := formulaRequest(http.MethodDelete, "/api/v1/formulas/total", ""); rr.Code != http.StatusNoContent {
+		t.Errorf("❌ Удаление: ожидался статус %d, получен %d", http.StatusNoContent, rr.Code)
+	}
+	if rr := formulaRequest(http.MethodGet, "/api/v1/formulas/total", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("❌ Удалённая формула: ожидался статус %d, получен %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestEvaluateFormula(t *testing.T) {
+	isolateState(t)
+
+	formulaRequest(http.MethodPost, "/api/v1/formulas", `{"name": "area", "expression": "w * h + margin"}`)
+
+	rr := formulaRequest(http.MethodPost, "/api/v1/formulas/area/evaluate", `{"variables": {"w": 3, "h": 4, "margin": 1}}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("❌ Ожидался статус %d, получен %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	single := responseID(rr)
+
+	rr = formulaRequest(http.MethodPost, "/api/v1/formulas/area/evaluate",
+		`{"bindings": [{"w": 1, "h": 2, "margin": 0}, {"w": 5, "h": 5, "margin": 0.5}]}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("❌ Пакет: ожидался статус %d, получен %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var bulk struct {
+		IDs []string `json:"ids"`
+	}
+	json.NewDecoder(rr.Body).Decode(&bulk)
+	if len(bulk.IDs) != 2 {
+		t.Fatalf("❌ Ожидалось 2 выражения, получено %v", bulk.IDs)
+	}
+
+	runAgent(t)
+
+	for id, want := range map[string]float64{single: 13, bulk.IDs[0]: 2, bulk.IDs[1]: 25.5} {
+		if status, result := expressionResult(t, id); status != StatusDone || result != want {
+			t.Errorf("❌ Выражение %s: ожидалось done %v, получено %s %v", id, want, status, result)
+		}
+	}
+
+	mutex.Lock()
+	formula := store[single].Formula
+	mutex.Unlock()
+	if formula != "area" {
+		t.Errorf("❌ В записи выражения должно быть имя формулы, получено %q", formula)
+	}
+
+	// Целые больше 2^53 подставляются без округления.
+	exact := responseID(formulaRequest(http.MethodPost, "/api/v1/formulas/area/evaluate",
+		`{"mode": "bigint", "variables": {"w": 9007199254740993, "h": 1, "margin": 0}}`))
+	runAgent(t)
+	mutex.Lock()
+	value := store[exact].Value
+	mutex.Unlock()
+	if value != "9007199254740993" {
+		t.Errorf("❌ Ожидалось 9007199254740993, получено %q", value)
+	}
+}
+
+func TestEvaluateFormulaBatchIsAtomic(t *testing.T) {
+	isolateState(t)
+
+	formulaRequest(http.MethodPost, "/api/v1/formulas", `{"name": "ratio", "expression": "a / b"}`)
+
+	rr := formulaRequest(http.MethodPost, "/api/v1/formulas/ratio/evaluate",
+		`{"bindings": [{"a": 1, "b": 2}, {"a": 1}]}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("❌ Ожидался статус %d, получен %d", http.StatusBadRequest, rr.Code)
+	}
+	if apiErr := decodeAPIError(t, rr); apiErr.Details["index"] != float64(1) {
+		t.Errorf("❌ Ожидался индекс некорректного набора 1, получено %v", apiErr.Details)
+	}
+
+	mutex.Lock()
+	count := len(store)
+	mutex.Unlock()
+	if count != 0 {
+		t.Fatalf("❌ При ошибке в пакете не должно создаваться выражений, создано %d", count)
+	}
+
+	if rr := formulaRequest(http.MethodPost, "/api/v1/formulas/missing/evaluate", `{"variables": {}}`); rr.Code != http.StatusNotFound {
+		t.Errorf("❌ Неизвестная формула: ожидался статус %d, получен %d", http.StatusNotFound, rr.Code)
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import "fmt"
+
+// funcInfo описывает встроенную функцию для разбора выражения. Сами функции
+// вычисляют агенты, сервер проверяет только имя и число аргументов.
+type funcInfo struct {
+	minArgs int
+	// maxArgs < 0 означает произвольное число аргументов.
+	maxArgs int
+	// commutative — порядок аргументов не влияет на результат. У sum и
+	// product его нет: в float от порядка зависит округление свёртки.
+	commutative bool
+	// complexOnly — функция имеет смысл только в режиме complex.
+	complexOnly bool
+	// matrix — аргумент функции — матрица (см. matrix.go).
+	matrix bool
+	// aggregate — функция над списком, при разборе она заменяется
+	// деревом свёртки (см. aggregate.go).
+	aggregate bool
+	// internal — имя агрегатной функции, для которой сервер строит эту
+	// служебную функцию. В выражении служебная функция недоступна.
+	internal string
+}
+
+var functions = map[string]funcInfo{
+	"sqrt":  {minArgs: 1, maxArgs: 1},
+	"cbrt":  {minArgs: 1, maxArgs: 1},
+	"abs":   {minArgs: 1, maxArgs: 1},
+	"sign":  {minArgs: 1, maxArgs: 1},
+	"round": {minArgs: 1, maxArgs: 1},
+	"floor": {minArgs: 1, maxArgs: 1},
+	"ceil":  {minArgs: 1, maxArgs: 1},
+	"trunc": {minArgs: 1, maxArgs: 1},
+	"exp":   {minArgs: 1, maxArgs: 1},
+	"ln":    {minArgs: 1, maxArgs: 1},
+	"log":   {minArgs: 1, maxArgs: 2},
+	"log2":  {minArgs: 1, maxArgs: 1},
+	"log10": {minArgs: 1, maxArgs: 1},
+	"sin":   {minArgs: 1, maxArgs: 1},
+	"cos":   {minArgs: 1, maxArgs: 1},
+	"tan":   {minArgs: 1, maxArgs: 1},
+	"asin":  {minArgs: 1, maxArgs: 1},
+	"acos":  {minArgs: 1, maxArgs: 1},
+	"atan":  {minArgs: 1, maxArgs: 1},
+	"atan2": {minArgs: 2, maxArgs: 2},
+	"sinh":  {minArgs: 1, maxArgs: 1},
+	"cosh":  {minArgs: 1, maxArgs: 1},
+	"tanh":  {minArgs: 1, maxArgs: 1},
+	"hypot": {minArgs: 2, maxArgs: 2, commutative: true},
+	"min":   {minArgs: 1, maxArgs: -1, commutative: true},
+	"max":   {minArgs: 1, maxArgs: -1, commutative: true},
+	"conj":  {minArgs: 1, maxArgs: 1, complexOnly: true},
+	"re":    {minArgs: 1, maxArgs: 1, complexOnly: true},
+	"im":    {minArgs: 1, maxArgs: 1, complexOnly: true},
+	"arg":   {minArgs: 1, maxArgs: 1, complexOnly: true},
+
+	"transpose": {minArgs: 1, maxArgs: 1, matrix: true},
+
+	"sum":     {minArgs: 1, maxArgs: -1, aggregate: true},
+	"product": {minArgs: 1, maxArgs: -1, aggregate: true},
+	"avg":     {minArgs: 1, maxArgs: -1, aggregate: true},
+	"stddev":  {minArgs: 1, maxArgs: -1, aggregate: true},
+	// dev(k, x...) = Σ(x-k), sqdev(k, x...) = Σ(x-k)².
+	"dev":   {minArgs: 2, maxArgs: -1, internal: "stddev"},
+	"sqdev": {minArgs: 2, maxArgs: -1, internal: "stddev"},
+
+	// if(условие, то, иначе) вычисляет сервер: агентам уходит только
+	// выбранная ветвь (см. conditional.go).
+	"if": {minArgs: 3, maxArgs: 3},
+}
+
+// checkArity возвращает ошибку, если функции передано неверное число аргументов.
+func (f funcInfo) checkArity(name string, n int) error {
+	if n >= f.minArgs && (f.maxArgs < 0 || n <= f.maxArgs) {
+		return nil
+	}
+
+	var expected string
+	switch {
+	case f.maxArgs < 0:
+		expected = fmt.Sprintf("не меньше %d", f.minArgs)
+	case f.minArgs == f.maxArgs:
+		expected = fmt.Sprintf("%d", f.minArgs)
+	default:
+		expected = fmt.Sprintf("от %d до %d", f.minArgs, f.maxArgs)
+	}
+	return fmt.Errorf("функция %s ожидает аргументов: %s, передано %d", name, expected, n)
+}
+
+// publicName — имя функции для сообщений об ошибках: у служебной функции
+// это имя агрегатной функции, из которой она построена.
+func publicName(name string) string {
+	if origin := functions[name].internal; origin != "" {
+		return origin
+	}
+	return name
+}
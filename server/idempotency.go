@@ -0,0 +1,62 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+const maxIdempotencyKeyLength = 255
+
+type idempotencyRecord struct {
+	exprID      string
+	fingerprint string
+	createdAt   time.Time
+}
+
+var (
+	idempotencyWindow time.Duration
+	// idempotencyKeys: значение заголовка Idempotency-Key -> исходная отправка. Защищён mutex.
+	idempotencyKeys = make(map[string]idempotencyRecord)
+)
+
+func validateIdempotencyKey(key string) error {
+	if len(key) > maxIdempotencyKeyLength {
+		return fmt.Errorf("Idempotency-Key не может быть длиннее %d символов", maxIdempotencyKeyLength)
+	}
+	return nil
+}
+
+// requestFingerprint позволяет отличить повтор запроса от другого запроса
+// с тем же ключом.
+func requestFingerprint(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// lookupIdempotent вызывается под mutex. Возвращает ID ранее созданного
+// выражения, если ключ ещё действует, или ошибку, если ключ уже использован
+// для другого запроса.
+func lookupIdempotent(key, fingerprint string, now time.Time) (string, error) {
+	record, ok := idempotencyKeys[key]
+	if !ok {
+		return "", nil
+	}
+	if _, exists := store[record.exprID]; !exists || now.Sub(record.createdAt) > idempotencyWindow {
+		delete(idempotencyKeys, key)
+		return "", nil
+	}
+	if record.fingerprint != fingerprint {
+		return "", fmt.Errorf("Idempotency-Key уже использован для другого запроса")
+	}
+	return record.exprID, nil
+}
+
+func rememberIdempotent(key, fingerprint, exprID string, now time.Time) {
+	idempotencyKeys[key] = idempotencyRecord{exprID: exprID, fingerprint: fingerprint, createdAt: now}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func submitWithKey(expression, key string) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"expression": %q}`, expression)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBufferString(body))
+	req.Header.Set("Idempotency-Key", key)
+	rr := httptest.NewRecorder()
+	addExpression(rr, req)
+	return rr
+}
+
+func responseID(rr *httptest.ResponseRecorder) string {
+	var resp map[string]string
+	json.NewDecoder(rr.Body).Decode(&resp)
+	return resp["id"]
+}
+
+func TestIdempotentSubmission(t *testing.T) {
+	testName := "TestIdempotentSubmission"
+	isolateState(t)
+
+	first := submitWithKey("9 - 4", "retry-key-1")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("❌ [%s] Ожидался статус %d, но получен %d", testName, http.StatusCreated, first.Code)
+	}
+	id := responseID(first)
+
+	second := submitWithKey("9 - 4", "retry-key-1")
+	if second.Code != http.StatusOK || second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("❌ [%s] Повтор должен вернуть %d, получен %d", testName, http.StatusOK, second.Code)
+	}
+	if replayed := responseID(second); replayed != id {
+		t.Fatalf("❌ [%s] Повтор вернул другой ID: %s вместо %s", testName, replayed, id)
+	}
+
+	mutex.Lock()
+	count, key := len(store), store[id].IdempotencyKey
+	mutex.Unlock()
+	if count != 1 || key != "retry-key-1" {
+		t.Fatalf("❌ [%s] Ожидалось одно выражение с ключом, получено %d выражений, ключ %q", testName, count, key)
+	}
+
+	conflict := submitWithKey("9 - 5", "retry-key-1")
+	if conflict.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("❌ [%s] Другой запрос с тем же ключом: ожидался статус %d, получен %d", testName, http.StatusUnprocessableEntity, conflict.Code)
+	}
+
+	if other := submitWithKey("9 - 4", "retry-key-2"); other.Code != http.StatusCreated || responseID(other) == id {
+		t.Fatalf("❌ [%s] Новый ключ должен создать новое выражение", testName)
+	}
+
+	fmt.Printf("[%s] прошел успешно!\n", testName)
+}
+
+func TestIdempotencyWindowExpires(t *testing.T) {
+	isolateState(t)
+
+	original := idempotencyWindow
+	idempotencyWindow = time.Hour
+	defer func() { idempotencyWindow = original }()
+
+	id := responseID(submitWithKey("1 * 8", "expiring"))
+
+	mutex.Lock()
+	record := idempotencyKeys["expiring"]
+	record.createdAt = record.createdAt.Add(-2 * time.Hour)
+	idempotencyKeys["expiring"] = record
+	mutex.Unlock()
+
+	rr := submitWithKey("1 * 8", "expiring")
+	if rr.Code != http.StatusCreated || responseID(rr) == id {
+		t.Fatalf("❌ После истечения окна ключ должен создать новое выражение, получен статус %d", rr.Code)
+	}
+}
+
+func TestIdempotentConcurrentRetries(t *testing.T) {
+	isolateState(t)
+
+	const n = 50
+	ids := make(chan string, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids <- responseID(submitWithKey("3 * 3", "concurrent-key"))
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	first := <-ids
+	for id := range ids {
+		if id != first {
+			t.Fatalf("❌ Параллельные повторы получили разные ID: %s и %s", first, id)
+		}
+	}
+}
+
+func TestIdempotencyKeyTooLong(t *testing.T) {
+	rr := submitWithKey("1 + 1", strings.Repeat("k", maxIdempotencyKeyLength+1))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("❌ Ожидался статус %d, но получен %d", http.StatusBadRequest, rr.Code)
+	}
+}
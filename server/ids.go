@@ -0,0 +1,61 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+var (
+	idMu     sync.Mutex
+	idLastMs int64
+	idSeq    uint16
+)
+
+// generateID возвращает UUIDv7: 48 бит времени в миллисекундах, 12 бит
+// счётчика внутри миллисекунды и 62 случайных бита. Идентификаторы,
+// выданные одним процессом, строго возрастают при сравнении как строки.
+func generateID() string {
+	idMu.Lock()
+	ms := time.Now().UnixMilli()
+	if ms <= idLastMs {
+		idSeq++
+		if idSeq > 0x0fff {
+			// Счётчик исчерпан — занимаем следующую миллисекунду.
+			idLastMs++
+			idSeq = 0
+		}
+		ms = idLastMs
+	} else {
+		idLastMs = ms
+		idSeq = 0
+	}
+	seq := idSeq
+	idMu.Unlock()
+
+	var u [16]byte
+	if _, err := rand.Read(u[8:]); err != nil {
+		panic("не удалось получить случайные байты: " + err.Error())
+	}
+
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(ms))
+	copy(u[0:6], ts[2:8])
+	u[6] = 0x70 | byte(seq>>8)
+	u[7] = byte(seq)
+	u[8] = 0x80 | (u[8] & 0x3f)
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+var uuidV7Pattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestGenerateIDFormatAndOrder(t *testing.T) {
+	prev := generateID()
+	for i := 0; i < 10000; i++ {
+		id := generateID()
+		if !uuidV7Pattern.MatchString(id) {
+			t.Fatalf("❌ %q не является UUIDv7", id)
+		}
+		if id <= prev {
+			t.Fatalf("❌ Идентификаторы не возрастают: %s после %s", id, prev)
+		}
+		prev = id
+	}
+}
+
+func TestConcurrentSubmissionsGetUniqueIDs(t *testing.T) {
+	testName := "TestConcurrentSubmissionsGetUniqueIDs"
+	const n = 2000
+
+	ids := make(chan string, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBufferString(`{"expression": "1 + 2"}`))
+			rr := httptest.NewRecorder()
+			addExpression(rr, req)
+
+			var resp map[string]string
+			json.NewDecoder(rr.Body).Decode(&resp)
+			ids <- resp["id"]
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[string]bool, n)
+	mutex.Lock()
+	defer mutex.Unlock()
+	taskIDs := make(map[string]bool, n)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("❌ [%s] Повторяющийся ID выражения: %s", testName, id)
+		}
+		seen[id] = true
+
+		expr, ok := store[id]
+		if !ok {
+			t.Fatalf("❌ [%s] Выражение %s потеряно", testName, id)
+		}
+		for _, task := range expr.Tasks {
+			if task.ID == id || taskIDs[task.ID] {
+				t.Fatalf("❌ [%s] ID задачи %s не уникален", testName, task.ID)
+			}
+			taskIDs[task.ID] = true
+		}
+	}
+
+	fmt.Printf("[%s] прошел успешно!\n", testName)
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 1000
+)
+
+// exprKey задаёт порядок выражений: по времени создания, затем по ID.
+type exprKey struct {
+	createdAt time.Time
+	id        string
+}
+
+func (a exprKey) less(b exprKey) bool {
+	if !a.createdAt.Equal(b.createdAt) {
+		return a.createdAt.Before(b.createdAt)
+	}
+	return a.id < b.id
+}
+
+// exprOrder — отсортированный индекс store, защищён mutex.
+var exprOrder []exprKey
+
+func indexExpression(expr Expression) {
+	key := exprKey{createdAt: expr.CreatedAt, id: expr.ID}
+	i := sort.Search(len(exprOrder), func(i int) bool { return !exprOrder[i].less(key) })
+	exprOrder = append(exprOrder, exprKey{})
+	copy(exprOrder[i+1:], exprOrder[i:])
+	exprOrder[i] = key
+}
+
+func unindexExpression(expr Expression) {
+	key := exprKey{createdAt: expr.CreatedAt, id: expr.ID}
+	i := sort.Search(len(exprOrder), func(i int) bool { return !exprOrder[i].less(key) })
+	if i < len(exprOrder) && exprOrder[i].id == key.id {
+		exprOrder = append(exprOrder[:i], exprOrder[i+1:]...)
+	}
+}
+
+func encodeCursor(key exprKey) string {
+	raw := fmt.Sprintf("%d:%s", key.createdAt.UnixNano(), key.id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (exprKey, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return exprKey{}, fmt.Errorf("некорректный cursor")
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return exprKey{}, fmt.Errorf("некорректный cursor")
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return exprKey{}, fmt.Errorf("некорректный cursor")
+	}
+	return exprKey{createdAt: time.Unix(0, n), id: id}, nil
+}
+
+type listQuery struct {
+	limit         int
+	cursor        *exprKey
+	statuses      map[Status]bool
+	createdAfter  *time.Time
+	createdBefore *time.Time
+	desc          bool
+}
+
+func parseListQuery(values url.Values) (listQuery, error) {
+	q := listQuery{limit: defaultPageLimit}
+
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return q, fmt.Errorf("limit должен быть положительным числом")
+		}
+		if n > maxPageLimit {
+			n = maxPageLimit
+		}
+		q.limit = n
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		key, err := decodeCursor(v)
+		if err != nil {
+			return q, err
+		}
+		q.cursor = &key
+	}
+
+	if v := values.Get("status"); v != "" {
+		q.statuses = make(map[Status]bool)
+		for _, s := range strings.Split(v, ",") {
+			status := Status(strings.TrimSpace(s))
+			if !status.Valid() {
+				return q, fmt.Errorf("неизвестный статус: %s", status)
+			}
+			q.statuses[status] = true
+		}
+	}
+
+	for param, dst := range map[string]**time.Time{"created_after": &q.createdAfter, "created_before": &q.createdBefore} {
+		if v := values.Get(param); v != "" {
+			ts, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return q, fmt.Errorf("%s должен быть в формате RFC3339", param)
+			}
+			*dst = &ts
+		}
+	}
+
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.desc = true
+	default:
+		return q, fmt.Errorf("order должен быть asc или desc")
+	}
+
+	return q, nil
+}
+
+// listExpressions вызывается под mutex. Границы диапазона находятся
+// бинарным поиском по exprOrder, поэтому страница не требует обхода всего store.
+func listExpressions(q listQuery) ([]Expression, string) {
+	lo, hi := 0, len(exprOrder)
+	if q.createdAfter != nil {
+		lo = sort.Search(len(exprOrder), func(i int) bool { return exprOrder[i].createdAt.After(*q.createdAfter) })
+	}
+	if q.createdBefore != nil {
+		hi = sort.Search(len(exprOrder), func(i int) bool { return !exprOrder[i].createdAt.Before(*q.createdBefore) })
+	}
+	if q.cursor != nil {
+		c := *q.cursor
+		if q.desc {
+			hi = min(hi, sort.Search(len(exprOrder), func(i int) bool { return !exprOrder[i].less(c) }))
+		} else {
+			lo = max(lo, sort.Search(len(exprOrder), func(i int) bool { return c.less(exprOrder[i]) }))
+		}
+	}
+
+	exprs := []Expression{}
+	var last exprKey
+	step, i := 1, lo
+	if q.desc {
+		step, i = -1, hi-1
+	}
+
+	for ; i >= lo && i < hi; i += step {
+		expr, ok := store[exprOrder[i].id]
+		if !ok || (q.statuses != nil && !q.statuses[expr.Status]) {
+			continue
+		}
+		if len(exprs) == q.limit {
+			return exprs, encodeCursor(last)
+		}
+		exprs = append(exprs, expr)
+		last = exprOrder[i]
+	}
+	return exprs, ""
+}
+
+func getAllExpressions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	q, err := parseListQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidQuery, err.Error(), nil)
+		return
+	}
+
+	// Под mutex страница только копируется: кодирование ответа медленному
+	// клиенту не должно задерживать выдачу задач и приём результатов.
+	mutex.Lock()
+	exprs, next := listExpressions(q)
+	for i := range exprs {
+		exprs[i] = exprs[i].clone()
+	}
+	mutex.Unlock()
+
+	response := map[string]interface{}{"expressions": exprs}
+	if next != "" {
+		response["next_cursor"] = next
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// clone возвращает копию выражения, не разделяющую с хранилищем ни срезов,
+// ни словарей, ни указателей: её можно читать без mutex.
+func (e Expression) clone() Expression {
+	e.Result = clonePtr(e.Result)
+	e.Complex = clonePtr(e.Complex)
+	e.StartedAt = clonePtr(e.StartedAt)
+	e.FinishedAt = clonePtr(e.FinishedAt)
+	e.Matrix = cloneMatrix(e.Matrix)
+	e.Variables = maps.Clone(e.Variables)
+	if e.Matrices != nil {
+		matrices := make(map[string][][]float64, len(e.Matrices))
+		for name, m := range e.Matrices {
+			matrices[name] = cloneMatrix(m)
+		}
+		e.Matrices = matrices
+	}
+	if e.Tasks != nil {
+		tasks := make([]Task, len(e.Tasks))
+		for i, t := range e.Tasks {
+			t.Args = slices.Clone(t.Args)
+			t.Operands = slices.Clone(t.Operands)
+			t.ArgTasks = slices.Clone(t.ArgTasks)
+			t.Mat1, t.Mat2 = cloneMatrix(t.Mat1), cloneMatrix(t.Mat2)
+			t.Matrix = cloneMatrix(t.Matrix)
+			t.Block = clonePtr(t.Block)
+			t.Result = clonePtr(t.Result)
+			tasks[i] = t
+		}
+		e.Tasks = tasks
+	}
+	return e
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+func cloneMatrix(m [][]float64) [][]float64 {
+	if m == nil {
+		return nil
+	}
+	out := make([][]float64, len(m))
+	for i, row := range m {
+		out[i] = slices.Clone(row)
+	}
+	return out
+}
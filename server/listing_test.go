@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type listResponse struct {
+	Expressions []Expression `json:"expressions"`
+	NextCursor  string       `json:"next_cursor"`
+}
+
+func seedExpressions(t *testing.T, n int) time.Time {
+	t.Helper()
+
+	originalStore, originalOrder := store, exprOrder
+	t.Cleanup(func() {
+		mutex.Lock()
+		store, exprOrder = originalStore, originalOrder
+		mutex.Unlock()
+	})
+
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	mutex.Lock()
+	store, exprOrder = make(map[string]Expression), nil
+	// Вставка в обратном порядке проверяет, что индекс остаётся отсортированным.
+	for i := n - 1; i >= 0; i-- {
+		status := StatusQueued
+		if i%3 == 0 {
+			status = StatusDone
+		}
+		expr := Expression{
+			ID:        fmt.Sprintf("list_%03d", i),
+			Expr:      "1 + 1",
+			Status:    status,
+			CreatedAt: base.Add(time.Duration(i) * time.Minute),
+		}
+		saveNewExpression(expr)
+	}
+	mutex.Unlock()
+	return base
+}
+
+func fetchPage(t *testing.T, query url.Values) (int, listResponse) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions?"+query.Encode(), nil)
+	rr := httptest.NewRecorder()
+	getAllExpressions(rr, req)
+
+	var resp listResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	return rr.Code, resp
+}
+
+func TestListPaginationWalksAllPages(t *testing.T) {
+	seedExpressions(t, 25)
+
+	var ids []string
+	query := url.Values{"limit": {"10"}}
+	for page := 0; page < 10; page++ {
+		code, resp := fetchPage(t, query)
+		if code != http.StatusOK {
+			t.Fatalf("❌ Ожидался статус %d, но получен %d", http.StatusOK, code)
+		}
+		for _, e := range resp.Expressions {
+			ids = append(ids, e.ID)
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", resp.NextCursor)
+	}
+
+	if len(ids) != 25 {
+		t.Fatalf("❌ Ожидалось 25 выражений, получено %d", len(ids))
+	}
+	for i, id := range ids {
+		if want := fmt.Sprintf("list_%03d", i); id != want {
+			t.Fatalf("❌ Позиция %d: ожидалось %s, получено %s", i, want, id)
+		}
+	}
+}
+
+func TestListFilterAndOrder(t *testing.T) {
+	base := seedExpressions(t, 30)
+
+	query := url.Values{
+		"status":         {"done"},
+		"order":          {"desc"},
+		"created_after":  {base.Add(5 * time.Minute).Format(time.RFC3339)},
+		"created_before": {base.Add(25 * time.Minute).Format(time.RFC3339)},
+		"limit":          {"4"},
+	}
+	_, first := fetchPage(t, query)
+	query.Set("cursor", first.NextCursor)
+	_, second := fetchPage(t, query)
+
+	var got []string
+	for _, e := range append(first.Expressions, second.Expressions...) {
+		got = append(got, e.ID)
+	}
+	want := []string{"list_024", "list_021", "list_018", "list_015", "list_012", "list_009", "list_006"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("❌ Ожидалось %v, получено %v", want, got)
+	}
+	if second.NextCursor != "" {
+		t.Fatalf("❌ На последней странице не должно быть next_cursor")
+	}
+}
+
+func TestListInvalidQuery(t *testing.T) {
+	for _, query := range []url.Values{
+		{"limit": {"0"}},
+		{"status": {"pending"}},
+		{"cursor": {"!!!"}},
+		{"order": {"sideways"}},
+		{"created_after": {"yesterday"}},
+	} {
+		code, _ := fetchPage(t, query)
+		if code != http.StatusBadRequest {
+			t.Errorf("❌ %v: ожидался статус %d, но получен %d", query, http.StatusBadRequest, code)
+		}
+	}
+}
+
+func TestExpressionCloneIsIndependent(t *testing.T) {
+	result := 3.0
+	original := Expression{
+		ID:        "clone_expr",
+		Result:    &result,
+		Matrix:    [][]float64{{1, 2}},
+		Variables: Variables{"x": "1"},
+		Matrices:  map[string][][]float64{"A": {{1}}},
+		Tasks:     []Task{{ID: "clone_task", Args: []float64{1}, Status: TaskReady}},
+	}
+	copied := original.clone()
+
+	*original.Result = 4
+	original.Matrix[0][0] = 5
+	original.Variables["x"] = "2"
+	original.Matrices["A"][0][0] = 6
+	original.Tasks[0].Status = TaskDone
+	original.Tasks[0].Args[0] = 7
+
+	if *copied.Result != 3 || copied.Matrix[0][0] != 1 || copied.Variables["x"] != "1" || copied.Matrices["A"][0][0] != 1 ||
+		copied.Tasks[0].Status != TaskReady || copied.Tasks[0].Args[0] != 1 {
+		t.Fatalf("❌ Копия выражения изменилась вместе с оригиналом: %+v", copied)
+	}
+}
@@ -0,0 +1,316 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Операции над матрицами, которые выполняют агенты. Сложение, вычитание,
+// умножение на число и деление на число выполняются поэлементно; если один
+// из аргументов — число, оно применяется ко всем элементам другого.
+const (
+	opMatMul    = "matmul"
+	opMatAdd    = "madd"
+	opMatSub    = "msub"
+	opMatScale  = "mscale"
+	opMatDiv    = "mdiv"
+	opTranspose = "transpose"
+	// opAssemble собирает результат блочного умножения на сервере и агентам
+	// не отправляется.
+	opAssemble = "assemble"
+)
+
+// matrixBlockSize — максимальное число строк и столбцов результата в одной
+// задаче умножения. Большие произведения делятся на блоки.
+var matrixBlockSize int
+
+// MatrixBlock — часть результата умножения, которую вычисляет задача:
+// строки [Row0, Row1) левого аргумента на столбцы [Col0, Col1) правого.
+type MatrixBlock struct {
+	Row0 int `json:"row0"`
+	Row1 int `json:"row1"`
+	Col0 int `json:"col0"`
+	Col1 int `json:"col1"`
+}
+
+// shape — размер значения узла; у числа оба поля нулевые.
+type shape struct {
+	rows, cols int
+}
+
+func (s shape) isMatrix() bool {
+	return s.rows > 0
+}
+
+func (s shape) String() string {
+	return fmt.Sprintf("%d×%d", s.rows, s.cols)
+}
+
+func isMatrixOperation(op string) bool {
+	switch op {
+	case opMatMul, opMatAdd, opMatSub, opMatScale, opMatDiv, opTranspose, opAssemble:
+		return true
+	}
+	return false
+}
+
+// matrixKey — запись матрицы для канонического ключа. Сами элементы в ключ
+// не попадают, чтобы ключ не рос вместе с матрицей.
+func matrixKey(m [][]float64) string {
+	h := sha256.New()
+	var buf [8]byte
+	for _, row := range m {
+		for _, v := range row {
+			binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+			h.Write(buf[:])
+		}
+	}
+	return fmt.Sprintf("[%dx%d:%x]", len(m), len(m[0]), h.Sum(nil)[:8])
+}
+
+// checkMatrix проверяет, что матрица непустая и прямоугольная.
+func checkMatrix(m [][]float64) error {
+	if len(m) == 0 || len(m[0]) == 0 {
+		return fmt.Errorf("пустая матрица")
+	}
+	for _, row := range m {
+		if len(row) != len(m[0]) {
+			return fmt.Errorf("строки матрицы должны быть одной длины")
+		}
+	}
+	return nil
+}
+
+// checkResultMatrix проверяет матрицу из результата агента: она должна
+// иметь размер, который вычислен для задачи при разборе.
+func checkResultMatrix(task Task, m [][]float64) error {
+	if err := checkMatrix(m); err != nil {
+		return err
+	}
+	if got := (shape{rows: len(m), cols: len(m[0])}); got != task.size {
+		return fmt.Errorf("ожидалась матрица %s, получена %s", task.size, got)
+	}
+	return nil
+}
+
+func validateMatrices(matrices map[string][][]float64, vars Variables) error {
+	for name, m := range matrices {
+		if !isIdentifier(name) {
+			return fmt.Errorf("некорректное имя переменной %q", name)
+		}
+		if _, ok := vars[name]; ok {
+			return fmt.Errorf("переменная %s задана и числом, и матрицей", name)
+		}
+		if err := checkMatrix(m); err != nil {
+			return fmt.Errorf("матрица %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// inferShapes вычисляет размеры всех узлов дерева после подстановки
+// переменных и отклоняет операции с несогласованными размерами.
+func inferShapes(root *Node) (map[*Node]shape, error) {
+	shapes := make(map[*Node]shape)
+	var walk func(n *Node) (shape, error)
+	walk = func(n *Node) (shape, error) {
+		args := make([]shape, len(n.Args))
+		for i, arg := range n.Args {
+			s, err := walk(arg)
+			if err != nil {
+				return shape{}, err
+			}
+			args[i] = s
+		}
+		s, err := nodeShape(n, args)
+		if err != nil {
+			return shape{}, err
+		}
+		shapes[n] = s
+		return s, nil
+	}
+	if _, err := walk(root); err != nil {
+		return nil, err
+	}
+	return shapes, nil
+}
+
+func nodeShape(n *Node, args []shape) (shape, error) {
+	fail := func(format string, a ...interface{}) (shape, error) {
+		return shape{}, &ParseError{Message: fmt.Sprintf(format, a...), Token: n.Op, Position: n.Pos}
+	}
+
+	switch n.Kind {
+	case NodeMatrix:
+		return shape{rows: len(n.Matrix), cols: len(n.Matrix[0])}, nil
+	case NodeCall:
+		if functions[n.Op].matrix {
+			if !args[0].isMatrix() {
+				return fail("функция %s ожидает матрицу", n.Op)
+			}
+			return shape{rows: args[0].cols, cols: args[0].rows}, nil
+		}
+		for _, s := range args {
+			if s.isMatrix() {
+				return fail("функция %s не применяется к матрицам", publicName(n.Op))
+			}
+		}
+		return shape{}, nil
+	case NodeBinary:
+	default:
+		return shape{}, nil
+	}
+
+	left, right := args[0], args[1]
+	if !left.isMatrix() && !right.isMatrix() {
+		return shape{}, nil
+	}
+	switch n.Op {
+	case "+", "-":
+		if left.isMatrix() && right.isMatrix() && left != right {
+			return fail("размеры матриц не совпадают: %s и %s", left, right)
+		}
+	case "*":
+		if left.isMatrix() && right.isMatrix() {
+			if left.cols != right.rows {
+				return fail("несогласованные размеры для умножения: %s и %s", left, right)
+			}
+			return shape{rows: left.rows, cols: right.cols}, nil
+		}
+	case "/":
+		if right.isMatrix() {
+			return fail("деление на матрицу не поддерживается")
+		}
+	default:
+		return fail("операция %s не применяется к матрицам", n.Op)
+	}
+	if left.isMatrix() {
+		return left, nil
+	}
+	return right, nil
+}
+
+// matrixOperation — операция агента для узла с матричным значением.
+func matrixOperation(n *Node, shapes map[*Node]shape) string {
+	if n.Kind == NodeCall {
+		return n.Op
+	}
+	switch n.Op {
+	case "+":
+		return opMatAdd
+	case "-":
+		return opMatSub
+	case "/":
+		return opMatDiv
+	}
+	if shapes[n.Args[0]].isMatrix() && shapes[n.Args[1]].isMatrix() {
+		return opMatMul
+	}
+	return opMatScale
+}
+
+// buildMatrix вызывается из build для узла с матричным значением. Произведение,
+// результат которого больше matrixBlockSize по строкам или столбцам,
+// делится на независимые задачи-блоки и задачу сборки.
+func (b *taskBuilder) buildMatrix(n *Node, key string) (Value, string) {
+	values := make([]Value, len(n.Args))
+	ids := make([]string, len(n.Args))
+	for i, arg := range n.Args {
+		values[i], ids[i] = b.build(arg)
+	}
+
+	op := matrixOperation(n, b.shapes)
+	out := b.shapes[n]
+	if op == opMatMul && (out.rows > matrixBlockSize || out.cols > matrixBlockSize) {
+		assemble := Task{ID: generateID(), Operation: opAssemble, Key: key, Status: TaskWaiting}
+		for r := 0; r < out.rows; r += matrixBlockSize {
+			for c := 0; c < out.cols; c += matrixBlockSize {
+				block := &MatrixBlock{Row0: r, Row1: min(r+matrixBlockSize, out.rows), Col0: c, Col1: min(c+matrixBlockSize, out.cols)}
+				task := b.matrixTask(op, values, ids)
+				task.Block = block
+				task.size = shape{rows: block.Row1 - block.Row0, cols: block.Col1 - block.Col0}
+				task.setMatrixOperand(0, values[0].Matrix)
+				task.setMatrixOperand(1, values[1].Matrix)
+				b.add(task)
+				assemble.ArgTasks = append(assemble.ArgTasks, task.ID)
+			}
+		}
+		b.register(key, assemble.ID)
+		b.add(assemble)
+		return Value{}, assemble.ID
+	}
+
+	task := b.matrixTask(op, values, ids)
+	task.Key = key
+	task.size = out
+	for i, v := range values {
+		task.setMatrixOperand(i, v.Matrix)
+	}
+	b.register(key, task.ID)
+	b.add(task)
+	return Value{}, task.ID
+}
+
+func (b *taskBuilder) matrixTask(op string, values []Value, ids []string) Task {
+	task := Task{ID: generateID(), Operation: op, Arg1: values[0].Float, Arg1Task: ids[0]}
+	if len(values) > 1 {
+		task.Arg2, task.Arg2Task = values[1].Float, ids[1]
+	}
+	task.Status = TaskWaiting
+	if len(task.dependencies()) == 0 {
+		task.Status = TaskReady
+	}
+	return task
+}
+
+// setMatrixOperand записывает матричный аргумент задачи. У блока умножения
+// левый аргумент урезается до его строк, правый — до его столбцов.
+func (t *Task) setMatrixOperand(i int, m [][]float64) {
+	if m == nil {
+		return
+	}
+	if t.Block != nil {
+		if i == 0 {
+			m = m[t.Block.Row0:t.Block.Row1]
+		} else {
+			m = columns(m, t.Block.Col0, t.Block.Col1)
+		}
+	}
+	if i == 0 {
+		t.Mat1 = m
+	} else {
+		t.Mat2 = m
+	}
+}
+
+func columns(m [][]float64, from, to int) [][]float64 {
+	out := make([][]float64, len(m))
+	for i, row := range m {
+		out[i] = row[from:to]
+	}
+	return out
+}
+
+// assembleMatrix собирает результат задачи сборки из готовых блоков.
+func assembleMatrix(expr Expression, task Task) [][]float64 {
+	var rows, cols int
+	blocks := make([]Task, 0, len(task.ArgTasks))
+	for _, id := range task.ArgTasks {
+		block := expr.Tasks[taskIndex(expr, id)]
+		rows, cols = max(rows, block.Block.Row1), max(cols, block.Block.Col1)
+		blocks = append(blocks, block)
+	}
+
+	out := make([][]float64, rows)
+	for i := range out {
+		out[i] = make([]float64, cols)
+	}
+	for _, block := range blocks {
+		for i, row := range block.Matrix {
+			copy(out[block.Block.Row0+i][block.Block.Col0:], row)
+		}
+	}
+	return out
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// matrixResult вычисляет матричную задачу так же, как агент.
+func matrixResult(t *testing.T, task Task) [][]float64 {
+	t.Helper()
+
+	at := func(m [][]float64, scalar float64, i, j int) float64 {
+		if m == nil {
+			return scalar
+		}
+		return m[i][j]
+	}
+	shape := task.Mat1
+	if shape == nil {
+		shape = task.Mat2
+	}
+
+	var out [][]float64
+	switch task.Operation {
+	case opMatMul:
+		for _, row := range task.Mat1 {
+			res := make([]float64, len(task.Mat2[0]))
+			for k, v := range row {
+				for j, w := range task.Mat2[k] {
+					res[j] += v * w
+				}
+			}
+			out = append(out, res)
+		}
+	case opTranspose:
+		for j := range task.Mat1[0] {
+			var res []float64
+			for _, row := range task.Mat1 {
+				res = append(res, row[j])
+			}
+			out = append(out, res)
+		}
+	case opMatAdd, opMatSub, opMatScale, opMatDiv:
+		for i, row := range shape {
+			res := make([]float64, len(row))
+			for j := range row {
+				a, b := at(task.Mat1, task.Arg1, i, j), at(task.Mat2, task.Arg2, i, j)
+				switch task.Operation {
+				case opMatAdd:
+					res[j] = a + b
+				case opMatSub:
+					res[j] = a - b
+				case opMatScale:
+					res[j] = a * b
+				case opMatDiv:
+					res[j] = a / b
+				}
+			}
+			out = append(out, res)
+		}
+	default:
+		t.Fatalf("Неизвестная матричная операция %q", task.Operation)
+	}
+	return out
+}
+
+func TestParseMatrixLiteral(t *testing.T) {
+	root, err := parseExpressionAST("[[1, -2], [3, 4.5]] * [5, 6]")
+	if err != nil {
+		t.Fatalf("❌ Неожиданная ошибка: %v", err)
+	}
+	if got := root.Args[0].Matrix; !reflect.DeepEqual(got, [][]float64{{1, -2}, {3, 4.5}}) {
+		t.Errorf("❌ Левая матрица разобрана неверно: %v", got)
+	}
+	if got := root.Args[1].Matrix; !reflect.DeepEqual(got, [][]float64{{5, 6}}) {
+		t.Errorf("❌ Строка [5, 6] должна стать матрицей 1×2, получено %v", got)
+	}
+
+	tests := []struct {
+		expression string
+		message    string
+	}{
+		{"[[1, 2], [3]] + 1", "строки матрицы должны быть одной длины"},
+		{"[] + 1", "пустая матрица"},
+		{"[1, x] + 1", "элементом матрицы может быть только действительное число"},
+		{"[1 2] + 1", "ожидалась запятая или закрывающая квадратная скобка"},
+		{"[[1, 2]]", "выражение должно содержать хотя бы одну операцию"},
+	}
+	for _, tt := range tests {
+		_, err := parseExpressionAST(tt.expression)
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Message != tt.message {
+			t.Errorf("❌ %q: ожидалась ошибка %q, получено %v", tt.expression, tt.message, err)
+		}
+	}
+}
+
+func TestMatrixProductIsNotCommutative(t *testing.T) {
+	a, _ := parseExpressionAST("[[1, 2]] * [[3], [4]]")
+	b, _ := parseExpressionAST("[[3], [4]] * [[1, 2]]")
+	if canonical(a) == canonical(b) {
+		t.Fatalf("❌ A * B и B * A должны иметь разные ключи: %s", canonical(a))
+	}
+}
+
+func TestMatrixShapeErrors(t *testing.T) {
+	isolateState(t)
+
+	tests := []struct {
+		body    string
+		code    string
+		message string
+	}{
+		{`{"expression": "[[1, 2]] * [[1, 2]]"}`, ErrCodeInvalidExpression, "несогласованные размеры для умножения: 1×2 и 1×2"},
+		{`{"expression": "A + [[1, 2]]", "matrices": {"A": [[1], [2]]}}`, ErrCodeInvalidExpression, "размеры матриц не совпадают: 2×1 и 1×2"},
+		{`{"expression": "2 / A", "matrices": {"A": [[1]]}}`, ErrCodeInvalidExpression, "деление на матрицу не поддерживается"},
+		{`{"expression": "sqrt(A)", "matrices": {"A": [[1]]}}`, ErrCodeInvalidExpression, "функция sqrt не применяется к матрицам"},
+		{`{"expression": "transpose(2) + 1"}`, ErrCodeInvalidExpression, "функция transpose ожидает матрицу"},
+		{`{"expression": "A + 1", "matrices": {"A": [[1], [2, 3]]}}`, ErrCodeInvalidVariables, "матрица A: строки матрицы должны быть одной длины"},
+		{`{"expression": "A + 1", "variables": {"A": 1}, "matrices": {"A": [[1]]}}`, ErrCodeInvalidVariables, "переменная A задана и числом, и матрицей"},
+		{`{"expression": "[[1]] + 1", "mode": "rational"}`, ErrCodeInvalidExpression, "матрицы доступны только в режиме float"},
+	}
+	for _, tt := range tests {
+		rr := submitBody(tt.body)
+		apiErr := decodeAPIError(t, rr)
+		if apiErr.Code != tt.code || apiErr.Message != tt.message {
+			t.Errorf("❌ %s: ожидалось %s %q, получено %d %s %q", tt.body, tt.code, tt.message, rr.Code, apiErr.Code, apiErr.Message)
+		}
+	}
+}
+
+func TestMatrixExpressionEvaluation(t *testing.T) {
+	isolateState(t)
+
+	id := responseID(submitBody(`{"expression": "A * B + C - 2 * transpose(C)", "matrices": {
+		"A": [[1, 2], [3, 4]],
+		"B": [[5, 6], [7, 8]],
+		"C": [[1, 0], [1, 1]]
+	}}`))
+	if executed := runAgent(t); executed != 5 {
+		t.Errorf("❌ Ожидалось 5 задач (matmul, madd, transpose, mscale, msub), выполнено %d", executed)
+	}
+
+	mutex.Lock()
+	expr := store[id]
+	mutex.Unlock()
+	expected := [][]float64{{18, 20}, {44, 49}}
+	if expr.Status != StatusDone || !reflect.DeepEqual(expr.Matrix, expected) {
+		t.Fatalf("❌ Ожидалось done %v, получено %s %v (%s)", expected, expr.Status, expr.Matrix, expr.Error)
+	}
+	if expr.Result != nil {
+		t.Errorf("❌ У матричного результата не должно быть поля result, получено %v", *expr.Result)
+	}
+}
+
+func TestLargeMatrixProductSplitIntoBlocks(t *testing.T) {
+	isolateState(t)
+	saved := matrixBlockSize
+	matrixBlockSize = 2
+	t.Cleanup(func() { matrixBlockSize = saved })
+
+	// 3×2 на 2×3: результат 3×3 делится на блоки 2×2, 2×1, 1×2 и 1×1.
+	id := responseID(submitBody(`{"expression": "A * B", "matrices": {
+		"A": [[1, 2], [3, 4], [5, 6]],
+		"B": [[1, 0, 2], [0, 1, 3]]
+	}}`))
+
+	mutex.Lock()
+	expr := store[id]
+	queued := len(tasks)
+	mutex.Unlock()
+	if len(expr.Tasks) != 5 || queued != 4 {
+		t.Fatalf("❌ Ожидалось 4 блока в очереди и задача сборки, получено задач %d, в очереди %d", len(expr.Tasks), queued)
+	}
+	if last := expr.Tasks[len(expr.Tasks)-1]; last.Operation != opAssemble || len(last.ArgTasks) != 4 {
+		t.Fatalf("❌ Последней должна быть сборка из 4 блоков, получено %s %v", last.Operation, last.ArgTasks)
+	}
+
+	if executed := runAgent(t); executed != 4 {
+		t.Errorf("❌ Агентам должны уйти только 4 блока, выполнено %d", executed)
+	}
+
+	mutex.Lock()
+	expr = store[id]
+	mutex.Unlock()
+	expected := [][]float64{{1, 2, 8}, {3, 4, 18}, {5, 6, 28}}
+	if expr.Status != StatusDone || !reflect.DeepEqual(expr.Matrix, expected) {
+		t.Fatalf("❌ Ожидалось done %v, получено %s %v", expected, expr.Status, expr.Matrix)
+	}
+
+	// Повторное произведение берётся из кэша целиком.
+	again := responseID(submitBody(`{"expression": "A * B", "matrices": {
+		"A": [[1, 2], [3, 4], [5, 6]],
+		"B": [[1, 0, 2], [0, 1, 3]]
+	}}`))
+	mutex.Lock()
+	cached := store[again]
+	mutex.Unlock()
+	if cached.Status != StatusDone || !reflect.DeepEqual(cached.Matrix, expected) {
+		t.Fatalf("❌ Повторное произведение должно завершиться из кэша, получено %s %v", cached.Status, cached.Matrix)
+	}
+}
+
+func TestMatrixResultSizeChecked(t *testing.T) {
+	isolateState(t)
+	saved := matrixBlockSize
+	matrixBlockSize = 2
+	t.Cleanup(func() { matrixBlockSize = saved })
+
+	responseID(submitBody(`{"expression": "A * B", "matrices": {
+		"A": [[1, 2], [3, 4], [5, 6]],
+		"B": [[1, 0, 2], [0, 1, 3]]
+	}}`))
+	responseID(submitBody(`{"expression": "transpose(C)", "matrices": {"C": [[1, 2, 3], [4, 5, 6]]}}`))
+
+	_, fetched := fetchBatch(t, "?limit=10")
+	if len(fetched) != 5 {
+		t.Fatalf("❌ Ожидалось 5 задач, получено %d", len(fetched))
+	}
+	// Результат размера всего произведения не подходит ни одному блоку,
+	// а 2×3 не подходит транспонированию матрицы 2×3.
+	wrong := [][]float64{{1, 2, 3}, {4, 5, 6}}
+	for _, task := range fetched {
+		data, _ := json.Marshal(map[string]interface{}{"id": task.ID, "matrix": wrong})
+		rr := httptest.NewRecorder()
+		completeTask(rr, httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBuffer(data)))
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("❌ %s: ожидался статус %d, получен %d", task.Operation, http.StatusUnprocessableEntity, rr.Code)
+		}
+		if apiErr := decodeAPIError(t, rr); apiErr.Code != ErrCodeInvalidResult {
+			t.Fatalf("❌ %s: ожидался код %s, получено %+v", task.Operation, ErrCodeInvalidResult, apiErr)
+		}
+	}
+}
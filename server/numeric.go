@@ -0,0 +1,182 @@
+package server
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// Числовые режимы выражения. В режиме float агенты считают в float64,
+// в остальных режимах операнды и результаты передаются строками.
+const (
+	ModeFloat    = "float"
+	ModeDecimal  = "decimal"
+	ModeRational = "rational"
+	ModeInt64    = "int64"
+	ModeBigInt   = "bigint"
+	ModeComplex  = "complex"
+)
+
+const maxDecimalPrecision = 1000
+
+// decimalPrecision — число значащих цифр в режиме decimal по умолчанию.
+var decimalPrecision int
+
+// Value — результат задачи. Text заполнен в режимах со строковыми значениями
+// и хранит значение без потерь; Float — его приближение (или само значение
+// в float, или действительная часть в complex). У матричного значения
+// заполнено только Matrix.
+type Value struct {
+	Float  float64
+	Text   string
+	Matrix [][]float64
+}
+
+// ComplexValue — результат выражения в режиме complex.
+type ComplexValue struct {
+	Re float64 `json:"re"`
+	Im float64 `json:"im"`
+}
+
+// exactFunctions — функции, доступные в точных режимах.
+var exactFunctions = map[string]bool{
+	"abs": true, "sign": true, "min": true, "max": true,
+	"round": true, "floor": true, "ceil": true, "trunc": true, "sqrt": true, "if": true,
+	"sum": true, "product": true, "dev": true, "sqdev": true,
+}
+
+// complexFunctions — функции, доступные в режиме complex.
+var complexFunctions = map[string]bool{
+	"sqrt": true, "abs": true, "exp": true, "ln": true, "log": true, "log10": true,
+	"sin": true, "cos": true, "tan": true, "asin": true, "acos": true, "atan": true,
+	"sinh": true, "cosh": true, "tanh": true,
+	"conj": true, "re": true, "im": true, "arg": true, "if": true,
+	"sum": true, "product": true,
+}
+
+// parseNumericMode проверяет режим и точность из запроса и подставляет
+// значения по умолчанию.
+func parseNumericMode(mode string, precision int) (string, int, error) {
+	switch mode {
+	case "", ModeFloat, ModeRational, ModeInt64, ModeBigInt, ModeComplex:
+		if precision != 0 {
+			return "", 0, fmt.Errorf("precision допустим только в режиме %s", ModeDecimal)
+		}
+		if mode == "" {
+			mode = ModeFloat
+		}
+		return mode, 0, nil
+	case ModeDecimal:
+		if precision == 0 {
+			precision = decimalPrecision
+		}
+		if precision < 1 || precision > maxDecimalPrecision {
+			return "", 0, fmt.Errorf("precision должен быть от 1 до %d", maxDecimalPrecision)
+		}
+		return mode, precision, nil
+	default:
+		return "", 0, fmt.Errorf("неизвестный числовой режим %q, допустимы %s, %s, %s, %s, %s, %s",
+			mode, ModeFloat, ModeDecimal, ModeRational, ModeInt64, ModeBigInt, ModeComplex)
+	}
+}
+
+// textValues сообщает, передаются ли значения режима строками в Operands.
+func textValues(mode string) bool {
+	return mode != "" && mode != ModeFloat
+}
+
+func isIntegerMode(mode string) bool {
+	return mode == ModeInt64 || mode == ModeBigInt
+}
+
+// checkModeLiterals вызывается после подстановки переменных. Мнимые литералы
+// допустимы только в режиме complex; в целочисленных режимах все числа
+// должны быть целыми, а в int64 ещё и помещаться в 64 бита. Матрицы
+// допустимы только в режиме float, единицы измерения — во всех, кроме complex.
+func checkModeLiterals(n *Node, mode string) error {
+	if n.Kind == NodeMatrix && mode != ModeFloat {
+		return &ParseError{Message: "матрицы доступны только в режиме " + ModeFloat, Token: "[", Position: n.Pos}
+	}
+	if n.Kind == NodeNumber {
+		text := exactText(n)
+		if n.Imag && mode != ModeComplex {
+			return &ParseError{Message: "мнимые числа доступны только в режиме " + ModeComplex, Token: text, Position: n.Pos}
+		}
+		if n.Unit != "" && mode == ModeComplex {
+			return &ParseError{Message: "единицы измерения недоступны в режиме " + ModeComplex, Token: n.Unit, Position: n.Pos}
+		}
+		if !isIntegerMode(mode) {
+			return nil
+		}
+		r, ok := new(big.Rat).SetString(text)
+		if !ok || !r.IsInt() {
+			return &ParseError{Message: "в целочисленном режиме допустимы только целые числа", Token: text, Position: n.Pos}
+		}
+		if mode == ModeInt64 && !r.Num().IsInt64() {
+			return &ParseError{Message: "число не помещается в int64", Token: text, Position: n.Pos}
+		}
+		return nil
+	}
+	for _, arg := range n.Args {
+		if err := checkModeLiterals(arg, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkModeFunctions отклоняет функции и операции, недоступные в режиме.
+func checkModeFunctions(n *Node, mode string) error {
+	switch {
+	case n.Kind == NodeCall && functions[n.Op].complexOnly && mode != ModeComplex:
+		return &ParseError{Message: fmt.Sprintf("функция %s доступна только в режиме %s", n.Op, ModeComplex), Token: n.Op, Position: n.Pos}
+	case n.Kind == NodeCall && mode == ModeComplex && !complexFunctions[n.Op],
+		n.Kind == NodeCall && textValues(mode) && mode != ModeComplex && !exactFunctions[n.Op]:
+		return &ParseError{Message: fmt.Sprintf("функция %s недоступна в режиме %s", publicName(n.Op), mode), Token: n.Op, Position: n.Pos}
+	case n.Kind == NodeBinary && mode == ModeComplex && (n.Op == "//" || n.Op == "%" || isOrdering(n.Op)):
+		return &ParseError{Message: fmt.Sprintf("операция %s недоступна в режиме %s", n.Op, mode), Token: n.Op, Position: n.Pos}
+	}
+	for _, arg := range n.Args {
+		if err := checkModeFunctions(arg, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isOrdering сообщает, сравнивает ли операция значения по величине: такие
+// сравнения не определены для комплексных чисел.
+func isOrdering(op string) bool {
+	return op == "<" || op == "<=" || op == ">" || op == ">="
+}
+
+// modeKeyPrefix отделяет ключи кэша разных режимов: 1/3 в decimal и в
+// rational — разные результаты.
+func modeKeyPrefix(mode string, precision int) string {
+	switch mode {
+	case ModeDecimal:
+		return ModeDecimal + ":" + strconv.Itoa(precision) + " "
+	case ModeRational, ModeInt64, ModeBigInt, ModeComplex:
+		return mode + " "
+	default:
+		return ""
+	}
+}
+
+// parseModeValue разбирает строковый результат агента.
+func parseModeValue(mode, text string) (Value, error) {
+	if mode == ModeComplex {
+		c, err := strconv.ParseComplex(text, 128)
+		if err != nil {
+			return Value{}, fmt.Errorf("некорректное комплексное значение %q", text)
+		}
+		return Value{Float: real(c), Text: text}, nil
+	}
+
+	r, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return Value{}, fmt.Errorf("некорректное точное значение %q", text)
+	}
+	f, _ := r.Float64()
+	return Value{Float: f, Text: text}, nil
+}
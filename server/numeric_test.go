@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseNumericMode(t *testing.T) {
+	tests := []struct {
+		mode      string
+		precision int
+		wantMode  string
+		wantPrec  int
+		wantErr   bool
+	}{
+		{"", 0, ModeFloat, 0, false},
+		{ModeRational, 0, ModeRational, 0, false},
+		{ModeDecimal, 0, ModeDecimal, decimalPrecision, false},
+		{ModeDecimal, 50, ModeDecimal, 50, false},
+		{ModeDecimal, maxDecimalPrecision + 1, "", 0, true},
+		{ModeFloat, 10, "", 0, true},
+		{"bignum", 0, "", 0, true},
+	}
+
+	for _, tt := range tests {
+		mode, precision, err := parseNumericMode(tt.mode, tt.precision)
+		if (err != nil) != tt.wantErr || mode != tt.wantMode || precision != tt.wantPrec {
+			t.Errorf("❌ parseNumericMode(%q, %d) = %q, %d, %v", tt.mode, tt.precision, mode, precision, err)
+		}
+	}
+}
+
+func TestDecimalModeSubmission(t *testing.T) {
+	isolateState(t)
+
+	rr := submitBody(`{"expression": "0.1 + 0.2", "mode": "decimal", "precision": 20}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("❌ Ожидался статус %d, получен %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	id := responseID(rr)
+
+	mutex.Lock()
+	task := tasks[0]
+	mutex.Unlock()
+	if task.Mode != ModeDecimal || task.Precision != 20 || len(task.Operands) != 2 || task.Operands[0] != "0.1" {
+		t.Fatalf("❌ Задача должна нести режим и точные операнды, получено %+v", task)
+	}
+
+	runAgent(t)
+
+	mutex.Lock()
+	expr := store[id]
+	mutex.Unlock()
+	if expr.Status != StatusDone || expr.Value != "0.3" || expr.Result == nil || *expr.Result != 0.3 {
+		t.Fatalf("❌ Ожидалось done со значением \"0.3\", получено %s %q %v", expr.Status, expr.Value, expr.Result)
+	}
+}
+
+func TestRationalModeChainsExactValues(t *testing.T) {
+	isolateState(t)
+
+	id := responseID(submitBody(`{"expression": "1 / 3 + 1 / 6", "mode": "rational"}`))
+	if executed := runAgent(t); executed != 3 {
+		t.Fatalf("❌ Ожидалось 3 задачи, выполнено %d", executed)
+	}
+
+	mutex.Lock()
+	expr := store[id]
+	mutex.Unlock()
+	if expr.Value != "1/2" {
+		t.Fatalf("❌ Ожидалось значение 1/2, получено %q", expr.Value)
+	}
+}
+
+func TestNumericModesDoNotShareCache(t *testing.T) {
+	isolateState(t)
+
+	submit(t, "1 / 3")
+	runAgent(t)
+
+	id := responseID(submitBody(`{"expression": "1 / 3", "mode": "rational"}`))
+	if executed := runAgent(t); executed != 1 {
+		t.Fatalf("❌ Результат float не должен использоваться в режиме rational, выполнено задач %d", executed)
+	}
+	mutex.Lock()
+	value := store[id].Value
+	mutex.Unlock()
+	if value != "1/3" {
+		t.Fatalf("❌ Ожидалось значение 1/3, получено %q", value)
+	}
+}
+
+func TestNumericModeErrors(t *testing.T) {
+	isolateState(t)
+
+	tests := []struct {
+		name string
+		body string
+		code string
+	}{
+		{"unknown mode", `{"expression": "1 + 1", "mode": "bignum"}`, ErrCodeInvalidMode},
+		{"precision in float", `{"expression": "1 + 1", "precision": 10}`, ErrCodeInvalidMode},
+		{"inexact function", `{"expression": "sin(1) + 1", "mode": "rational"}`, ErrCodeInvalidExpression},
+		{"fraction in int64", `{"expression": "2.5 * 2", "mode": "int64"}`, ErrCodeInvalidExpression},
+		{"fractional variable", `{"expression": "x * 2", "mode": "bigint", "variables": {"x": 0.5}}`, ErrCodeInvalidExpression},
+		{"int64 literal range", `{"expression": "9223372036854775808 - 1", "mode": "int64"}`, ErrCodeInvalidExpression},
+		{"imaginary in float", `{"expression": "3 + 4i"}`, ErrCodeInvalidExpression},
+		{"modulo in complex", `{"expression": "(3 + 4i) % 2", "mode": "complex"}`, ErrCodeInvalidExpression},
+		{"complex-only function", `{"expression": "re(3) + 1"}`, ErrCodeInvalidExpression},
+	}
+
+	for _, tt := range tests {
+		rr := submitBody(tt.body)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("❌ [%s] Ожидался статус %d, получен %d", tt.name, http.StatusBadRequest, rr.Code)
+			continue
+		}
+		if apiErr := decodeAPIError(t, rr); apiErr.Code != tt.code {
+			t.Errorf("❌ [%s] Ожидался код %s, получено %+v", tt.name, tt.code, apiErr)
+		}
+	}
+
+	id := responseID(submitBody(`{"expression": "2 * 3", "mode": "rational"}`))
+	mutex.Lock()
+	taskID := store[id].Tasks[0].ID
+	mutex.Unlock()
+	getTask(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+	rr := httptest.NewRecorder()
+	completeTask(rr, httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBufferString(`{"id": "`+taskID+`", "result": 6}`)))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("❌ Результат без точного значения: ожидался статус %d, получен %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+}
+
+func TestIntegerModeSubmission(t *testing.T) {
+	isolateState(t)
+
+	id := responseID(submitBody(`{"expression": "9223372036854775808 * 2 - x", "mode": "bigint", "variables": {"x": 1e3}}`))
+	runAgent(t)
+
+	mutex.Lock()
+	expr := store[id]
+	mutex.Unlock()
+	if expr.Status != StatusDone || expr.Value != "18446744073709550616" {
+		t.Fatalf("❌ Ожидалось done 18446744073709550616, получено %s %q", expr.Status, expr.Value)
+	}
+}
+
+func TestLargeIntegerVariablesExact(t *testing.T) {
+	isolateState(t)
+
+	// 2^53 + 1 не представимо в float64 и не должно округляться.
+	bigint := responseID(submitBody(`{"expression": "x * 2 + 1", "mode": "bigint", "variables": {"x": 9007199254740993}}`))
+	int64ID := responseID(submitBody(`{"expression": "x - 1", "mode": "int64", "variables": {"x": 9223372036854775807}}`))
+	runAgent(t)
+
+	mutex.Lock()
+	first, second := store[bigint], store[int64ID]
+	mutex.Unlock()
+	if first.Status != StatusDone || first.Value != "18014398509481987" {
+		t.Errorf("❌ Ожидалось done 18014398509481987, получено %s %q", first.Status, first.Value)
+	}
+	if second.Status != StatusDone || second.Value != "9223372036854775806" {
+		t.Errorf("❌ Ожидалось done 9223372036854775806, получено %s %q", second.Status, second.Value)
+	}
+
+	rr := submitBody(`{"expression": "x + 1", "variables": {"x": 1e400}}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("❌ Значение вне диапазона float64: ожидался статус %d, получен %d", http.StatusBadRequest, rr.Code)
+	}
+	if apiErr := decodeAPIError(t, rr); apiErr.Code != ErrCodeInvalidVariables {
+		t.Errorf("❌ Ожидался код %s, получено %+v", ErrCodeInvalidVariables, apiErr)
+	}
+}
+
+func TestInt64OverflowFailsExpression(t *testing.T) {
+	isolateState(t)
+
+	id := responseID(submitBody(`{"expression": "9223372036854775807 + 1", "mode": "int64"}`))
+
+	mutex.Lock()
+	taskID := store[id].Tasks[0].ID
+	mutex.Unlock()
+	getTask(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+	rr := httptest.NewRecorder()
+	completeTask(rr, httptest.NewRequest(http.MethodPost, "/internal/task",
+		bytes.NewBufferString(`{"id": "`+taskID+`", "error": "переполнение int64: + [9223372036854775807 1]"}`)))
+
+	mutex.Lock()
+	expr := store[id]
+	mutex.Unlock()
+	if expr.Status != StatusError || expr.Value != "" || expr.Result != nil {
+		t.Fatalf("❌ Переполнение должно завершать выражение ошибкой без результата, получено %s %q", expr.Status, expr.Value)
+	}
+}
+
+func TestComplexModeSubmission(t *testing.T) {
+	isolateState(t)
+
+	id := responseID(submitBody(`{"expression": "(3 + 4i) * (1 - 2i) + sqrt(-1)", "mode": "complex"}`))
+	runAgent(t)
+
+	mutex.Lock()
+	expr := store[id]
+	mutex.Unlock()
+	if expr.Status != StatusDone || expr.Complex == nil || *expr.Complex != (ComplexValue{Re: 11, Im: -1}) {
+		t.Fatalf("❌ Ожидалось done {11, -1}, получено %s %+v", expr.Status, expr.Complex)
+	}
+	if expr.Result != nil {
+		t.Errorf("❌ У комплексного результата не должно быть поля result, получено %v", *expr.Result)
+	}
+}
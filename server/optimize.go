@@ -0,0 +1,370 @@
+package server
+
+import (
+	"math"
+	"math/big"
+	"math/bits"
+)
+
+// Оптимизация дерева перед построением задач включается полем optimize
+// запроса. Сервер сворачивает операции и вызовы sum, product (и служебных
+// функций stddev) над константами, убирает тождественные операции (x * 1,
+// x + 0, x / 1, x ^ 1) и перестраивает длинные цепочки + и * в
+// сбалансированное дерево: a + b + c + d считается как (a + b) + (c + d),
+// и критический путь становится короче.
+//
+// Константы сворачиваются только там, где сервер получит тот же результат,
+// что и агент: в float, rational и целочисленных режимах. В decimal каждый
+// шаг округляется агентом, а в complex сервер литералы не вычисляет. По той
+// же причине в decimal не убираются тождества: 1.23456 * 1 при точности 3
+// агент округлит, а без операции литерал попал бы в результат как есть.
+// Перестановка и перегруппировка слагаемых в float и decimal может
+// изменить последние знаки результата. Тождества вроде x * 0 = 0 не
+// применяются: они скрыли бы ошибку вычисления x. Значения переменных
+// константами не считаются: вычисления над ними остаются агентам.
+
+type optimizer struct {
+	mode    string
+	applied []string
+}
+
+// optimize возвращает оптимизированную копию дерева и описания выполненных
+// преобразований.
+func optimize(root *Node, mode string) (*Node, []string) {
+	o := &optimizer{mode: mode}
+	root = o.balance(o.simplify(root))
+	return root, o.applied
+}
+
+func (o *optimizer) note(before, after *Node) {
+	o.applied = append(o.applied, formatExpression(before)+" → "+formatExpression(after))
+}
+
+// simplify сворачивает константы и тождества снизу вверх.
+func (o *optimizer) simplify(n *Node) *Node {
+	if len(n.Args) == 0 {
+		return n
+	}
+	out := *n
+	out.Args = make([]*Node, len(n.Args))
+	for i, arg := range n.Args {
+		out.Args[i] = o.simplify(arg)
+	}
+
+	var result *Node
+	switch {
+	case out.Kind == NodeCall && out.Op == opIf && isLiteral(out.Args[0]):
+		cond := out.Args[0]
+		result = chooseBranch(o.mode, Value{Float: cond.Value, Text: exactText(cond)}, out.Args[1], out.Args[2])
+	case out.Kind == NodeCall:
+		if folded, ok := o.foldAggregate(&out); ok {
+			result = folded
+		}
+	case out.Kind == NodeBinary:
+		if folded, ok := o.fold(&out); ok {
+			result = folded
+		} else if o.mode != ModeDecimal {
+			result = identity(&out)
+		}
+	}
+	if result == nil {
+		return &out
+	}
+	o.note(&out, result)
+	return result
+}
+
+// fold вычисляет бинарную операцию над двумя действительными литералами.
+func (o *optimizer) fold(n *Node) (*Node, bool) {
+	a, b := n.Args[0], n.Args[1]
+	if !isLiteral(a) || !isLiteral(b) {
+		return nil, false
+	}
+	dim, ok := foldDimension(n.Op, a, b)
+	if !ok {
+		return nil, false
+	}
+	out := &Node{Kind: NodeNumber, Dim: dim, Pos: n.Pos}
+
+	switch o.mode {
+	case ModeFloat:
+		v, ok := foldFloat(n.Op, a.Value, b.Value)
+		if !ok {
+			return nil, false
+		}
+		out.Value = v
+	case ModeRational, ModeInt64, ModeBigInt:
+		x, _ := new(big.Rat).SetString(exactText(a))
+		y, _ := new(big.Rat).SetString(exactText(b))
+		r, ok := foldExact(n.Op, x, y, o.mode)
+		if !ok {
+			return nil, false
+		}
+		out.Value, _ = r.Float64()
+		out.Text = r.RatString()
+	default:
+		return nil, false
+	}
+	return out, true
+}
+
+// foldAggregate вычисляет sum, product, dev или sqdev над действительными
+// литералами по порядку аргументов, как агент, бинарными шагами fold.
+func (o *optimizer) foldAggregate(n *Node) (*Node, bool) {
+	for _, arg := range n.Args {
+		if !isLiteral(arg) {
+			return nil, false
+		}
+	}
+	step := func(op string, a, b *Node) (*Node, bool) {
+		return o.fold(&Node{Kind: NodeBinary, Op: op, Args: []*Node{a, b}, Pos: n.Pos})
+	}
+
+	var acc *Node
+	ok := true
+	switch n.Op {
+	case "sum", "product":
+		op := "+"
+		if n.Op == "product" {
+			op = "*"
+		}
+		acc = n.Args[0]
+		for _, x := range n.Args[1:] {
+			if acc, ok = step(op, acc, x); !ok {
+				return nil, false
+			}
+		}
+	case "dev", "sqdev":
+		k := n.Args[0]
+		for _, x := range n.Args[1:] {
+			d, ok := step("-", x, k)
+			if ok && n.Op == "sqdev" {
+				d, ok = step("*", d, d)
+			}
+			if ok && acc != nil {
+				d, ok = step("+", acc, d)
+			}
+			if !ok {
+				return nil, false
+			}
+			acc = d
+		}
+	default:
+		return nil, false
+	}
+	return acc, true
+}
+
+func foldDimension(op string, a, b *Node) (dimension, bool) {
+	switch op {
+	case "+", "-":
+		return a.Dim, true
+	case "*":
+		return a.Dim.add(b.Dim, 1), true
+	case "/":
+		return a.Dim.add(b.Dim, -1), true
+	case "^":
+		// Размерности уже проверены: у размерного основания показатель целый.
+		return a.Dim.scale(int(b.Value)), true
+	}
+	return dimension{}, false
+}
+
+func foldFloat(op string, a, b float64) (float64, bool) {
+	var v float64
+	switch op {
+	case "+":
+		v = a + b
+	case "-":
+		v = a - b
+	case "*":
+		v = a * b
+	case "/":
+		if b == 0 {
+			return 0, false
+		}
+		v = a / b
+	case "^":
+		v = math.Pow(a, b)
+	default:
+		return 0, false
+	}
+	return v, !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// foldExact сворачивает операцию в точном режиме. Деление сворачивается
+// только в rational: в целочисленных режимах его ошибки сообщает агент,
+// как и переполнение int64.
+func foldExact(op string, a, b *big.Rat, mode string) (*big.Rat, bool) {
+	r := new(big.Rat)
+	switch op {
+	case "+":
+		r.Add(a, b)
+	case "-":
+		r.Sub(a, b)
+	case "*":
+		r.Mul(a, b)
+	case "/":
+		if mode != ModeRational || b.Sign() == 0 {
+			return nil, false
+		}
+		r.Quo(a, b)
+	default:
+		return nil, false
+	}
+	if mode == ModeInt64 && !r.Num().IsInt64() {
+		return nil, false
+	}
+	return r, true
+}
+
+// identity убирает операцию с нейтральным элементом. Нейтральный элемент
+// должен быть безразмерным: x * 1 m меняет размерность x.
+func identity(n *Node) *Node {
+	a, b := n.Args[0], n.Args[1]
+	switch n.Op {
+	case "+":
+		if isConstant(b, 0) {
+			return a
+		}
+		if isConstant(a, 0) {
+			return b
+		}
+	case "*":
+		if isConstant(b, 1) {
+			return a
+		}
+		if isConstant(a, 1) {
+			return b
+		}
+	case "-":
+		if isConstant(b, 0) {
+			return a
+		}
+	case "/", "^":
+		if isConstant(b, 1) {
+			return a
+		}
+	}
+	return nil
+}
+
+// isLiteral сообщает, что узел — действительный литерал выражения, а не
+// подставленное значение переменной.
+func isLiteral(n *Node) bool {
+	return n.Kind == NodeNumber && n.Name == "" && !n.Imag
+}
+
+func isConstant(n *Node, v int64) bool {
+	if !isLiteral(n) || !n.Dim.dimensionless() {
+		return false
+	}
+	r, ok := new(big.Rat).SetString(exactText(n))
+	return ok && r.Cmp(big.NewRat(v, 1)) == 0
+}
+
+// balance перестраивает цепочки + и * сверху вниз. Порядок операндов
+// сохраняется, поэтому перегруппировка годится и для произведения матриц;
+// переносятся только числовые константы, которые коммутируют со всем.
+func (o *optimizer) balance(n *Node) *Node {
+	if n.Kind == NodeBinary && (n.Op == "+" || n.Op == "*") {
+		operands := chainOperands(n, n.Op, nil)
+		if len(operands) > 2 {
+			for i, x := range operands {
+				operands[i] = o.balance(x)
+			}
+			operands, merged := o.mergeConstants(n.Op, operands)
+			if merged || chainDepth(n, n.Op) > bits.Len(uint(len(operands)-1)) {
+				result := balancedTree(n.Op, operands, n.Pos)
+				o.note(n, result)
+				return result
+			}
+			return chainTree(n, n.Op, operands)
+		}
+	}
+	if len(n.Args) == 0 {
+		return n
+	}
+	out := *n
+	out.Args = make([]*Node, len(n.Args))
+	for i, arg := range n.Args {
+		out.Args[i] = o.balance(arg)
+	}
+	return &out
+}
+
+// chainOperands собирает операнды цепочки op слева направо.
+func chainOperands(n *Node, op string, operands []*Node) []*Node {
+	if n.Kind == NodeBinary && n.Op == op {
+		operands = chainOperands(n.Args[0], op, operands)
+		return chainOperands(n.Args[1], op, operands)
+	}
+	return append(operands, n)
+}
+
+func chainDepth(n *Node, op string) int {
+	if n.Kind != NodeBinary || n.Op != op {
+		return 0
+	}
+	return 1 + max(chainDepth(n.Args[0], op), chainDepth(n.Args[1], op))
+}
+
+// chainTree повторяет форму исходной цепочки с новыми операндами.
+func chainTree(n *Node, op string, operands []*Node) *Node {
+	next := 0
+	var rebuild func(n *Node) *Node
+	rebuild = func(n *Node) *Node {
+		if n.Kind != NodeBinary || n.Op != op {
+			next++
+			return operands[next-1]
+		}
+		out := *n
+		out.Args = []*Node{rebuild(n.Args[0]), rebuild(n.Args[1])}
+		return &out
+	}
+	return rebuild(n)
+}
+
+func balancedTree(op string, operands []*Node, pos int) *Node {
+	if len(operands) == 1 {
+		return operands[0]
+	}
+	mid := len(operands) / 2
+	return &Node{Kind: NodeBinary, Op: op, Args: []*Node{balancedTree(op, operands[:mid], pos), balancedTree(op, operands[mid:], pos)}, Pos: pos}
+}
+
+// mergeConstants сворачивает числовые константы цепочки в одну, которая
+// ставится в конец: 2 * x * 3 → x * 6.
+func (o *optimizer) mergeConstants(op string, operands []*Node) ([]*Node, bool) {
+	var rest []*Node
+	var acc *Node
+	merged := 0
+	for _, x := range operands {
+		if !isLiteral(x) {
+			rest = append(rest, x)
+			continue
+		}
+		if acc == nil {
+			acc, merged = x, 1
+			continue
+		}
+		folded, ok := o.fold(&Node{Kind: NodeBinary, Op: op, Args: []*Node{acc, x}, Pos: x.Pos})
+		if !ok {
+			rest = append(rest, x)
+			continue
+		}
+		acc = folded
+		merged++
+	}
+	if merged < 2 {
+		return operands, false
+	}
+	neutral := int64(0)
+	if op == "*" {
+		neutral = 1
+	}
+	if len(rest) > 0 && isConstant(acc, neutral) {
+		return rest, true
+	}
+	return append(rest, acc), true
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func optimizeExpression(t *testing.T, expression, mode string, vars Variables) (string, []string) {
+	t.Helper()
+
+	root, err := parseExpressionAST(expression)
+	if err == nil {
+		root, err = bindVariables(root, vars, nil)
+	}
+	if err != nil {
+		t.Fatalf("❌ %s: неожиданная ошибка %v", expression, err)
+	}
+	root, applied := optimize(root, mode)
+	return formatExpression(root), applied
+}
+
+func TestOptimizeRewrites(t *testing.T) {
+	tests := []struct {
+		input    string
+		mode     string
+		expected string
+	}{
+		{"x * 1 + 0", ModeFloat, "x"},
+		{"2 * 3 + y", ModeFloat, "6 + y"},
+		{"2 * x * 3", ModeFloat, "x * 6"},
+		{"a + b + c + d", ModeFloat, "a + b + (c + d)"},
+		{"a + b + c + d + e", ModeFloat, "a + b + (c + (d + e))"},
+		{"(a + b) + (c + d)", ModeFloat, "a + b + (c + d)"},
+		{"a - b - c", ModeFloat, "a - b - c"},
+		{"x ^ 1 / 1 - 0", ModeFloat, "x"},
+		{"x * 0", ModeFloat, "x * 0"},
+		{"x * 1 m", ModeFloat, "x * 1"},
+		{"if(2 - 1, x, y)", ModeFloat, "x"},
+		{"1 / 3 + x", ModeRational, "1/3 + x"},
+		{"0.1 + 0.2 + x", ModeRational, "3/10 + x"},
+		{"0.1 + x + 0.2", ModeRational, "x + 3/10"},
+		{"0.1 + 0.2 + x", ModeDecimal, "0.1 + 0.2 + x"},
+		{"1.23456 * 1 + x", ModeDecimal, "1.23456 * 1 + x"},
+		{"sum(1, 2, 3) + product(2, 3) * x", ModeRational, "6 + 6 * x"},
+		{"sum(1, 2) + x", ModeDecimal, "sum(1, 2) + x"},
+		{"7 / 2 + x", ModeBigInt, "7 / 2 + x"},
+		{"4611686018427387904 * 2 + x", ModeInt64, "4611686018427387904 * 2 + x"},
+		{"4611686018427387904 * 2 + x", ModeBigInt, "9223372036854775808 + x"},
+		{"2 * x * 3", ModeComplex, "2 * x * 3"},
+	}
+	vars := Variables{"x": "2", "y": "3", "a": "1", "b": "1", "c": "1", "d": "1", "e": "1"}
+	for _, tt := range tests {
+		got, _ := optimizeExpression(t, tt.input, tt.mode, vars)
+		if got != tt.expected {
+			t.Errorf("❌ %s (%s): ожидалось %q, получено %q", tt.input, tt.mode, tt.expected, got)
+		}
+	}
+}
+
+func TestOptimizeReportsRewrites(t *testing.T) {
+	got, applied := optimizeExpression(t, "x * 1 + 2 * 3", ModeFloat, Variables{"x": "5"})
+	if got != "x + 6" {
+		t.Fatalf("❌ Ожидалось x + 6, получено %s", got)
+	}
+	if want := []string{"x * 1 → x", "2 * 3 → 6"}; !reflect.DeepEqual(applied, want) {
+		t.Errorf("❌ Ожидались преобразования %v, получено %v", want, applied)
+	}
+}
+
+func TestOptimizedSubmission(t *testing.T) {
+	isolateState(t)
+
+	plain := responseID(submitBody(`{"expression": "a + b + c + d + e + f + g + h", "variables": {"a": 1, "b": 2, "c": 3, "d": 4, "e": 5, "f": 6, "g": 7, "h": 8}}`))
+	optimized := responseID(submitBody(`{"expression": "a + b + c + d + e + f + g + h + 0 * 1", "variables": {"a": 1, "b": 2, "c": 3, "d": 4, "e": 5, "f": 6, "g": 7, "h": 8}, "optimize": true}`))
+	folded := responseID(submitBody(`{"expression": "2 * 3 + 4", "optimize": true}`))
+
+	mutex.Lock()
+	depth := func(id string) int {
+		expr := store[id]
+		depths := make(map[string]int)
+		for _, task := range expr.Tasks {
+			d := 0
+			for _, dep := range task.dependencies() {
+				d = max(d, depths[dep])
+			}
+			depths[task.ID] = d + 1
+		}
+		return depths[expr.Tasks[len(expr.Tasks)-1].ID]
+	}
+	plainDepth, optimizedDepth := depth(plain), depth(optimized)
+	foldedExpr := store[folded]
+	mutex.Unlock()
+
+	if plainDepth != 7 || optimizedDepth != 3 {
+		t.Errorf("❌ Ожидалась глубина 7 без оптимизации и 3 с ней, получено %d и %d", plainDepth, optimizedDepth)
+	}
+	if foldedExpr.Status != StatusDone || *foldedExpr.Result != 10 || len(foldedExpr.Tasks) != 0 || !foldedExpr.Optimize {
+		t.Errorf("❌ Выражение из констант должно вычислиться на сервере: %s %v %d", foldedExpr.Status, foldedExpr.Result, len(foldedExpr.Tasks))
+	}
+
+	runAgent(t)
+	if status, result := expressionResult(t, optimized); status != StatusDone || result != 36 {
+		t.Errorf("❌ Ожидалось done 36, получено %s %v", status, result)
+	}
+}
+
+func TestExplainReportsOptimizations(t *testing.T) {
+	isolateState(t)
+
+	plan := explainBody(t, `{"expression": "x * 1 + 2 * 3", "variables": {"x": 5}, "optimize": true}`)
+	if plan.Optimized != "x + 6" || len(plan.Tasks) != 1 {
+		t.Errorf("❌ Ожидалось выражение x + 6 из одной задачи, получено %q и %d задач", plan.Optimized, len(plan.Tasks))
+	}
+	if want := []string{"x * 1 → x", "2 * 3 → 6"}; !reflect.DeepEqual(plan.Optimizations, want) {
+		t.Errorf("❌ Ожидались преобразования %v, получено %v", want, plan.Optimizations)
+	}
+
+	plain := explainBody(t, `{"expression": "x * 1 + 2 * 3", "variables": {"x": 5}}`)
+	if plain.Optimized != "" || len(plain.Tasks) != 3 {
+		t.Errorf("❌ Без optimize план не меняется: %q, %d задач", plain.Optimized, len(plain.Tasks))
+	}
+}
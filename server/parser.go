@@ -0,0 +1,548 @@
+package server
+
+import (
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokOperator
+	tokLParen
+	tokRParen
+	tokIdent
+	tokComma
+	tokLBracket
+	tokRBracket
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type NodeKind int
+
+const (
+	NodeNumber NodeKind = iota
+	NodeBinary
+	NodeCall
+	NodeVariable
+	NodeMatrix
+)
+
+// Node — узел AST. У бинарной операции два аргумента в Args, у вызова
+// функции — столько, сколько передано; имя функции хранится в Op.
+// У переменной заполнено только Name. Text у числа хранит запись литерала
+// без потерь для точных режимов вычислений; у мнимого литерала Imag = true,
+// а Value — его мнимая часть. У числа с единицей измерения Value и Text
+// уже переведены в СИ, а Unit и Dim хранят единицу и размерность.
+// У матрицы элементы по строкам лежат в Matrix. Число или матрица,
+// подставленные вместо переменной, сохраняют её имя в Name.
+type Node struct {
+	Kind   NodeKind
+	Op     string
+	Name   string
+	Value  float64
+	Imag   bool
+	Text   string
+	Unit   string
+	Dim    dimension
+	Matrix [][]float64
+	Args   []*Node
+	Pos    int
+}
+
+type opInfo struct {
+	prec        int
+	rightAssoc  bool
+	commutative bool
+	// zeroDivisor — сообщение об ошибке, если правый аргумент — литерал 0.
+	zeroDivisor string
+}
+
+// Унарный минус связывает сильнее умножения, но слабее возведения в степень:
+// -2 ^ 2 = -(2 ^ 2).
+const unaryPrec = 6
+
+// Сравнения и логические операции возвращают 1 (истина) или 0 (ложь);
+// логические операции считают истинным любое ненулевое значение.
+var binaryOps = map[string]opInfo{
+	"||": {prec: 1, commutative: true},
+	"&&": {prec: 2, commutative: true},
+	"==": {prec: 3, commutative: true},
+	"!=": {prec: 3, commutative: true},
+	"<":  {prec: 3},
+	"<=": {prec: 3},
+	">":  {prec: 3},
+	">=": {prec: 3},
+	"+":  {prec: 4, commutative: true},
+	"-":  {prec: 4},
+	"*":  {prec: 5, commutative: true},
+	"/":  {prec: 5, zeroDivisor: "деление на ноль"},
+	"//": {prec: 5, zeroDivisor: "целочисленное деление на ноль"},
+	"%":  {prec: 5, zeroDivisor: "остаток от деления на ноль"},
+	"^":  {prec: 7, rightAssoc: true},
+}
+
+// operatorSpellings упорядочены так, чтобы более длинные операторы
+// распознавались раньше своих префиксов ("//" раньше "/", "<=" раньше "<").
+var operatorSpellings = []string{"//", "==", "!=", "<=", ">=", "&&", "||", "+", "-", "*", "/", "%", "^", "<", ">"}
+
+func tokenize(expression string) ([]token, error) {
+	var tokens []token
+	runes := []rune(expression)
+
+	for i := 0; i < len(runes); {
+		ch := runes[i]
+		switch {
+		case unicode.IsSpace(ch):
+			i++
+		case unicode.IsDigit(ch) || ch == '.':
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			if i < len(runes) && (runes[i] == 'e' || runes[i] == 'E') {
+				j := i + 1
+				if j < len(runes) && (runes[j] == '+' || runes[j] == '-') {
+					j++
+				}
+				if j < len(runes) && unicode.IsDigit(runes[j]) {
+					for i = j; i < len(runes) && unicode.IsDigit(runes[i]); i++ {
+					}
+				}
+			}
+			// Суффикс i делает литерал мнимым: 4i, 2.5i.
+			if i < len(runes) && runes[i] == 'i' && (i+1 == len(runes) || !isIdentRune(runes[i+1])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: string(runes[start:i]), pos: start})
+		case unicode.IsLetter(ch) || ch == '_':
+			start := i
+			for i < len(runes) && isIdentRune(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: string(runes[start:i]), pos: start})
+		case ch == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "(", pos: i})
+			i++
+		case ch == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")", pos: i})
+			i++
+		case ch == ',':
+			tokens = append(tokens, token{kind: tokComma, text: ",", pos: i})
+			i++
+		case ch == '[':
+			tokens = append(tokens, token{kind: tokLBracket, text: "[", pos: i})
+			i++
+		case ch == ']':
+			tokens = append(tokens, token{kind: tokRBracket, text: "]", pos: i})
+			i++
+		default:
+			op := matchOperator(runes[i:])
+			if op == "" {
+				return nil, &ParseError{Message: "неизвестный токен", Token: string(ch), Position: i}
+			}
+			tokens = append(tokens, token{kind: tokOperator, text: op, pos: i})
+			i += len([]rune(op))
+		}
+	}
+	tokens = append(tokens, token{kind: tokEOF, pos: len(runes)})
+	return tokens, nil
+}
+
+func isIdentRune(ch rune) bool {
+	return unicode.IsLetter(ch) || unicode.IsDigit(ch) || ch == '_'
+}
+
+func matchOperator(rest []rune) string {
+	for _, op := range operatorSpellings {
+		spelling := []rune(op)
+		if len(rest) >= len(spelling) && string(rest[:len(spelling)]) == op {
+			return op
+		}
+	}
+	return ""
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) previous() token {
+	if p.pos == 0 {
+		return token{}
+	}
+	return p.tokens[p.pos-1]
+}
+
+// parseExpression разбирает выражение с приоритетом не ниже minPrec.
+func (p *parser) parseExpression(minPrec int) (*Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		info, ok := binaryOps[tok.text]
+		if tok.kind != tokOperator || !ok || info.prec < minPrec {
+			return left, nil
+		}
+		p.next()
+
+		nextPrec := info.prec + 1
+		if info.rightAssoc {
+			nextPrec = info.prec
+		}
+		right, err := p.parseExpression(nextPrec)
+		if err != nil {
+			return nil, err
+		}
+		if info.zeroDivisor != "" && right.Kind == NodeNumber && right.Value == 0 {
+			return nil, &ParseError{Message: info.zeroDivisor, Token: tok.text, Position: tok.pos}
+		}
+		left = &Node{Kind: NodeBinary, Op: tok.text, Args: []*Node{left, right}, Pos: tok.pos}
+	}
+}
+
+func (p *parser) parseUnary() (*Node, error) {
+	tok := p.peek()
+	if tok.kind == tokOperator && (tok.text == "-" || tok.text == "+") {
+		p.next()
+		operand, err := p.parseExpression(unaryPrec)
+		if err != nil {
+			return nil, err
+		}
+		if tok.text == "+" {
+			return operand, nil
+		}
+		if operand.Kind == NodeNumber {
+			operand.Value = -operand.Value
+			operand.Text = negateText(operand.Text)
+			operand.Pos = tok.pos
+			return operand, nil
+		}
+		zero := &Node{Kind: NodeNumber, Pos: tok.pos}
+		return &Node{Kind: NodeBinary, Op: "-", Args: []*Node{zero, operand}, Pos: tok.pos}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (*Node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		imag := strings.HasSuffix(tok.text, "i")
+		value, err := strconv.ParseFloat(strings.TrimSuffix(tok.text, "i"), 64)
+		if err != nil {
+			return nil, &ParseError{Message: "некорректное число", Token: tok.text, Position: tok.pos}
+		}
+		n := &Node{Kind: NodeNumber, Value: value, Imag: imag, Text: tok.text, Pos: tok.pos}
+		// Идентификатор сразу после числа — единица измерения: 5 km, 2 h.
+		if name := p.peek(); name.kind == tokIdent && p.tokens[p.pos+1].kind != tokLParen {
+			if _, ok := units[name.text]; ok {
+				p.next()
+				if imag {
+					return nil, &ParseError{Message: "у мнимого числа не может быть единицы измерения", Token: name.text, Position: name.pos}
+				}
+				withUnit(n, name.text)
+			}
+		}
+		return n, nil
+	case tokLParen:
+		inner, err := p.parseExpression(1)
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, &ParseError{Message: "ожидалась закрывающая скобка", Token: closing.text, Position: closing.pos}
+		}
+		return inner, nil
+	case tokLBracket:
+		return p.parseMatrix(tok)
+	case tokEOF:
+		if prev := p.previous(); prev.kind == tokOperator {
+			return nil, &ParseError{Message: "выражение не может заканчиваться оператором", Token: prev.text, Position: prev.pos}
+		}
+		return nil, &ParseError{Message: "неожиданный конец выражения", Position: tok.pos}
+	case tokIdent:
+		if p.peek().kind == tokLParen {
+			return p.parseCall(tok)
+		}
+		return &Node{Kind: NodeVariable, Name: tok.text, Pos: tok.pos}, nil
+	default:
+		return nil, &ParseError{Message: "ожидалось число", Token: tok.text, Position: tok.pos}
+	}
+}
+
+// parseCall разбирает аргументы вызова функции name(arg, ...) и проверяет
+// их количество.
+func (p *parser) parseCall(name token) (*Node, error) {
+	info, ok := functions[name.text]
+	if !ok || info.internal != "" {
+		return nil, &ParseError{Message: "неизвестная функция", Token: name.text, Position: name.pos}
+	}
+	p.next()
+
+	var args []*Node
+	switch {
+	case info.aggregate && p.peek().kind == tokLBracket:
+		// sum([1, 2, 3]) — то же, что sum(1, 2, 3).
+		list, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, &ParseError{Message: "ожидалась закрывающая скобка", Token: closing.text, Position: closing.pos}
+		}
+		args = list
+	case p.peek().kind == tokRParen:
+		p.next()
+	default:
+		for {
+			arg, err := p.parseExpression(1)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			tok := p.next()
+			if tok.kind == tokRParen {
+				break
+			}
+			if tok.kind != tokComma {
+				return nil, &ParseError{Message: "ожидалась запятая или закрывающая скобка", Token: tok.text, Position: tok.pos}
+			}
+		}
+	}
+
+	if err := info.checkArity(name.text, len(args)); err != nil {
+		return nil, &ParseError{Message: err.Error(), Token: name.text, Position: name.pos}
+	}
+	if info.aggregate {
+		return expandAggregate(name, args), nil
+	}
+	return &Node{Kind: NodeCall, Op: name.text, Args: args, Pos: name.pos}, nil
+}
+
+// parseList разбирает список значений [a, b, ...] аргумента агрегатной
+// функции. В отличие от матрицы, элементами могут быть любые выражения.
+func (p *parser) parseList() ([]*Node, error) {
+	open := p.next()
+	if p.peek().kind == tokRBracket {
+		return nil, &ParseError{Message: "пустой список", Token: open.text, Position: open.pos}
+	}
+	var elems []*Node
+	for {
+		elem, err := p.parseExpression(1)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+
+		tok := p.next()
+		if tok.kind == tokRBracket {
+			return elems, nil
+		}
+		if tok.kind != tokComma {
+			return nil, &ParseError{Message: "ожидалась запятая или закрывающая квадратная скобка", Token: tok.text, Position: tok.pos}
+		}
+	}
+}
+
+// parseMatrix разбирает матричный литерал [[1, 2], [3, 4]]. Одна строка
+// записывается и без внешних скобок: [1, 2, 3] — матрица 1×3.
+func (p *parser) parseMatrix(open token) (*Node, error) {
+	var rows [][]float64
+	if p.peek().kind == tokLBracket {
+		for {
+			rowOpen := p.next()
+			if rowOpen.kind != tokLBracket {
+				return nil, &ParseError{Message: "ожидалась строка матрицы", Token: rowOpen.text, Position: rowOpen.pos}
+			}
+			row, err := p.parseMatrixRow()
+			if err != nil {
+				return nil, err
+			}
+			if len(rows) > 0 && len(row) != len(rows[0]) {
+				return nil, &ParseError{Message: "строки матрицы должны быть одной длины", Token: rowOpen.text, Position: rowOpen.pos}
+			}
+			rows = append(rows, row)
+
+			tok := p.next()
+			if tok.kind == tokRBracket {
+				break
+			}
+			if tok.kind != tokComma {
+				return nil, &ParseError{Message: "ожидалась запятая или закрывающая квадратная скобка", Token: tok.text, Position: tok.pos}
+			}
+		}
+	} else {
+		row, err := p.parseMatrixRow()
+		if err != nil {
+			return nil, err
+		}
+		rows = [][]float64{row}
+	}
+	return &Node{Kind: NodeMatrix, Matrix: rows, Pos: open.pos}, nil
+}
+
+// parseMatrixRow разбирает числа строки до закрывающей квадратной скобки
+// включительно. Элементами могут быть только действительные числа.
+func (p *parser) parseMatrixRow() ([]float64, error) {
+	var row []float64
+	for {
+		start := p.peek()
+		if start.kind == tokRBracket && len(row) == 0 {
+			return nil, &ParseError{Message: "пустая матрица", Token: start.text, Position: start.pos}
+		}
+		elem, err := p.parseExpression(1)
+		if err != nil {
+			return nil, err
+		}
+		if elem.Kind != NodeNumber || elem.Imag || elem.Unit != "" {
+			return nil, &ParseError{Message: "элементом матрицы может быть только действительное число", Token: start.text, Position: start.pos}
+		}
+		row = append(row, elem.Value)
+
+		tok := p.next()
+		if tok.kind == tokRBracket {
+			return row, nil
+		}
+		if tok.kind != tokComma {
+			return nil, &ParseError{Message: "ожидалась запятая или закрывающая квадратная скобка", Token: tok.text, Position: tok.pos}
+		}
+	}
+}
+
+// parseExpressionAST разбирает строку в AST. Выражение без единой операции
+// и без переменных отклоняется: агентам в нём нечего вычислять.
+func parseExpressionAST(expression string) (*Node, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	if p.peek().kind == tokEOF {
+		return nil, &ParseError{Message: "пустое выражение", Position: -1}
+	}
+
+	root, err := p.parseExpression(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok := p.peek(); tok.kind {
+	case tokEOF:
+	case tokRParen:
+		return nil, &ParseError{Message: "лишняя закрывающая скобка", Token: tok.text, Position: tok.pos}
+	default:
+		return nil, &ParseError{Message: "ожидался оператор", Token: tok.text, Position: tok.pos}
+	}
+
+	if root.Kind == NodeNumber || root.Kind == NodeMatrix {
+		return nil, &ParseError{Message: "выражение должно содержать хотя бы одну операцию", Position: -1}
+	}
+	return root, nil
+}
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func negateText(text string) string {
+	if strings.HasPrefix(text, "-") {
+		return text[1:]
+	}
+	return "-" + text
+}
+
+// exactText возвращает запись числа без потерь: литерал как он написан,
+// подставленное значение — в кратчайшей десятичной записи.
+func exactText(n *Node) string {
+	if n.Text != "" {
+		return n.Text
+	}
+	return formatNumber(n.Value)
+}
+
+// numberKey — запись числа для канонического ключа. Литералы, которые
+// различаются только за пределами точности float64, получают разные ключи.
+func numberKey(n *Node) string {
+	short := formatNumber(n.Value)
+	if n.Imag {
+		return short + "i"
+	}
+	if n.Text == "" {
+		return short
+	}
+	exact, ok := new(big.Rat).SetString(n.Text)
+	rounded, _ := new(big.Rat).SetString(short)
+	if ok && rounded != nil && exact.Cmp(rounded) != 0 {
+		return exact.RatString()
+	}
+	return short
+}
+
+// canonical возвращает каноническую запись поддерева: одинаковые по смыслу
+// подвыражения (в том числе с переставленными аргументами коммутативных
+// операций) получают одинаковый ключ.
+func canonical(n *Node) string {
+	key, _ := canonicalKey(n)
+	return key
+}
+
+// canonicalKey дополнительно сообщает, содержит ли поддерево матрицу:
+// произведение матриц не коммутативно, и его аргументы не переставляются.
+func canonicalKey(n *Node) (string, bool) {
+	switch n.Kind {
+	case NodeNumber:
+		return numberKey(n), false
+	case NodeVariable:
+		return n.Name, false
+	case NodeMatrix:
+		return matrixKey(n.Matrix), true
+	}
+
+	args := make([]string, len(n.Args))
+	hasMatrix := false
+	for i, arg := range n.Args {
+		var m bool
+		args[i], m = canonicalKey(arg)
+		hasMatrix = hasMatrix || m
+	}
+	if isCommutative(n) && !(hasMatrix && n.Op == "*") {
+		sort.Strings(args)
+	}
+	return "(" + n.Op + " " + strings.Join(args, " ") + ")", hasMatrix
+}
+
+func isCommutative(n *Node) bool {
+	if n.Kind == NodeCall {
+		return functions[n.Op].commutative
+	}
+	return binaryOps[n.Op].commutative
+}
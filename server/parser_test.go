@@ -0,0 +1,97 @@
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseExpressionAST(t *testing.T) {
+	tests := []struct {
+		expression string
+		canonical  string
+	}{
+		{"2 + 3 * 4", "(+ (* 3 4) 2)"},
+		{"2+3*4", "(+ (* 3 4) 2)"},
+		{"(2 + 3) * 4", "(* (+ 2 3) 4)"},
+		{"10 - 4 - 3", "(- (- 10 4) 3)"},
+		{"8 / 4 / 2", "(/ (/ 8 4) 2)"},
+		{"-2 * 3", "(* -2 3)"},
+		{"2 - -3", "(- 2 -3)"},
+		{"-(1 + 2) * 3", "(* (- 0 (+ 1 2)) 3)"},
+		{"1.5e2 + .5", "(+ 0.5 150)"},
+		{"2 ^ 3 ^ 2", "(^ 2 (^ 3 2))"},
+		{"-2 ^ 2", "(- 0 (^ 2 2))"},
+		{"2 ^ -1", "(^ 2 -1)"},
+		{"2 * 3 ^ 2", "(* (^ 3 2) 2)"},
+		{"7 // 2 % 3", "(% (// 7 2) 3)"},
+		{"7//2", "(// 7 2)"},
+		{"max(2, sqrt(16)) * 3", "(* (max (sqrt 16) 2) 3)"},
+		{"max(3, 1 + 1)", "(max (+ 1 1) 3)"},
+		{"log(8, 2) ^ 2", "(^ (log 8 2) 2)"},
+		{"-abs(-4)", "(- 0 (abs -4))"},
+		{"(3 + 4i) * (1 - 2.5i)", "(* (+ 3 4i) (- 1 2.5i))"},
+		{"-2i * 3", "(* -2i 3)"},
+	}
+
+	for _, tt := range tests {
+		root, err := parseExpressionAST(tt.expression)
+		if err != nil {
+			t.Errorf("❌ %q: неожиданная ошибка %v", tt.expression, err)
+			continue
+		}
+		if got := canonical(root); got != tt.canonical {
+			t.Errorf("❌ %q: ожидалось %s, получено %s", tt.expression, tt.canonical, got)
+		}
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		message    string
+		position   int
+	}{
+		{"", "пустое выражение", -1},
+		{"42", "выражение должно содержать хотя бы одну операцию", -1},
+		{"(2 + 3", "ожидалась закрывающая скобка", 6},
+		{"2 + 3)", "лишняя закрывающая скобка", 5},
+		{"2 3", "ожидался оператор", 2},
+		{"2 $ 3", "неизвестный токен", 2},
+		{"4 / (2 - 2) + 1 / 0", "деление на ноль", 16},
+		{"5 % 0", "остаток от деления на ноль", 2},
+		{"5 // (0)", "целочисленное деление на ноль", 2},
+		{"2 ^", "выражение не может заканчиваться оператором", 2},
+		{"1 + foo(2)", "неизвестная функция", 4},
+		{"1 + sqrt(4, 9)", "функция sqrt ожидает аргументов: 1, передано 2", 4},
+		{"max() + 1", "функция max ожидает аргументов: не меньше 1, передано 0", 0},
+		{"log(1, 2, 3)", "функция log ожидает аргументов: от 1 до 2, передано 3", 0},
+		{"max(1 2)", "ожидалась запятая или закрывающая скобка", 6},
+		{"sqrt(4", "ожидалась запятая или закрывающая скобка", 6},
+	}
+
+	for _, tt := range tests {
+		_, err := parseExpressionAST(tt.expression)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("❌ %q: ожидалась ParseError, получено %v", tt.expression, err)
+			continue
+		}
+		if perr.Message != tt.message || perr.Position != tt.position {
+			t.Errorf("❌ %q: ожидалось %q на позиции %d, получено %q на позиции %d",
+				tt.expression, tt.message, tt.position, perr.Message, perr.Position)
+		}
+	}
+}
+
+func TestCanonicalCommutative(t *testing.T) {
+	a, _ := parseExpressionAST("(1 + 2) * (3 - 4)")
+	b, _ := parseExpressionAST("(3 - 4) * (2 + 1)")
+	c, _ := parseExpressionAST("(4 - 3) * (2 + 1)")
+
+	if canonical(a) != canonical(b) {
+		t.Errorf("❌ Коммутативные выражения должны совпадать: %s и %s", canonical(a), canonical(b))
+	}
+	if canonical(a) == canonical(c) {
+		t.Errorf("❌ Вычитание не коммутативно: %s и %s", canonical(a), canonical(c))
+	}
+}
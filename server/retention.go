@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	retentionMaxAge   time.Duration
+	retentionMaxCount int
+	janitorInterval   time.Duration
+)
+
+// removeExpression вызывается под mutex и удаляет выражение из всех индексов.
+func removeExpression(id string) {
+	expr, ok := store[id]
+	if !ok {
+		return
+	}
+	delete(store, id)
+	unindexExpression(expr)
+	deadlines.remove(id)
+	for _, t := range expr.Tasks {
+		delete(taskOwner, t.ID)
+	}
+	if record, ok := idempotencyKeys[expr.IdempotencyKey]; ok && record.exprID == id {
+		delete(idempotencyKeys, expr.IdempotencyKey)
+	}
+
+	deliveriesMu.Lock()
+	delete(deliveries, id)
+	deliveriesMu.Unlock()
+}
+
+// hasLiveTasks сообщает, что у завершённого выражения остались задачи,
+// результата которых ждут другие выражения. Такие выражения не удаляются.
+func hasLiveTasks(expr Expression) bool {
+	for _, t := range expr.Tasks {
+		if t.Status != TaskDone && t.Status != TaskCancelled {
+			return true
+		}
+	}
+	return false
+}
+
+// purgeFinished удаляет завершённые выражения, подходящие под фильтр.
+// Пустой statuses означает любой конечный статус.
+func purgeFinished(statuses map[Status]bool, finishedBefore time.Time) int {
+	var ids []string
+	for _, key := range exprOrder {
+		expr := store[key.id]
+		if !expr.Status.IsTerminal() || expr.FinishedAt == nil || hasLiveTasks(expr) {
+			continue
+		}
+		if len(statuses) > 0 && !statuses[expr.Status] {
+			continue
+		}
+		if !finishedBefore.IsZero() && !expr.FinishedAt.Before(finishedBefore) {
+			continue
+		}
+		ids = append(ids, key.id)
+	}
+	for _, id := range ids {
+		removeExpression(id)
+	}
+	return len(ids)
+}
+
+// enforceRetention применяет политику хранения: сначала по возрасту,
+// затем по количеству, удаляя самые старые завершённые выражения.
+func enforceRetention(now time.Time) int {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	purged := 0
+	if retentionMaxAge > 0 {
+		purged += purgeFinished(nil, now.Add(-retentionMaxAge))
+	}
+
+	if retentionMaxCount > 0 {
+		var finished []string
+		for _, key := range exprOrder {
+			if expr := store[key.id]; expr.Status.IsTerminal() && !hasLiveTasks(expr) {
+				finished = append(finished, key.id)
+			}
+		}
+		for i := 0; i < len(finished)-retentionMaxCount; i++ {
+			removeExpression(finished[i])
+			purged++
+		}
+	}
+
+	if purged > 0 {
+		fmt.Printf("Очистка: удалено выражений %d, осталось %d\n", purged, len(store))
+	}
+	return purged
+}
+
+func runJanitor() {
+	if janitorInterval <= 0 {
+		return
+	}
+	for now := range time.Tick(janitorInterval) {
+		enforceRetention(now)
+	}
+}
+
+func purgeExpressions(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	statuses := make(map[Status]bool)
+	if v := query.Get("status"); v != "" {
+		for _, s := range strings.Split(v, ",") {
+			status := Status(strings.TrimSpace(s))
+			if !status.IsTerminal() {
+				writeError(w, http.StatusBadRequest, ErrCodeInvalidQuery,
+					"удалять можно только завершённые выражения", map[string]interface{}{"status": status})
+				return
+			}
+			statuses[status] = true
+		}
+	}
+
+	var finishedBefore time.Time
+	if v := query.Get("older_than"); v != "" {
+		age, err := time.ParseDuration(v)
+		if err != nil || age < 0 {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidQuery,
+				"older_than должен быть длительностью, например 30m или 24h", map[string]interface{}{"older_than": v})
+			return
+		}
+		finishedBefore = time.Now().Add(-age)
+	}
+
+	mutex.Lock()
+	purged := purgeFinished(statuses, finishedBefore)
+	mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"purged": purged})
+}
+
+func expressionsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		getAllExpressions(w, r)
+	case http.MethodDelete:
+		purgeExpressions(w, r)
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func seedFinished(t *testing.T, now time.Time) {
+	t.Helper()
+
+	originalStore, originalOrder, originalOwner := store, exprOrder, taskOwner
+	t.Cleanup(func() {
+		mutex.Lock()
+		store, exprOrder, taskOwner = originalStore, originalOrder, originalOwner
+		mutex.Unlock()
+	})
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	store, exprOrder, taskOwner = make(map[string]Expression), nil, make(map[string]string)
+
+	add := func(id string, status Status, age time.Duration) {
+		expr := Expression{
+			ID:        id,
+			Expr:      "1 + 1",
+			Status:    status,
+			CreatedAt: now.Add(-age - time.Second),
+			Tasks:     []Task{{ID: id + "_task", Operation: "+", Status: TaskDispatched}},
+		}
+		if status.IsTerminal() {
+			finished := now.Add(-age)
+			expr.FinishedAt = &finished
+			expr.Tasks[0].Status = TaskDone
+		}
+		saveNewExpression(expr)
+	}
+	add("old_done", StatusDone, 48*time.Hour)
+	add("old_error", StatusError, 36*time.Hour)
+	add("old_running", StatusInProgress, 30*time.Hour)
+	add("new_done", StatusDone, time.Minute)
+	add("new_cancelled", StatusCancelled, time.Second)
+}
+
+func remainingIDs() map[string]bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	ids := make(map[string]bool)
+	for id := range store {
+		ids[id] = true
+	}
+	return ids
+}
+
+func TestRetentionByAge(t *testing.T) {
+	now := time.Now()
+	seedFinished(t, now)
+
+	originalAge, originalCount := retentionMaxAge, retentionMaxCount
+	retentionMaxAge, retentionMaxCount = 24*time.Hour, 0
+	defer func() { retentionMaxAge, retentionMaxCount = originalAge, originalCount }()
+
+	if purged := enforceRetention(now); purged != 2 {
+		t.Fatalf("❌ Ожидалось удаление 2 выражений, удалено %d", purged)
+	}
+
+	ids := remainingIDs()
+	if ids["old_done"] || ids["old_error"] || !ids["old_running"] || !ids["new_done"] {
+		t.Fatalf("❌ Неожиданный набор выражений после очистки: %v", ids)
+	}
+
+	mutex.Lock()
+	owner, indexed := taskOwner["old_done_task"], len(exprOrder)
+	mutex.Unlock()
+	if owner != "" || indexed != 3 {
+		t.Fatalf("❌ Индексы не очищены: владелец задачи %q, записей в индексе %d", owner, indexed)
+	}
+}
+
+func TestRetentionByCount(t *testing.T) {
+	now := time.Now()
+	seedFinished(t, now)
+
+	originalAge, originalCount := retentionMaxAge, retentionMaxCount
+	retentionMaxAge, retentionMaxCount = 0, 1
+	defer func() { retentionMaxAge, retentionMaxCount = originalAge, originalCount }()
+
+	enforceRetention(now)
+
+	ids := remainingIDs()
+	if len(ids) != 2 || !ids["old_running"] || !ids["new_cancelled"] {
+		t.Fatalf("❌ Должны остаться незавершённое и самое новое завершённое выражение: %v", ids)
+	}
+}
+
+func TestPurgeEndpoint(t *testing.T) {
+	testName := "TestPurgeEndpoint"
+	seedFinished(t, time.Now())
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/expressions?status=done&older_than=1h", nil)
+	rr := httptest.NewRecorder()
+	expressionsHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("❌ [%s] Ожидался статус %d, но получен %d", testName, http.StatusOK, rr.Code)
+	}
+	var resp map[string]int
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp["purged"] != 1 || remainingIDs()["old_done"] {
+		t.Fatalf("❌ [%s] Ожидалось удаление только old_done, ответ %v", testName, resp)
+	}
+
+	for _, query := range []string{"status=in_progress", "older_than=yesterday"} {
+		rr = httptest.NewRecorder()
+		expressionsHandler(rr, httptest.NewRequest(http.MethodDelete, "/api/v1/expressions?"+query, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("❌ [%s] %s: ожидался статус %d, но получен %d", testName, query, http.StatusBadRequest, rr.Code)
+		}
+	}
+
+	fmt.Printf("[%s] прошел успешно!\n", testName)
+}
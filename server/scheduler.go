@@ -0,0 +1,264 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+)
+
+// Планировщик выбирает, какую готовую задачу отдать агенту. По умолчанию
+// (SCHEDULER_POLICY=heft) очередь упорядочена по рангу, как в списочном
+// планировании HEFT: ранг задачи — оценка времени от её начала до конца
+// выражения, то есть её длительность плюс наибольший ранг задач, которые
+// ждут её результата. Первыми уходят задачи критического пути, и длинная
+// цепочка широкого выражения не ждёт, пока агенты разберут короткие
+// задачи, поставленные в очередь раньше неё. При SCHEDULER_POLICY=fifo
+// задачи выдаются в порядке готовности.
+//
+// Длительности сервер узнаёт из выполненных задач: агент сообщает время
+// вычисления в duration_ms, а если не сообщил — берётся время от выдачи
+// задачи до результата. Оценка операции — экспоненциальное скользящее
+// среднее замеров; пока замеров нет, используются задержки TIME_*_MS
+// (operationDelay). Замеры агента, передающего заголовок X-Agent-ID,
+// собираются отдельно, но только для статистики: ранги считаются по
+// средним всех агентов, и выбор задачи от агента не зависит.
+
+const (
+	PolicyHEFT = "heft"
+	PolicyFIFO = "fifo"
+
+	// timingWeight — вес нового замера в скользящем среднем.
+	timingWeight = 0.2
+)
+
+type timing struct {
+	Samples int     `json:"samples"`
+	AvgMs   float64 `json:"avg_ms"`
+}
+
+func (t *timing) observe(ms float64) {
+	if t.Samples == 0 {
+		t.AvgMs = ms
+	} else {
+		t.AvgMs += timingWeight * (ms - t.AvgMs)
+	}
+	t.Samples++
+}
+
+type agentTimings struct {
+	Tasks      int                `json:"tasks"`
+	BusyMs     float64            `json:"busy_ms"`
+	Operations map[string]*timing `json:"operations"`
+	LastSeen   time.Time          `json:"last_seen"`
+}
+
+// dispatch — выдача задачи агенту.
+type dispatch struct {
+	agent     string
+	operation string
+	at        time.Time
+}
+
+// scheduleTotals — сводка по выполненным выражениям: насколько время
+// выполнения (makespan) близко к оценке критического пути.
+type scheduleTotals struct {
+	Expressions    int
+	MakespanMs     float64
+	CriticalPathMs float64
+}
+
+var (
+	schedulerPolicy = PolicyHEFT
+
+	// Всё ниже защищено mutex.
+	// operationTimings: операция -> замеры по всем агентам.
+	operationTimings = make(map[string]*timing)
+	// agentStats: X-Agent-ID -> замеры агента.
+	agentStats = make(map[string]*agentTimings)
+	// dispatched: ID задачи -> кому и когда она выдана.
+	dispatched = make(map[string]dispatch)
+	schedule   scheduleTotals
+)
+
+// estimateDuration — ожидаемое время задачи в миллисекундах.
+func estimateDuration(op string) float64 {
+	if op == opIf || op == opAssemble {
+		return 0
+	}
+	if t, ok := operationTimings[op]; ok {
+		return t.AvgMs
+	}
+	return float64(operationDelay(op))
+}
+
+// rankTasks вызывается под mutex и записывает ранги задач выражения.
+// Задачи идут в порядке обхода в глубину: зависимости раньше зависящих.
+func rankTasks(list []Task) {
+	index := make(map[string]int, len(list))
+	for i, t := range list {
+		index[t.ID] = i
+	}
+	successors := make([]float64, len(list))
+	for i := len(list) - 1; i >= 0; i-- {
+		rank := estimateDuration(list[i].Operation) + successors[i]
+		list[i].Rank = int(math.Round(rank))
+		for _, dep := range list[i].dependencies() {
+			if j, ok := index[dep]; ok && rank > successors[j] {
+				successors[j] = rank
+			}
+		}
+	}
+}
+
+// updateQueuedRanks вызывается под mutex после пересчёта рангов выражения
+// и переносит их в копии его задач, которые уже стоят в очереди.
+func updateQueuedRanks(list []Task) {
+	ranks := make(map[string]int, len(list))
+	for _, t := range list {
+		ranks[t.ID] = t.Rank
+	}
+	for i := range tasks {
+		if rank, ok := ranks[tasks[i].ID]; ok {
+			tasks[i].Rank = rank
+		}
+	}
+}
+
+// nextTask вызывается под mutex для непустой очереди и возвращает индекс
+// задачи, которую нужно выдать. При равных рангах раньше уходит задача,
+// которая раньше стала готовой.
+func nextTask() int {
+	best := 0
+	if schedulerPolicy == PolicyFIFO {
+		return best
+	}
+	for i, t := range tasks {
+		if t.Rank > tasks[best].Rank {
+			best = i
+		}
+	}
+	return best
+}
+
+// recordDispatch вызывается под mutex при выдаче задачи агенту.
+func recordDispatch(task Task, agent string, now time.Time) {
+	dispatched[task.ID] = dispatch{agent: agent, operation: task.Operation, at: now}
+}
+
+// recordCompletion вызывается под mutex при успешном результате задачи.
+// reportedMs — время вычисления по словам агента, 0 — не сообщено.
+func recordCompletion(taskID string, reportedMs float64, now time.Time) {
+	d, ok := dispatched[taskID]
+	if !ok {
+		return
+	}
+	delete(dispatched, taskID)
+
+	ms := reportedMs
+	if ms <= 0 {
+		ms = float64(now.Sub(d.at).Microseconds()) / 1000
+	}
+	op, ok := operationTimings[d.operation]
+	if !ok {
+		op = &timing{}
+		operationTimings[d.operation] = op
+	}
+	op.observe(ms)
+
+	if d.agent == "" {
+		return
+	}
+	a, ok := agentStats[d.agent]
+	if !ok {
+		a = &agentTimings{Operations: make(map[string]*timing)}
+		agentStats[d.agent] = a
+	}
+	at, ok := a.Operations[d.operation]
+	if !ok {
+		at = &timing{}
+		a.Operations[d.operation] = at
+	}
+	at.observe(ms)
+	a.Tasks++
+	a.BusyMs += ms
+	a.LastSeen = now
+}
+
+// recordMakespan вызывается под mutex, когда выражение успешно вычислено.
+func recordMakespan(expr Expression) {
+	if expr.StartedAt == nil || expr.FinishedAt == nil || len(expr.Tasks) == 0 {
+		return
+	}
+	critical := 0
+	for _, t := range expr.Tasks {
+		critical = max(critical, t.Rank)
+	}
+	schedule.Expressions++
+	schedule.MakespanMs += float64(expr.FinishedAt.Sub(*expr.StartedAt).Microseconds()) / 1000
+	schedule.CriticalPathMs += float64(critical)
+}
+
+func getSchedulerStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	type operationStats struct {
+		Samples    int     `json:"samples"`
+		AvgMs      float64 `json:"avg_ms"`
+		DefaultMs  int     `json:"default_ms"`
+		EstimateMs float64 `json:"estimate_ms"`
+	}
+	type agentSummary struct {
+		agentTimings
+		// Speed — во сколько раз агент быстрее среднего на тех же операциях.
+		Speed float64 `json:"speed"`
+	}
+
+	mutex.Lock()
+	operations := make(map[string]operationStats, len(operationTimings))
+	for op, t := range operationTimings {
+		operations[op] = operationStats{Samples: t.Samples, AvgMs: t.AvgMs, DefaultMs: operationDelay(op), EstimateMs: estimateDuration(op)}
+	}
+	agents := make(map[string]agentSummary, len(agentStats))
+	for id, a := range agentStats {
+		s := agentSummary{agentTimings: *a, Speed: 1}
+		s.Operations = make(map[string]*timing, len(a.Operations))
+		var expected, actual float64
+		for op, t := range a.Operations {
+			copied := *t
+			s.Operations[op] = &copied
+			expected += float64(t.Samples) * operationTimings[op].AvgMs
+			actual += float64(t.Samples) * t.AvgMs
+		}
+		if actual > 0 {
+			s.Speed = expected / actual
+		}
+		agents[id] = s
+	}
+	totals := schedule
+	queued, running := len(tasks), len(dispatched)
+	mutex.Unlock()
+
+	expressions := map[string]interface{}{"completed": totals.Expressions}
+	if totals.Expressions > 0 {
+		n := float64(totals.Expressions)
+		expressions["avg_makespan_ms"] = totals.MakespanMs / n
+		expressions["avg_critical_path_ms"] = totals.CriticalPathMs / n
+		if totals.MakespanMs > 0 {
+			expressions["efficiency"] = totals.CriticalPathMs / totals.MakespanMs
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"policy":      schedulerPolicy,
+		"queued":      queued,
+		"dispatched":  running,
+		"operations":  operations,
+		"agents":      agents,
+		"expressions": expressions,
+	})
+}
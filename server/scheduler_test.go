@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fetchTask запрашивает задачу от имени агента; пустая задача — очередь пуста.
+func fetchTask(t *testing.T, agent string) Task {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/internal/task", nil)
+	req.Header.Set("X-Agent-ID", agent)
+	rr := httptest.NewRecorder()
+	getTask(rr, req)
+	if rr.Code == http.StatusNotFound {
+		return Task{}
+	}
+	var resp struct {
+		Task Task `json:"task"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	return resp.Task
+}
+
+func reportTask(t *testing.T, id string, result float64, durationMs int) {
+	t.Helper()
+
+	body := fmt.Sprintf(`{"id": %q, "result": %v, "duration_ms": %d}`, id, result, durationMs)
+	rr := httptest.NewRecorder()
+	completeTask(rr, httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBufferString(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("❌ Результат задачи %s отклонён: %d %s", id, rr.Code, rr.Body.String())
+	}
+}
+
+func setPolicy(t *testing.T, policy string) {
+	saved := schedulerPolicy
+	schedulerPolicy = policy
+	t.Cleanup(func() { schedulerPolicy = saved })
+}
+
+// simulate выполняет выражение агентами с виртуальным временем: каждая
+// задача занимает агента на operationDelay мс. Возвращает время выполнения.
+func simulate(t *testing.T, expression string, agents int) int {
+	t.Helper()
+
+	id := responseID(submitBody(fmt.Sprintf(`{"expression": %q}`, expression)))
+	type running struct {
+		task   Task
+		finish int
+	}
+	now := 0
+	var busy []running
+	for {
+		for len(busy) < agents {
+			task := fetchTask(t, fmt.Sprintf("agent-%d", len(busy)))
+			if task.ID == "" {
+				break
+			}
+			busy = append(busy, running{task: task, finish: now + operationDelay(task.Operation)})
+		}
+		if len(busy) == 0 {
+			break
+		}
+		next := 0
+		for i, r := range busy {
+			if r.finish < busy[next].finish {
+				next = i
+			}
+		}
+		r := busy[next]
+		busy = append(busy[:next], busy[next+1:]...)
+		now = r.finish
+
+		result := r.task.Arg1 + r.task.Arg2
+		if r.task.Operation == "sqrt" {
+			result = math.Sqrt(r.task.Args[0])
+		}
+		reportTask(t, r.task.ID, result, operationDelay(r.task.Operation))
+	}
+
+	if status, _ := expressionResult(t, id); status != StatusDone {
+		t.Fatalf("❌ %s: ожидался статус done, получен %s", expression, status)
+	}
+	return now
+}
+
+func TestRankPrioritizesCriticalPath(t *testing.T) {
+	isolateState(t)
+	setDelays(t)
+
+	id := responseID(submitBody(`{"expression": "(1 + 2) + sqrt(sqrt(3))"}`))
+	mutex.Lock()
+	ranks := make([]int, 0, 4)
+	for _, task := range store[id].Tasks {
+		ranks = append(ranks, task.Rank)
+	}
+	mutex.Unlock()
+	// 1+2: 100 + 100; sqrt(3): 200 + 200 + 100; sqrt(t2): 200 + 100; корень: 100.
+	if fmt.Sprint(ranks) != "[200 500 300 100]" {
+		t.Fatalf("❌ Неожиданные ранги %v", ranks)
+	}
+	if task := fetchTask(t, "a"); task.Operation != "sqrt" {
+		t.Errorf("❌ Первой должна уйти задача критического пути, получена %s", task.Operation)
+	}
+
+	isolateState(t)
+	setPolicy(t, PolicyFIFO)
+	submitBody(`{"expression": "(1 + 2) + sqrt(sqrt(3))"}`)
+	if task := fetchTask(t, "a"); task.Operation != "+" {
+		t.Errorf("❌ При fifo первой уходит первая готовая задача, получена %s", task.Operation)
+	}
+}
+
+func TestHEFTShortensWideExpression(t *testing.T) {
+	setDelays(t)
+	expression := "(1 + 2) + (3 + 4) + (5 + 6) + (7 + 8) + sqrt(sqrt(sqrt(9)))"
+
+	isolateState(t)
+	setPolicy(t, PolicyFIFO)
+	fifo := simulate(t, expression, 2)
+
+	isolateState(t)
+	setPolicy(t, PolicyHEFT)
+	heft := simulate(t, expression, 2)
+
+	if fifo != 900 || heft != 800 {
+		t.Errorf("❌ Ожидалось 900 мс при fifo и 800 мс при heft, получено %d и %d", fifo, heft)
+	}
+}
+
+func TestSchedulerLearnsDurations(t *testing.T) {
+	isolateState(t)
+	setDelays(t)
+
+	submitBody(`{"expression": "(1 + 2) * (3 + 4)"}`)
+	fast, slow := fetchTask(t, "fast"), fetchTask(t, "slow")
+	reportTask(t, fast.ID, 3, 50)
+	reportTask(t, slow.ID, 7, 150)
+
+	mutex.Lock()
+	estimate := estimateDuration("+")
+	fastTasks, slowBusy := agentStats["fast"].Tasks, agentStats["slow"].BusyMs
+	mutex.Unlock()
+	// Первый замер 50, затем 50 + 0.2 * (150 - 50).
+	if estimate != 70 || fastTasks != 1 || slowBusy != 150 {
+		t.Errorf("❌ Ожидались оценка 70 мс, 1 задача fast и 150 мс у slow, получено %v, %d, %v", estimate, fastTasks, slowBusy)
+	}
+
+	// Новые выражения ранжируются по выученной оценке.
+	id := responseID(submitBody(`{"expression": "5 + 6"}`))
+	mutex.Lock()
+	rank := store[id].Tasks[0].Rank
+	mutex.Unlock()
+	if rank != 70 {
+		t.Errorf("❌ Ожидался ранг 70 по выученной оценке, получено %d", rank)
+	}
+
+	rr := httptest.NewRecorder()
+	getSchedulerStats(rr, httptest.NewRequest(http.MethodGet, "/api/v1/stats/scheduler", nil))
+	var resp struct {
+		Policy     string `json:"policy"`
+		Operations map[string]struct {
+			Samples   int `json:"samples"`
+			DefaultMs int `json:"default_ms"`
+		} `json:"operations"`
+		Agents map[string]struct {
+			Tasks int     `json:"tasks"`
+			Speed float64 `json:"speed"`
+		} `json:"agents"`
+		Dispatched int `json:"dispatched"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Policy != PolicyHEFT || resp.Operations["+"].Samples != 2 || resp.Operations["+"].DefaultMs != 100 || resp.Dispatched != 0 {
+		t.Errorf("❌ Неожиданная статистика %+v", resp)
+	}
+	if resp.Agents["fast"].Speed <= 1 || resp.Agents["slow"].Speed >= 1 {
+		t.Errorf("❌ fast должен быть быстрее среднего, а slow — медленнее: %+v", resp.Agents)
+	}
+}
+
+func TestBranchRerankUpdatesQueue(t *testing.T) {
+	isolateState(t)
+	setDelays(t)
+	t.Setenv("TIME_COMPARISONS_MS", "300")
+
+	// Ранги: * — 300, if — 300, > — 600, 3 + 4 — 400.
+	submitBody(`{"expression": "if(x > 0, sqrt(2), 0) * (3 + 4)", "variables": {"x": 1}}`)
+	cond := fetchTask(t, "a")
+	if cond.Operation != ">" {
+		t.Fatalf("❌ Первым должно уйти условие, получено %s", cond.Operation)
+	}
+
+	// Пока условие считалось, сложение оказалось медленным. После выбора
+	// ветви ранг 3 + 4 — 1000 + 300, выше, чем у sqrt(2) — 200 + 300.
+	mutex.Lock()
+	operationTimings["+"] = &timing{Samples: 1, AvgMs: 1000}
+	mutex.Unlock()
+	reportTask(t, cond.ID, 1, 300)
+
+	mutex.Lock()
+	var ranks []int
+	for _, task := range tasks {
+		ranks = append(ranks, task.Rank)
+	}
+	mutex.Unlock()
+	if fmt.Sprint(ranks) != "[1300 500]" {
+		t.Fatalf("❌ Ранги в очереди должны обновиться, получено %v", ranks)
+	}
+	if task := fetchTask(t, "a"); task.Operation != "+" {
+		t.Errorf("❌ Следующим должно уйти сложение, получено %s", task.Operation)
+	}
+}
+
+func TestSchedulerMakespanStats(t *testing.T) {
+	isolateState(t)
+	setDelays(t)
+
+	simulate(t, "(1 + 2) * 3", 1)
+
+	rr := httptest.NewRecorder()
+	getSchedulerStats(rr, httptest.NewRequest(http.MethodGet, "/api/v1/stats/scheduler", nil))
+	var resp struct {
+		Expressions struct {
+			Completed    int     `json:"completed"`
+			CriticalPath float64 `json:"avg_critical_path_ms"`
+		} `json:"expressions"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Expressions.Completed != 1 || resp.Expressions.CriticalPath != 400 {
+		t.Errorf("❌ Ожидалось 1 выражение с критическим путём 400 мс, получено %+v", resp.Expressions)
+	}
+}
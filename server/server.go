@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/joho/godotenv"
 )
 
 type Server interface {
@@ -27,11 +30,32 @@ func StartServer() {
 var ActiveServer Server = &DefaultServer{}
 
 type Expression struct {
-	ID     string   `json:"id"`
-	Expr   string   `json:"expression"`
-	Status string   `json:"status"`
-	Result *float64 `json:"result,omitempty"`
-	Tasks  []Task   `json:"tasks,omitempty"`
+	ID      string        `json:"id"`
+	Expr    string        `json:"expression"`
+	Status  Status        `json:"status"`
+	Result  *float64      `json:"result,omitempty"`
+	Complex *ComplexValue `json:"complex,omitempty"`
+	Value   string        `json:"value,omitempty"`
+	Matrix  [][]float64   `json:"matrix,omitempty"`
+	Unit    string        `json:"unit,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	Tasks   []Task        `json:"tasks,omitempty"`
+
+	Mode      string `json:"mode,omitempty"`
+	Precision int    `json:"precision,omitempty"`
+	// Optimize — перед построением задач дерево оптимизировалось (optimize.go).
+	Optimize bool `json:"optimize,omitempty"`
+
+	Formula   string                 `json:"formula,omitempty"`
+	Variables Variables              `json:"variables,omitempty"`
+	Matrices  map[string][][]float64 `json:"matrices,omitempty"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	CallbackURL    string `json:"callback_url,omitempty"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
 }
 
 type Task struct {
@@ -39,124 +63,224 @@ type Task struct {
 	Arg1      float64 `json:"arg1"`
 	Arg2      float64 `json:"arg2"`
 	Operation string  `json:"operation"`
+	// Args — аргументы вызова функции; у бинарных операций используются Arg1 и Arg2.
+	Args []float64 `json:"args,omitempty"`
+	// Operands — точные значения аргументов в точных режимах (decimal, rational, int64, bigint).
+	Mode      string   `json:"mode,omitempty"`
+	Precision int      `json:"precision,omitempty"`
+	Operands  []string `json:"operands,omitempty"`
+	// Mat1 и Mat2 — матричные аргументы операций над матрицами (matrix.go);
+	// агент получает их в задаче, в ответе о выражении они не показываются.
+	Mat1  [][]float64  `json:"-"`
+	Mat2  [][]float64  `json:"-"`
+	Block *MatrixBlock `json:"block,omitempty"`
+	// size — ожидаемый размер матричного результата задачи.
+	size shape
+	// Unit — единица результата задачи: значения приведены к СИ, у корневой
+	// задачи — единица результата выражения.
+	Unit string `json:"unit,omitempty"`
+	// Rank — оценка времени от начала задачи до конца выражения, по ней
+	// планировщик выбирает задачу для агента (scheduler.go).
+	Rank int `json:"rank_ms,omitempty"`
+
+	Arg1Task   string      `json:"arg1_task,omitempty"`
+	Arg2Task   string      `json:"arg2_task,omitempty"`
+	ArgTasks   []string    `json:"arg_tasks,omitempty"`
+	SharedWith string      `json:"shared_with,omitempty"`
+	Key        string      `json:"key,omitempty"`
+	Status     TaskStatus  `json:"status,omitempty"`
+	Result     *float64    `json:"result,omitempty"`
+	Value      string      `json:"value,omitempty"`
+	Matrix     [][]float64 `json:"matrix,omitempty"`
 }
 
 var (
-	store = make(map[string]Expression)
-	tasks = []Task{}
-	mutex sync.Mutex
+	store     = make(map[string]Expression)
+	taskOwner = make(map[string]string)
+	tasks     = []Task{}
+	mutex     sync.Mutex
+
+	// taskBatchLimit — сколько задач агент может получить или сдать за один
+	// запрос к /internal/task.
+	taskBatchLimit = 100
 )
 
-func generateID() string {
-	return strconv.FormatInt(time.Now().UnixNano(), 10)
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("Не удалось загрузить .env файл, сервер использует стандартные значения")
+	}
+
+	webhookMaxAttempts = getEnvInt("WEBHOOK_MAX_ATTEMPTS", 5)
+	webhookBackoff = time.Duration(getEnvInt("WEBHOOK_BACKOFF_MS", 500)) * time.Millisecond
+	expressionTimeout = time.Duration(getEnvInt("EXPRESSION_TIMEOUT_MS", 300000)) * time.Millisecond
+	retentionMaxAge = time.Duration(getEnvInt("RETENTION_MAX_AGE_MS", 86400000)) * time.Millisecond
+	retentionMaxCount = getEnvInt("RETENTION_MAX_COUNT", 10000)
+	janitorInterval = time.Duration(getEnvInt("JANITOR_INTERVAL_MS", 60000)) * time.Millisecond
+	memo = newLRUCache(getEnvInt("MEMO_CACHE_SIZE", 10000))
+	idempotencyWindow = time.Duration(getEnvInt("IDEMPOTENCY_WINDOW_MS", 86400000)) * time.Millisecond
+	formulaBatchLimit = getEnvInt("FORMULA_BATCH_LIMIT", 1000)
+	decimalPrecision = getEnvInt("DECIMAL_PRECISION", 34)
+	matrixBlockSize = getEnvInt("MATRIX_BLOCK_SIZE", 64)
+	listChunkSize = getEnvInt("LIST_CHUNK_SIZE", 16)
+	taskBatchLimit = max(getEnvInt("TASK_BATCH_LIMIT", 100), 1)
+	if os.Getenv("SCHEDULER_POLICY") == PolicyFIFO {
+		schedulerPolicy = PolicyFIFO
+	}
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	valueStr, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	value, err := strconv.Atoi(valueStr)
+	if err != nil {
+		return defaultValue
+	}
+	return value
 }
 
 func StartServerLogic() {
 	http.HandleFunc("/api/v1/calculate", addExpression)
-	http.HandleFunc("/api/v1/expressions", getAllExpressions)
-	http.HandleFunc("/api/v1/expressions/", getExpression)
+	http.HandleFunc("/api/v1/expressions", expressionsHandler)
+	http.HandleFunc("/api/v1/expressions/", expressionHandler)
+	http.HandleFunc("/api/v1/formulas", formulasHandler)
+	http.HandleFunc("/api/v1/formulas/", formulaHandler)
+	http.HandleFunc("/api/v1/explain", explainExpression)
+	http.HandleFunc("/api/v1/derivative", derivativeHandler)
+	http.HandleFunc("/api/v1/events", getAllEvents)
+	http.HandleFunc("/api/v1/stats/cache", getCacheStats)
+	http.HandleFunc("/api/v1/stats/scheduler", getSchedulerStats)
 	http.HandleFunc("/internal/task", internalTaskHandler)
+	go watchTimeouts(nil)
+	go runJanitor()
 	log.Println("Сервер запущен на порту 8080...")
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
 
-func parseExpressionIntoTasks(expressionID string, expression string) ([]Task, error) {
-	tokens := strings.Fields(expression)
-	if len(tokens) < 3 {
-		return nil, fmt.Errorf("выражение должно содержать минимум 3 элемента")
+func addExpression(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, r)
+		return
 	}
 
-	var tasks []Task
-	var values []float64
-	var operators []string
-	fmt.Println("Список задач:", tasks)
-
-	for _, token := range tokens {
-		if num, err := strconv.ParseFloat(token, 64); err == nil {
-			values = append(values, num)
-		} else if token == "+" || token == "-" || token == "*" || token == "/" {
-			operators = append(operators, token)
-		} else {
-			return nil, fmt.Errorf("неизвестный токен: %s", token)
-		}
+	var req struct {
+		Expression  string                 `json:"expression"`
+		Variables   Variables              `json:"variables"`
+		Matrices    map[string][][]float64 `json:"matrices"`
+		Mode        string                 `json:"mode"`
+		Precision   int                    `json:"precision"`
+		Unit        string                 `json:"unit"`
+		Optimize    bool                   `json:"optimize"`
+		CallbackURL string                 `json:"callback_url"`
 	}
-
-	var newValues []float64
-	var newOperators []string
-	newValues = append(newValues, values[0])
-
-	for i, op := range operators {
-		if op == "*" || op == "/" {
-			arg1 := newValues[len(newValues)-1]
-			arg2 := values[i+1]
-			var result float64
-			if op == "*" {
-				result = arg1 * arg2
-			} else {
-				if arg2 == 0 {
-					return nil, fmt.Errorf("деление на ноль")
-				}
-				result = arg1 / arg2
-			}
-			newValues[len(newValues)-1] = result
-		} else {
-			newOperators = append(newOperators, op)
-			newValues = append(newValues, values[i+1])
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJSON, "некорректный JSON", map[string]interface{}{"reason": err.Error()})
+		return
+	}
+	if req.CallbackURL != "" {
+		if err := validateCallbackURL(req.CallbackURL); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidCallback, err.Error(), nil)
+			return
 		}
 	}
 
-	finalValue := newValues[0]
-	for i, op := range newOperators {
-		if op == "+" {
-			finalValue += newValues[i+1]
-		} else {
-			finalValue -= newValues[i+1]
-		}
+	err := validateVariables(req.Variables)
+	if err == nil {
+		err = validateMatrices(req.Matrices, req.Variables)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidVariables, err.Error(), nil)
+		return
 	}
-	task := Task{
-		ID:        expressionID,
-		Arg1:      finalValue,
-		Arg2:      0,
-		Operation: "done",
+	mode, precision, err := parseNumericMode(req.Mode, req.Precision)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidMode, err.Error(), nil)
+		return
 	}
 
-	return []Task{task}, nil
-}
-
-func addExpression(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if err := validateIdempotencyKey(idempotencyKey); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidIdempotencyKey, err.Error(), nil)
 		return
 	}
 
-	var req struct {
-		Expression string `json:"expression"`
+	root, err := parseExpressionAST(req.Expression)
+	if err == nil {
+		err = checkModeFunctions(root, mode)
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if err == nil {
+		root, err = bindVariables(root, req.Variables, req.Matrices)
+	}
+	if err == nil {
+		err = checkModeLiterals(root, mode)
+	}
+	if err == nil {
+		_, err = inferShapes(root)
+	}
+	var dims map[*Node]dimension
+	if err == nil {
+		dims, err = inferDimensions(root)
+	}
+	if err != nil {
+		writeExpressionError(w, err)
 		return
 	}
-
-	id := generateID()
-	tasksList, err := parseExpressionIntoTasks(id, req.Expression)
+	root, unit, err := applyResultUnit(root, dims[root], req.Unit, mode)
 	if err != nil {
-		http.Error(w, "Ошибка обработки выражения: "+err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidUnit, err.Error(), map[string]interface{}{"unit": req.Unit})
 		return
 	}
+	if req.Optimize {
+		root, _ = optimize(root, mode)
+	}
 
-	fmt.Println("Созданные задачи:", tasksList)
+	now := time.Now()
+	bindings, _ := json.Marshal(req.Variables)
+	matrices, _ := json.Marshal(req.Matrices)
+	fingerprint := requestFingerprint(req.Expression, string(bindings)+string(matrices), mode, strconv.Itoa(precision), req.Unit,
+		strconv.FormatBool(req.Optimize), req.CallbackURL)
+
+	mutex.Lock()
+	if idempotencyKey != "" {
+		existing, err := lookupIdempotent(idempotencyKey, fingerprint, now)
+		if err != nil {
+			mutex.Unlock()
+			writeError(w, http.StatusUnprocessableEntity, ErrCodeIdempotencyConflict, err.Error(),
+				map[string]interface{}{"idempotency_key": idempotencyKey})
+			return
+		}
+		if existing != "" {
+			mutex.Unlock()
+			fmt.Printf("Повторная отправка с Idempotency-Key %s, возвращаем выражение %s\n", idempotencyKey, existing)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(map[string]string{"id": existing})
+			return
+		}
+	}
 
-	expr := Expression{
+	id := generateID()
+	startExpression(Expression{
 		ID:     id,
 		Expr:   req.Expression,
-		Status: "pending",
-		Tasks:  tasksList,
+		Status: StatusQueued,
+
+		Mode:      mode,
+		Precision: precision,
+		Optimize:  req.Optimize,
+		Unit:      unit,
+		Variables: req.Variables,
+		Matrices:  req.Matrices,
+
+		CreatedAt:      now,
+		CallbackURL:    req.CallbackURL,
+		IdempotencyKey: idempotencyKey,
+	}, root)
+	if idempotencyKey != "" {
+		rememberIdempotent(idempotencyKey, fingerprint, id, now)
 	}
-
-	mutex.Lock()
-	store[id] = expr
-	tasks = append(tasks, tasksList...)
-	fmt.Println("Общее количество задач в очереди после добавления:", len(tasks))
 	mutex.Unlock()
 
 	w.Header().Set("Content-Type", "application/json")
@@ -164,46 +288,78 @@ func addExpression(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"id": id})
 }
 
-func getAllExpressions(w http.ResponseWriter, r *http.Request) {
-	mutex.Lock()
-	defer mutex.Unlock()
-
-	var exprs []Expression
-	for _, expr := range store {
-		exprs = append(exprs, expr)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"expressions": exprs})
-}
-
 func getExpression(w http.ResponseWriter, r *http.Request) {
 	id := strings.TrimPrefix(r.URL.Path, "/api/v1/expressions/")
 	mutex.Lock()
 	expr, exists := store[id]
 	mutex.Unlock()
 	if !exists {
-		http.Error(w, `{"error": "Expression not found"}`, http.StatusNotFound)
+		writeError(w, http.StatusNotFound, ErrCodeExpressionNotFound, "выражение не найдено", map[string]interface{}{"id": id})
 		return
 	}
 
 	response := struct {
-		ID         string   `json:"id"`
-		Expression string   `json:"expression"`
-		Status     string   `json:"status"`
-		Result     *float64 `json:"result,omitempty"`
+		ID         string        `json:"id"`
+		Expression string        `json:"expression"`
+		Status     Status        `json:"status"`
+		Result     *float64      `json:"result,omitempty"`
+		Complex    *ComplexValue `json:"complex,omitempty"`
+		Value      string        `json:"value,omitempty"`
+		Matrix     [][]float64   `json:"matrix,omitempty"`
+		Unit       string        `json:"unit,omitempty"`
+		Error      string        `json:"error,omitempty"`
+		Mode       string        `json:"mode,omitempty"`
+		Precision  int           `json:"precision,omitempty"`
+		Optimize   bool          `json:"optimize,omitempty"`
+		CreatedAt  time.Time     `json:"created_at"`
+		StartedAt  *time.Time    `json:"started_at,omitempty"`
+		FinishedAt *time.Time    `json:"finished_at,omitempty"`
+
+		Formula        string                 `json:"formula,omitempty"`
+		Variables      Variables              `json:"variables,omitempty"`
+		Matrices       map[string][][]float64 `json:"matrices,omitempty"`
+		IdempotencyKey string                 `json:"idempotency_key,omitempty"`
 	}{
 		ID:         expr.ID,
 		Expression: expr.Expr,
 		Status:     expr.Status,
 		Result:     expr.Result,
+		Complex:    expr.Complex,
+		Value:      expr.Value,
+		Matrix:     expr.Matrix,
+		Unit:       expr.Unit,
+		Error:      expr.Error,
+		Mode:       expr.Mode,
+		Precision:  expr.Precision,
+		Optimize:   expr.Optimize,
+		CreatedAt:  expr.CreatedAt,
+		StartedAt:  expr.StartedAt,
+		FinishedAt: expr.FinishedAt,
+
+		Formula:        expr.Formula,
+		Variables:      expr.Variables,
+		Matrices:       expr.Matrices,
+		IdempotencyKey: expr.IdempotencyKey,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{"expression": response})
 }
 
+// getTask выдаёт агенту задачу. С параметром limit агент получает до limit
+// задач за один запрос — по числу свободных мест в своей очереди.
 func getTask(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidQuery, "limit должен быть положительным целым числом",
+				map[string]interface{}{"limit": v})
+			return
+		}
+		limit = min(n, taskBatchLimit)
+	}
+
 	mutex.Lock()
 	defer mutex.Unlock()
 
@@ -211,96 +367,238 @@ func getTask(w http.ResponseWriter, r *http.Request) {
 
 	if len(tasks) == 0 {
 		fmt.Println("Очередь пуста!")
-		http.Error(w, `{"error": "No tasks available"}`, http.StatusNotFound)
+		writeError(w, http.StatusNotFound, ErrCodeNoTasks, "нет доступных задач", nil)
+		return
+	}
+
+	agent := r.Header.Get("X-Agent-ID")
+	w.Header().Set("Content-Type", "application/json")
+	if limit == 0 {
+		json.NewEncoder(w).Encode(map[string]interface{}{"task": dispatchTask(agent, time.Now())})
 		return
 	}
+	batch := make([]map[string]interface{}, 0, min(limit, len(tasks)))
+	for len(batch) < limit && len(tasks) > 0 {
+		batch = append(batch, dispatchTask(agent, time.Now()))
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"tasks": batch})
+}
 
-	task := tasks[0]
-	tasks = tasks[1:]
+// dispatchTask вызывается под mutex для непустой очереди: убирает из неё
+// следующую задачу, отмечает её выданной и возвращает её описание для агента.
+func dispatchTask(agent string, now time.Time) map[string]interface{} {
+	next := nextTask()
+	task := tasks[next]
+	tasks = append(tasks[:next], tasks[next+1:]...)
 
 	fmt.Println("Отправлена задача:", task)
+	recordDispatch(task, agent, now)
+	exprID := expressionIDForTask(task.ID)
+	if expr, ok := store[exprID]; ok {
+		if i := taskIndex(expr, task.ID); i >= 0 {
+			expr.Tasks[i].Status = TaskDispatched
+		}
+		if expr.Status == StatusQueued {
+			expr.transition(StatusInProgress, now)
+		}
+		store[exprID] = expr
+	}
+	events.publish(Event{Type: EventTaskDispatched, ExpressionID: exprID, TaskID: task.ID})
 
 	response := map[string]interface{}{
 		"id":             task.ID,
 		"arg1":           task.Arg1,
 		"arg2":           task.Arg2,
 		"operation":      task.Operation,
-		"operation_time": time.Now().Format(time.RFC3339),
+		"operation_time": now.Format(time.RFC3339),
+	}
+	if task.Args != nil {
+		response["args"] = task.Args
 	}
+	if task.Unit != "" {
+		response["unit"] = task.Unit
+	}
+	if task.Mat1 != nil {
+		response["mat1"] = task.Mat1
+	}
+	if task.Mat2 != nil {
+		response["mat2"] = task.Mat2
+	}
+	if task.Operands != nil {
+		response["mode"] = task.Mode
+		response["operands"] = task.Operands
+		if task.Precision != 0 {
+			response["precision"] = task.Precision
+		}
+	}
+	return response
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+// taskResult — результат задачи от агента.
+type taskResult struct {
+	ID     string      `json:"id"`
+	Result float64     `json:"result"`
+	Value  string      `json:"value"`
+	Matrix [][]float64 `json:"matrix"`
+	Error  string      `json:"error"`
+	// DurationMs — время вычисления задачи на агенте.
+	DurationMs float64 `json:"duration_ms"`
 }
 
+// resultError — отказ в приёме результата задачи.
+type resultError struct {
+	status  int
+	code    string
+	message string
+	details map[string]interface{}
+}
+
+// completeTask принимает результат задачи или пакет результатов в поле
+// results. Результаты пакета принимаются независимо: отказ в одном из них
+// не мешает остальным и возвращается в ответе на его месте.
 func completeTask(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		ID     string  `json:"id"`
-		Result float64 `json:"result"`
+		taskResult
+		Results []taskResult `json:"results"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusUnprocessableEntity)
+		writeError(w, http.StatusUnprocessableEntity, ErrCodeInvalidJSON, "некорректный JSON", map[string]interface{}{"reason": err.Error()})
+		return
+	}
+	if req.Results != nil && (len(req.Results) == 0 || len(req.Results) > taskBatchLimit) {
+		writeError(w, http.StatusBadRequest, ErrCodeBatchTooLarge,
+			fmt.Sprintf("пакет должен содержать от 1 до %d результатов", taskBatchLimit),
+			map[string]interface{}{"size": len(req.Results), "limit": taskBatchLimit})
 		return
 	}
 
 	mutex.Lock()
 	defer mutex.Unlock()
 
-	fmt.Printf("Получен результат задачи: ID=%s, Result=%f\n", req.ID, req.Result)
+	w.Header().Set("Content-Type", "application/json")
+	if req.Results == nil {
+		status, rejected := applyTaskResult(req.taskResult)
+		if rejected != nil {
+			writeError(w, rejected.status, rejected.code, rejected.message, rejected.details)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": status})
+		return
+	}
 
-	for exprID, expr := range store {
-		for i, task := range expr.Tasks {
-			if task.ID == req.ID {
-				expr.Tasks[i].Operation = "done"
-				expr.Tasks[i].Arg1 = req.Result
-				taskIDFloat, err := strconv.ParseFloat(task.ID, 64)
-				if err != nil {
-					fmt.Printf("Ошибка преобразования ID задачи %s в float64: %v\n", task.ID, err)
-					continue
-				}
-
-				if i+1 < len(expr.Tasks) {
-					if expr.Tasks[i+1].Arg1 == taskIDFloat {
-						expr.Tasks[i+1].Arg1 = req.Result
-					} else if expr.Tasks[i+1].Arg2 == taskIDFloat {
-						expr.Tasks[i+1].Arg2 = req.Result
-					}
-				}
-				break
-			}
+	results := make([]map[string]interface{}, len(req.Results))
+	for i, res := range req.Results {
+		status, rejected := applyTaskResult(res)
+		if rejected != nil {
+			results[i] = map[string]interface{}{"id": res.ID, "error": APIError{Code: rejected.code, Message: rejected.message, Details: rejected.details}}
+			continue
 		}
+		results[i] = map[string]interface{}{"id": res.ID, "status": status}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+}
+
+// applyTaskResult вызывается под mutex и записывает результат одной задачи.
+func applyTaskResult(req taskResult) (string, *resultError) {
+	fmt.Printf("Получен результат задачи: ID=%s, Result=%f\n", req.ID, req.Result)
+
+	exprID := expressionIDForTask(req.ID)
+	expr, exists := store[exprID]
+	i := taskIndex(expr, req.ID)
+	if !exists || i < 0 {
+		fmt.Printf("⚠️ Ошибка: Задача с ID=%s не найдена\n", req.ID)
+		return "", &resultError{http.StatusNotFound, ErrCodeTaskNotFound, "задача не найдена", map[string]interface{}{"id": req.ID}}
+	}
+	task := expr.Tasks[i]
+	// Результат принимается только для задачи, выданной агенту: задачи
+	// сервера (if, assemble) и ещё не выданные агент вычислять не мог.
+	if task.Status != TaskDispatched || isServerTask(task) {
+		return "", &resultError{http.StatusConflict, ErrCodeInvalidTransition, "задача не ожидает результата",
+			map[string]interface{}{"id": req.ID, "task_status": task.Status, "status": expr.Status}}
+	}
 
-		allDone := true
-		for _, t := range expr.Tasks {
-			if t.Operation != "done" {
-				allDone = false
-				break
-			}
+	if req.Error != "" {
+		fmt.Printf("Агент сообщил об ошибке задачи %s: %s\n", req.ID, req.Error)
+		delete(dispatched, req.ID)
+		if !expr.Status.IsTerminal() {
+			finishExpression(&expr, StatusError, nil, req.Error)
 		}
+		failShared(task, req.Error)
+		return string(StatusError), nil
+	}
 
-		if allDone {
-			fmt.Println("✅ Все задачи завершены, выполняем финальный расчёт...")
+	value := Value{Float: req.Result}
+	if isMatrixOperation(task.Operation) {
+		if err := checkResultMatrix(task, req.Matrix); err != nil {
+			return "", &resultError{http.StatusUnprocessableEntity, ErrCodeInvalidResult, "некорректная матрица в результате: " + err.Error(),
+				map[string]interface{}{"id": req.ID, "operation": task.Operation}}
+		}
+		value = Value{Matrix: req.Matrix}
+	} else if textValues(task.Mode) {
+		var err error
+		if value, err = parseModeValue(task.Mode, req.Value); err != nil {
+			return "", &resultError{http.StatusUnprocessableEntity, ErrCodeInvalidResult, err.Error(),
+				map[string]interface{}{"id": req.ID, "mode": task.Mode}}
+		}
+	}
 
-			var finalResult float64
-			for _, t := range expr.Tasks {
-				if t.Operation == "done" {
-					finalResult = t.Arg1
-				}
-			}
+	recordCompletion(req.ID, req.DurationMs, time.Now())
+	resolveTask(exprID, req.ID, value)
+	completeShared(task, value)
+	return "done", nil
+}
 
-			expr.Status = "done"
-			expr.Result = &finalResult
-			fmt.Printf("🎯 Итоговый результат выражения ID=%s: %f\n", exprID, finalResult)
+// startExpression вызывается под mutex: строит задачи по дереву с уже
+// подставленными переменными, сохраняет выражение и ставит готовые задачи
+// в очередь.
+func startExpression(expr Expression, root *Node) {
+	tasksList, cached := buildTasks(expr, root)
+	fmt.Println("Созданные задачи:", tasksList)
+	expr.Tasks = tasksList
 
-			store[exprID] = expr
+	saveNewExpression(expr)
+	for _, t := range tasksList {
+		if t.Status == TaskReady {
+			tasks = append(tasks, t)
 		}
+	}
+	fmt.Println("Общее количество задач в очереди после добавления:", len(tasks))
+	events.publish(Event{Type: EventExpressionCreated, ExpressionID: expr.ID, Status: string(expr.Status)})
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"status": "done"})
-		return
+	if cached != nil {
+		fmt.Printf("Выражение %s полностью найдено в кэше: %f\n", expr.ID, cached.Float)
+		expr.transition(StatusInProgress, time.Now())
+		finishExpression(&expr, StatusDone, cached, "")
+	}
+}
+
+// saveNewExpression вызывается под mutex и регистрирует выражение во всех индексах.
+func saveNewExpression(expr Expression) {
+	store[expr.ID] = expr
+	indexExpression(expr)
+	deadlines.add(expr)
+	for _, t := range expr.Tasks {
+		taskOwner[t.ID] = expr.ID
 	}
+}
+
+func expressionIDForTask(taskID string) string {
+	return taskOwner[taskID]
+}
 
-	fmt.Printf("⚠️ Ошибка: Задача с ID=%s не найдена\n", req.ID)
-	http.Error(w, `{"error": "Task not found"}`, http.StatusNotFound)
+func expressionHandler(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/events"):
+		getExpressionEvents(w, r)
+		return
+	case strings.HasSuffix(r.URL.Path, "/deliveries"):
+		getDeliveries(w, r)
+		return
+	case strings.HasSuffix(r.URL.Path, "/cancel"):
+		cancelExpression(w, r)
+		return
+	}
+	getExpression(w, r)
 }
 
 func internalTaskHandler(w http.ResponseWriter, r *http.Request) {
@@ -310,6 +608,6 @@ func internalTaskHandler(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPost:
 		completeTask(w, r)
 	default:
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w, r)
 	}
 }
@@ -59,9 +59,9 @@ func TestGetExpression(t *testing.T) {
 	expr := Expression{
 		ID:     "test_id",
 		Expr:   "4 * 5",
-		Status: "pending",
+		Status: StatusQueued,
 	}
-	store["test_id"] = expr
+	saveNewExpression(expr)
 
 	req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/test_id", nil)
 	rr := httptest.NewRecorder()
@@ -108,12 +108,12 @@ func TestCompleteTask(t *testing.T) {
 	expr := Expression{
 		ID:     "expr1",
 		Expr:   "5 - 3",
-		Status: "pending",
+		Status: StatusQueued,
 		Tasks: []Task{
-			{ID: "task2", Arg1: 5, Arg2: 3, Operation: "-"},
+			{ID: "task2", Arg1: 5, Arg2: 3, Operation: "-", Status: TaskDispatched},
 		},
 	}
-	store["expr1"] = expr
+	saveNewExpression(expr)
 
 	reqBody := `{"id": "task2", "result": 2}`
 	req := httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBufferString(reqBody))
@@ -131,6 +131,31 @@ func TestCompleteTask(t *testing.T) {
 	fmt.Printf("[%s] прошел успешно!\n", testName)
 }
 
+func TestCompleteTaskRequiresDispatch(t *testing.T) {
+	testName := "TestCompleteTaskRequiresDispatch"
+
+	saveNewExpression(Expression{
+		ID:     "expr_undispatched",
+		Expr:   "if(1, 2, 3) + 4",
+		Status: StatusInProgress,
+		Tasks: []Task{
+			{ID: "task_ready", Arg1: 2, Arg2: 4, Operation: "+", Status: TaskReady},
+			{ID: "task_if", Operation: opIf, Status: TaskDispatched},
+		},
+	})
+
+	for _, id := range []string{"task_ready", "task_if"} {
+		req := httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBufferString(`{"id": "`+id+`", "result": 6}`))
+		rr := httptest.NewRecorder()
+		completeTask(rr, req)
+		if rr.Code != http.StatusConflict {
+			t.Fatalf("❌ [%s] Результат задачи %s: ожидался статус %d, но получен %d", testName, id, http.StatusConflict, rr.Code)
+		}
+	}
+
+	log.Printf("[%s] прошел успешно!", testName)
+}
+
 func TestAllTestsPassed(t *testing.T) {
 	log.Println("🎉 Все тесты пройдены успешно!")
 	fmt.Println("🎉 Все тесты пройдены успешно!")
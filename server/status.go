@@ -0,0 +1,218 @@
+package server
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusQueued     Status = "queued"
+	StatusInProgress Status = "in_progress"
+	StatusDone       Status = "done"
+	StatusError      Status = "error"
+	StatusCancelled  Status = "cancelled"
+	StatusTimeout    Status = "timeout"
+)
+
+var transitions = map[Status][]Status{
+	StatusQueued:     {StatusInProgress, StatusError, StatusCancelled, StatusTimeout},
+	StatusInProgress: {StatusDone, StatusError, StatusCancelled, StatusTimeout},
+}
+
+var expressionTimeout time.Duration
+
+func (s Status) IsTerminal() bool {
+	return s == StatusDone || s == StatusError || s == StatusCancelled || s == StatusTimeout
+}
+
+func (s Status) Valid() bool {
+	return s == StatusQueued || s == StatusInProgress || s.IsTerminal()
+}
+
+func (s Status) CanTransitionTo(to Status) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transition переводит выражение в новое состояние и проставляет метки времени.
+func (e *Expression) transition(to Status, now time.Time) error {
+	if !e.Status.CanTransitionTo(to) {
+		return fmt.Errorf("недопустимый переход %s -> %s", e.Status, to)
+	}
+	e.Status = to
+	switch {
+	case to == StatusInProgress:
+		e.StartedAt = &now
+	case to.IsTerminal():
+		e.FinishedAt = &now
+	}
+	return nil
+}
+
+// finishExpression вызывается под mutex: переводит выражение в конечное
+// состояние, отменяет его невыполненные задачи и уведомляет подписчиков.
+func finishExpression(expr *Expression, to Status, result *Value, reason string) error {
+	if err := expr.transition(to, time.Now()); err != nil {
+		return err
+	}
+	expr.Result, expr.Value, expr.Complex, expr.Matrix = nil, "", nil, nil
+	switch {
+	case result != nil && result.Matrix != nil:
+		expr.Matrix = result.Matrix
+	case result != nil && expr.Mode == ModeComplex:
+		expr.Value = result.Text
+		if c, err := strconv.ParseComplex(result.Text, 128); err == nil {
+			expr.Complex = &ComplexValue{Re: real(c), Im: imag(c)}
+		}
+	case result != nil:
+		value := result.Float
+		expr.Result, expr.Value = &value, result.Text
+	}
+	expr.Error = reason
+	if to != StatusDone {
+		releaseTasks(expr)
+	} else {
+		recordMakespan(*expr)
+	}
+	store[expr.ID] = *expr
+	deadlines.remove(expr.ID)
+
+	events.publish(Event{Type: EventExpressionFinished, ExpressionID: expr.ID, Status: string(to), Result: expr.Result, Value: expr.Value, Matrix: expr.Matrix})
+	notifyCallback(*expr)
+	return nil
+}
+
+// deadlineQueue — незавершённые выражения в порядке создания (куча по
+// CreatedAt). Срок ожидания у всех выражений один, поэтому раньше истекает
+// выражение, созданное раньше, и проверка таймаутов смотрит только на
+// вершину кучи, а не обходит всё хранилище. Защищена mutex.
+type deadlineQueue struct {
+	entries []deadlineEntry
+	index   map[string]int
+}
+
+type deadlineEntry struct {
+	id        string
+	createdAt time.Time
+}
+
+var deadlines = newDeadlineQueue()
+
+func newDeadlineQueue() *deadlineQueue {
+	return &deadlineQueue{index: make(map[string]int)}
+}
+
+func (q *deadlineQueue) Len() int { return len(q.entries) }
+
+func (q *deadlineQueue) Less(i, j int) bool {
+	return q.entries[i].createdAt.Before(q.entries[j].createdAt)
+}
+
+func (q *deadlineQueue) Swap(i, j int) {
+	q.entries[i], q.entries[j] = q.entries[j], q.entries[i]
+	q.index[q.entries[i].id] = i
+	q.index[q.entries[j].id] = j
+}
+
+func (q *deadlineQueue) Push(x any) {
+	e := x.(deadlineEntry)
+	q.index[e.id] = len(q.entries)
+	q.entries = append(q.entries, e)
+}
+
+func (q *deadlineQueue) Pop() any {
+	last := q.entries[len(q.entries)-1]
+	q.entries = q.entries[:len(q.entries)-1]
+	delete(q.index, last.id)
+	return last
+}
+
+// add ставит незавершённое выражение в очередь сроков.
+func (q *deadlineQueue) add(expr Expression) {
+	if _, ok := q.index[expr.ID]; ok || expr.Status.IsTerminal() {
+		return
+	}
+	heap.Push(q, deadlineEntry{id: expr.ID, createdAt: expr.CreatedAt})
+}
+
+// remove убирает выражение из очереди, если оно там есть.
+func (q *deadlineQueue) remove(id string) {
+	if i, ok := q.index[id]; ok {
+		heap.Remove(q, i)
+	}
+}
+
+func expireTimedOut(now time.Time) {
+	if expressionTimeout <= 0 {
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for deadlines.Len() > 0 {
+		top := deadlines.entries[0]
+		if now.Sub(top.createdAt) < expressionTimeout {
+			return
+		}
+		deadlines.remove(top.id)
+		expr, ok := store[top.id]
+		if !ok || expr.Status.IsTerminal() {
+			continue
+		}
+		fmt.Printf("Выражение %s превысило время ожидания\n", expr.ID)
+		finishExpression(&expr, StatusTimeout, nil, "превышено время выполнения")
+	}
+}
+
+// watchTimeouts раз в секунду завершает просроченные выражения, пока не
+// закрыт stop; nil — до завершения процесса.
+func watchTimeouts(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			expireTimedOut(now)
+		}
+	}
+}
+
+func cancelExpression(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/expressions/"), "/cancel")
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	expr, exists := store[id]
+	if !exists {
+		writeError(w, http.StatusNotFound, ErrCodeExpressionNotFound, "выражение не найдено", map[string]interface{}{"id": id})
+		return
+	}
+	if err := finishExpression(&expr, StatusCancelled, nil, "отменено пользователем"); err != nil {
+		writeError(w, http.StatusConflict, ErrCodeInvalidTransition, err.Error(),
+			map[string]interface{}{"status": expr.Status})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "status": expr.Status})
+}
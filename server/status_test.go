@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStatusTransitions(t *testing.T) {
+	tests := []struct {
+		from, to Status
+		allowed  bool
+	}{
+		{StatusQueued, StatusInProgress, true},
+		{StatusQueued, StatusCancelled, true},
+		{StatusQueued, StatusDone, false},
+		{StatusInProgress, StatusDone, true},
+		{StatusInProgress, StatusError, true},
+		{StatusInProgress, StatusTimeout, true},
+		{StatusInProgress, StatusQueued, false},
+		{StatusDone, StatusError, false},
+		{StatusCancelled, StatusInProgress, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.from.CanTransitionTo(tt.to); got != tt.allowed {
+			t.Errorf("❌ %s -> %s: ожидалось %v, получено %v", tt.from, tt.to, tt.allowed, got)
+		}
+	}
+}
+
+func TestExpressionLifecycleTimestamps(t *testing.T) {
+	testName := "TestExpressionLifecycleTimestamps"
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBufferString(`{"expression": "3 + 4"}`))
+	rr := httptest.NewRecorder()
+	addExpression(rr, req)
+
+	var created map[string]string
+	json.NewDecoder(rr.Body).Decode(&created)
+	id := created["id"]
+
+	mutex.Lock()
+	expr := store[id]
+	tasks = append([]Task{}, expr.Tasks...)
+	mutex.Unlock()
+
+	if expr.Status != StatusQueued || expr.CreatedAt.IsZero() || expr.StartedAt != nil {
+		t.Fatalf("❌ [%s] Новое выражение должно быть queued: %+v", testName, expr)
+	}
+
+	getTask(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+
+	mutex.Lock()
+	expr = store[id]
+	mutex.Unlock()
+	if expr.Status != StatusInProgress || expr.StartedAt == nil {
+		t.Fatalf("❌ [%s] После выдачи задачи ожидался in_progress: %+v", testName, expr)
+	}
+
+	body := fmt.Sprintf(`{"id": %q, "result": 7}`, expr.Tasks[0].ID)
+	completeTask(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBufferString(body)))
+
+	rr = httptest.NewRecorder()
+	getExpression(rr, httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+id, nil))
+
+	var resp struct {
+		Expression struct {
+			Status     Status     `json:"status"`
+			CreatedAt  time.Time  `json:"created_at"`
+			StartedAt  *time.Time `json:"started_at"`
+			FinishedAt *time.Time `json:"finished_at"`
+		} `json:"expression"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+
+	got := resp.Expression
+	if got.Status != StatusDone || got.StartedAt == nil || got.FinishedAt == nil {
+		t.Fatalf("❌ [%s] Ожидалось завершённое выражение с метками времени: %+v", testName, got)
+	}
+	if got.FinishedAt.Before(*got.StartedAt) || got.StartedAt.Before(got.CreatedAt) {
+		t.Fatalf("❌ [%s] Метки времени идут не по порядку: %+v", testName, got)
+	}
+
+	fmt.Printf("[%s] прошел успешно!\n", testName)
+}
+
+func TestCancelExpression(t *testing.T) {
+	testName := "TestCancelExpression"
+
+	mutex.Lock()
+	saveNewExpression(Expression{
+		ID:        "cancel_expr",
+		Expr:      "1 + 2",
+		Status:    StatusQueued,
+		Tasks:     []Task{{ID: "cancel_task", Arg1: 1, Arg2: 2, Operation: "+"}},
+		CreatedAt: time.Now(),
+	})
+	tasks = []Task{{ID: "cancel_task", Arg1: 1, Arg2: 2, Operation: "+"}}
+	mutex.Unlock()
+
+	rr := httptest.NewRecorder()
+	expressionHandler(rr, httptest.NewRequest(http.MethodPost, "/api/v1/expressions/cancel_expr/cancel", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("❌ [%s] Ожидался статус %d, но получен %d", testName, http.StatusOK, rr.Code)
+	}
+
+	mutex.Lock()
+	status, queued := store["cancel_expr"].Status, len(tasks)
+	mutex.Unlock()
+	if status != StatusCancelled || queued != 0 {
+		t.Fatalf("❌ [%s] Ожидалось cancelled и пустая очередь, получено %s и %d задач", testName, status, queued)
+	}
+
+	rr = httptest.NewRecorder()
+	expressionHandler(rr, httptest.NewRequest(http.MethodPost, "/api/v1/expressions/cancel_expr/cancel", nil))
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("❌ [%s] Повторная отмена: ожидался статус %d, но получен %d", testName, http.StatusConflict, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	completeTask(rr, httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBufferString(`{"id": "cancel_task", "result": 3}`)))
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("❌ [%s] Результат для отменённого выражения: ожидался статус %d, но получен %d", testName, http.StatusConflict, rr.Code)
+	}
+
+	fmt.Printf("[%s] прошел успешно!\n", testName)
+}
+
+func TestAgentErrorAndTimeout(t *testing.T) {
+	testName := "TestAgentErrorAndTimeout"
+
+	mutex.Lock()
+	saveNewExpression(Expression{
+		ID: "err_expr", Expr: "1 / 0", Status: StatusInProgress, CreatedAt: time.Now(),
+		Tasks: []Task{{ID: "err_task", Arg1: 1, Arg2: 0, Operation: "/", Status: TaskDispatched}},
+	})
+	saveNewExpression(Expression{
+		ID: "slow_expr", Expr: "1 + 1", Status: StatusQueued, CreatedAt: time.Now().Add(-time.Hour),
+		Tasks: []Task{{ID: "slow_task", Arg1: 1, Arg2: 1, Operation: "+"}},
+	})
+	mutex.Unlock()
+
+	completeTask(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/internal/task",
+		bytes.NewBufferString(`{"id": "err_task", "error": "деление на 0"}`)))
+
+	originalTimeout := expressionTimeout
+	expressionTimeout = time.Minute
+	expireTimedOut(time.Now())
+	expressionTimeout = originalTimeout
+
+	mutex.Lock()
+	errExpr, slowExpr := store["err_expr"], store["slow_expr"]
+	mutex.Unlock()
+
+	if errExpr.Status != StatusError || errExpr.Error == "" || errExpr.FinishedAt == nil {
+		t.Fatalf("❌ [%s] Ожидался статус error, получено %+v", testName, errExpr)
+	}
+	if slowExpr.Status != StatusTimeout || slowExpr.FinishedAt == nil {
+		t.Fatalf("❌ [%s] Ожидался статус timeout, получено %+v", testName, slowExpr)
+	}
+
+	fmt.Printf("[%s] прошел успешно!\n", testName)
+}
+
+func TestTimeoutQueueHoldsOnlyLiveExpressions(t *testing.T) {
+	isolateState(t)
+	saved := expressionTimeout
+	expressionTimeout = time.Minute
+	t.Cleanup(func() { expressionTimeout = saved })
+
+	now := time.Now()
+	mutex.Lock()
+	saveNewExpression(Expression{ID: "old_live", Status: StatusQueued, CreatedAt: now.Add(-time.Hour)})
+	saveNewExpression(Expression{ID: "old_done", Status: StatusDone, CreatedAt: now.Add(-time.Hour)})
+	saveNewExpression(Expression{ID: "fresh", Status: StatusQueued, CreatedAt: now})
+	queued := deadlines.Len()
+	mutex.Unlock()
+	if queued != 2 {
+		t.Fatalf("❌ В очереди сроков должны быть только 2 незавершённых выражения, получено %d", queued)
+	}
+
+	expireTimedOut(now)
+
+	mutex.Lock()
+	oldStatus, freshStatus, left := store["old_live"].Status, store["fresh"].Status, deadlines.Len()
+	fresh := store["fresh"]
+	finishExpression(&fresh, StatusCancelled, nil, "")
+	finished := deadlines.Len()
+	mutex.Unlock()
+	if oldStatus != StatusTimeout || freshStatus != StatusQueued || left != 1 {
+		t.Fatalf("❌ Ожидались timeout и queued, в очереди 1, получено %s, %s, %d", oldStatus, freshStatus, left)
+	}
+	if finished != 0 {
+		t.Fatalf("❌ Завершённое выражение должно уйти из очереди сроков, осталось %d", finished)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		watchTimeouts(stop)
+		close(done)
+	}()
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("❌ watchTimeouts не остановился после закрытия stop")
+	}
+}
@@ -0,0 +1,389 @@
+package server
+
+import (
+	"fmt"
+	"time"
+)
+
+type TaskStatus string
+
+const (
+	TaskWaiting    TaskStatus = "waiting"
+	TaskReady      TaskStatus = "ready"
+	TaskDispatched TaskStatus = "dispatched"
+	TaskShared     TaskStatus = "shared"
+	TaskDone       TaskStatus = "done"
+	TaskCancelled  TaskStatus = "cancelled"
+)
+
+type taskRef struct {
+	exprID string
+	taskID string
+}
+
+var (
+	// inflight: канонический ключ -> ID задачи, которая его сейчас вычисляет.
+	inflight = make(map[string]string)
+	// followers: ID задачи -> задачи других выражений, ждущие её результата.
+	followers = make(map[string][]taskRef)
+)
+
+// taskBuilder превращает AST в список задач выражения. Задачи добавляются
+// в порядке обхода в глубину, поэтому корневая задача всегда последняя.
+// plan — построение плана для explain: кэш и задачи других выражений
+// не используются, общее состояние не меняется (см. explain.go).
+type taskBuilder struct {
+	exprID    string
+	mode      string
+	precision int
+	prefix    string
+	shapes    map[*Node]shape
+	dims      map[*Node]dimension
+	plan      bool
+	tasks     []Task
+	local     map[string]string
+}
+
+// build вызывается под mutex. Возвращает либо готовое значение узла, либо
+// ID задачи, которая его вычислит.
+func (b *taskBuilder) build(n *Node) (Value, string) {
+	if n.Kind == NodeNumber {
+		if b.withText() {
+			return Value{Float: n.Value, Text: exactText(n)}, ""
+		}
+		return Value{Float: n.Value}, ""
+	}
+	if n.Kind == NodeMatrix {
+		return Value{Matrix: n.Matrix}, ""
+	}
+
+	key := b.prefix + canonical(n)
+	if id, ok := b.local[key]; ok {
+		if !b.plan {
+			stats.Deduplicated++
+		}
+		return Value{}, id
+	}
+	if b.plan {
+		return b.buildTask(n, key)
+	}
+	if value, ok := memo.get(key); ok {
+		stats.Hits++
+		return value, ""
+	}
+
+	if leader, ok := inflight[key]; ok {
+		stats.InflightHits++
+		task := Task{ID: generateID(), Operation: n.Op, Key: key, Status: TaskShared, SharedWith: leader}
+		followers[leader] = append(followers[leader], taskRef{exprID: b.exprID, taskID: task.ID})
+		b.add(task)
+		return Value{}, task.ID
+	}
+	return b.buildTask(n, key)
+}
+
+// buildTask создаёт задачу узла, которого нет ни в кэше, ни среди задач
+// в работе.
+func (b *taskBuilder) buildTask(n *Node, key string) (Value, string) {
+	if b.shapes[n].isMatrix() {
+		return b.buildMatrix(n, key)
+	}
+	if n.Kind == NodeCall && n.Op == opIf {
+		return b.buildIf(n, key)
+	}
+
+	task := Task{ID: generateID(), Operation: n.Op, Key: key, Unit: b.dims[n].String()}
+	values := make([]Value, len(n.Args))
+	if n.Kind == NodeCall {
+		task.Args = make([]float64, len(n.Args))
+		task.ArgTasks = make([]string, len(n.Args))
+		for i, arg := range n.Args {
+			values[i], task.ArgTasks[i] = b.build(arg)
+			task.Args[i] = values[i].Float
+		}
+	} else {
+		values[0], task.Arg1Task = b.build(n.Args[0])
+		values[1], task.Arg2Task = b.build(n.Args[1])
+		task.Arg1, task.Arg2 = values[0].Float, values[1].Float
+	}
+	if b.withText() {
+		task.Mode, task.Precision = b.mode, b.precision
+		task.Operands = make([]string, len(values))
+		for i, v := range values {
+			task.Operands[i] = v.Text
+		}
+	}
+
+	task.Status = TaskWaiting
+	if len(task.dependencies()) == 0 {
+		task.Status = TaskReady
+	}
+	b.register(key, task.ID)
+	b.add(task)
+	return Value{}, task.ID
+}
+
+func (b *taskBuilder) withText() bool {
+	return textValues(b.mode)
+}
+
+// register отмечает задачу как вычисляющую key. При построении плана
+// общее состояние не меняется.
+func (b *taskBuilder) register(key, id string) {
+	if b.plan {
+		return
+	}
+	stats.Misses++
+	inflight[key] = id
+}
+
+func (b *taskBuilder) add(task Task) {
+	b.tasks = append(b.tasks, task)
+	if task.Key != "" {
+		b.local[task.Key] = task.ID
+	}
+}
+
+// buildTasks вызывается под mutex. Если всё выражение нашлось в кэше,
+// задач не будет, а результат возвращается сразу.
+func buildTasks(expr Expression, root *Node) ([]Task, *Value) {
+	b := newTaskBuilder(expr, root)
+	value, id := b.build(root)
+	if id == "" {
+		return nil, &value
+	}
+	b.tasks[len(b.tasks)-1].Unit = expr.Unit
+	rankTasks(b.tasks)
+	return b.tasks, nil
+}
+
+func newTaskBuilder(expr Expression, root *Node) *taskBuilder {
+	// Размеры и размерности уже проверены при приёме выражения.
+	shapes, _ := inferShapes(root)
+	dims, _ := inferDimensions(root)
+	return &taskBuilder{
+		exprID:    expr.ID,
+		mode:      expr.Mode,
+		precision: expr.Precision,
+		prefix:    modeKeyPrefix(expr.Mode, expr.Precision),
+		shapes:    shapes,
+		dims:      dims,
+		local:     make(map[string]string),
+	}
+}
+
+func taskIndex(expr Expression, taskID string) int {
+	for i, t := range expr.Tasks {
+		if t.ID == taskID {
+			return i
+		}
+	}
+	return -1
+}
+
+// dependencies возвращает ID задач, результаты которых нужны этой задаче.
+func (t Task) dependencies() []string {
+	var deps []string
+	for _, dep := range append([]string{t.Arg1Task, t.Arg2Task}, t.ArgTasks...) {
+		if dep != "" {
+			deps = append(deps, dep)
+		}
+	}
+	return deps
+}
+
+// setOperand записывает точное значение аргумента, если задача считается
+// в точном режиме.
+func (t *Task) setOperand(i int, text string) {
+	if i < len(t.Operands) {
+		t.Operands[i] = text
+	}
+}
+
+func isReady(expr Expression, task Task) bool {
+	for _, dep := range task.dependencies() {
+		if i := taskIndex(expr, dep); i < 0 || expr.Tasks[i].Status != TaskDone {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveTask вызывается под mutex: записывает результат задачи, подставляет
+// его в зависящие задачи и ставит в очередь те, что стали готовы. Готовые
+// задачи сервера (сборка матрицы, условие) выполняются сразу.
+func resolveTask(exprID, taskID string, value Value) {
+	expr, ok := store[exprID]
+	if !ok {
+		return
+	}
+	i := taskIndex(expr, taskID)
+	if i < 0 || expr.Tasks[i].Status == TaskDone || expr.Tasks[i].Status == TaskCancelled {
+		return
+	}
+
+	result := value.Float
+	expr.Tasks[i].Status = TaskDone
+	expr.Tasks[i].Value = value.Text
+	expr.Tasks[i].Matrix = value.Matrix
+	if value.Matrix == nil {
+		expr.Tasks[i].Result = &result
+	}
+
+	var serverTasks []Task
+	for j := range expr.Tasks {
+		t := &expr.Tasks[j]
+		if t.Arg1Task == taskID {
+			t.Arg1 = value.Float
+			t.setOperand(0, value.Text)
+			t.setMatrixOperand(0, value.Matrix)
+		}
+		if t.Arg2Task == taskID {
+			t.Arg2 = value.Float
+			t.setOperand(1, value.Text)
+			t.setMatrixOperand(1, value.Matrix)
+		}
+		// Задача сборки читает блоки из их задач, аргументов у неё нет.
+		for k, dep := range t.ArgTasks {
+			if dep == taskID && t.Operation != opAssemble {
+				t.Args[k] = value.Float
+				t.setOperand(k, value.Text)
+			}
+		}
+		if t.Status == TaskWaiting && isReady(expr, *t) {
+			t.Status = TaskReady
+			if isServerTask(*t) {
+				serverTasks = append(serverTasks, *t)
+				continue
+			}
+			tasks = append(tasks, *t)
+		}
+	}
+	store[exprID] = expr
+
+	events.publish(Event{Type: EventTaskCompleted, ExpressionID: exprID, TaskID: taskID, Result: expr.Tasks[i].Result, Value: value.Text})
+
+	if i == len(expr.Tasks)-1 && !expr.Status.IsTerminal() {
+		fmt.Printf("🎯 Итоговый результат выражения ID=%s: %f\n", exprID, value.Float)
+		if expr.Status == StatusQueued {
+			expr.transition(StatusInProgress, time.Now())
+		}
+		finishExpression(&expr, StatusDone, &value, "")
+	}
+
+	for _, t := range serverTasks {
+		runServerTask(exprID, t)
+	}
+}
+
+// isServerTask сообщает, выполняет ли задачу сам сервер, а не агент.
+func isServerTask(t Task) bool {
+	return t.Operation == opAssemble || t.Operation == opIf
+}
+
+// runServerTask вызывается под mutex для готовой задачи сервера.
+func runServerTask(exprID string, t Task) {
+	if t.Operation == opIf {
+		expandBranch(exprID, t)
+		return
+	}
+	matrix := Value{Matrix: assembleMatrix(store[exprID], t)}
+	resolveTask(exprID, t.ID, matrix)
+	completeShared(t, matrix)
+}
+
+// completeShared вызывается под mutex после того, как задача-лидер получила
+// результат: кэширует его и раздаёт ожидающим задачам других выражений.
+func completeShared(task Task, value Value) {
+	if task.Key != "" {
+		memo.put(task.Key, value)
+		if inflight[task.Key] == task.ID {
+			delete(inflight, task.Key)
+		}
+	}
+
+	waiting := followers[task.ID]
+	delete(followers, task.ID)
+	for _, ref := range waiting {
+		resolveTask(ref.exprID, ref.taskID, value)
+	}
+}
+
+// failShared вызывается под mutex, когда задача-лидер завершилась ошибкой:
+// выражения, ждавшие её результата, завершаются той же ошибкой.
+func failShared(task Task, reason string) {
+	if task.Key != "" && inflight[task.Key] == task.ID {
+		delete(inflight, task.Key)
+	}
+
+	waiting := followers[task.ID]
+	delete(followers, task.ID)
+	for _, ref := range waiting {
+		if expr, ok := store[ref.exprID]; ok && !expr.Status.IsTerminal() {
+			finishExpression(&expr, StatusError, nil, reason)
+		}
+	}
+}
+
+// releaseTasks вызывается под mutex при досрочном завершении выражения.
+// Задачи, результата которых ждут другие выражения, продолжают выполняться
+// вместе со своими зависимостями; остальные отменяются и убираются из очереди.
+func releaseTasks(expr *Expression) {
+	needed := make(map[string]bool)
+	var mark func(id string)
+	mark = func(id string) {
+		if id == "" || needed[id] {
+			return
+		}
+		needed[id] = true
+		if i := taskIndex(*expr, id); i >= 0 {
+			for _, dep := range expr.Tasks[i].dependencies() {
+				mark(dep)
+			}
+		}
+	}
+	for _, t := range expr.Tasks {
+		if t.Status != TaskDone && len(followers[t.ID]) > 0 {
+			mark(t.ID)
+		}
+	}
+
+	cancelled := make(map[string]bool)
+	for i := range expr.Tasks {
+		t := &expr.Tasks[i]
+		if t.Status == TaskDone || needed[t.ID] {
+			continue
+		}
+		if t.Status == TaskShared {
+			unfollow(t.SharedWith, t.ID)
+		}
+		if inflight[t.Key] == t.ID {
+			delete(inflight, t.Key)
+		}
+		delete(branches, t.ID)
+		delete(dispatched, t.ID)
+		t.Status = TaskCancelled
+		cancelled[t.ID] = true
+	}
+
+	remaining := tasks[:0]
+	for _, t := range tasks {
+		if !cancelled[t.ID] {
+			remaining = append(remaining, t)
+		}
+	}
+	tasks = remaining
+}
+
+func unfollow(leaderID, taskID string) {
+	refs := followers[leaderID]
+	for i, ref := range refs {
+		if ref.taskID == taskID {
+			followers[leaderID] = append(refs[:i:i], refs[i+1:]...)
+			break
+		}
+	}
+	if len(followers[leaderID]) == 0 {
+		delete(followers, leaderID)
+	}
+}
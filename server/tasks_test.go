@@ -0,0 +1,473 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"math/cmplx"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// isolateState подменяет глобальное состояние сервера чистым на время теста.
+func isolateState(t *testing.T) {
+	t.Helper()
+
+	mutex.Lock()
+	saved := struct {
+		store     map[string]Expression
+		order     []exprKey
+		owner     map[string]string
+		queue     []Task
+		memo      *lruCache
+		inflight  map[string]string
+		followers map[string][]taskRef
+		stats     cacheStats
+		keys      map[string]idempotencyRecord
+		formulas  map[string]Formula
+		branches  map[string]*pendingBranch
+		timings   map[string]*timing
+		agents    map[string]*agentTimings
+		running   map[string]dispatch
+		schedule  scheduleTotals
+		deadlines *deadlineQueue
+	}{store, exprOrder, taskOwner, tasks, memo, inflight, followers, stats, idempotencyKeys, formulas, branches,
+		operationTimings, agentStats, dispatched, schedule, deadlines}
+
+	store, exprOrder, taskOwner, tasks = make(map[string]Expression), nil, make(map[string]string), nil
+	memo, inflight, followers, stats = newLRUCache(100), make(map[string]string), make(map[string][]taskRef), cacheStats{}
+	idempotencyKeys, formulas = make(map[string]idempotencyRecord), make(map[string]Formula)
+	branches = make(map[string]*pendingBranch)
+	operationTimings, agentStats, dispatched, schedule = make(map[string]*timing), make(map[string]*agentTimings), make(map[string]dispatch), scheduleTotals{}
+	deadlines = newDeadlineQueue()
+	mutex.Unlock()
+
+	t.Cleanup(func() {
+		mutex.Lock()
+		store, exprOrder, taskOwner, tasks = saved.store, saved.order, saved.owner, saved.queue
+		memo, inflight, followers, stats = saved.memo, saved.inflight, saved.followers, saved.stats
+		idempotencyKeys, formulas = saved.keys, saved.formulas
+		branches = saved.branches
+		operationTimings, agentStats, dispatched, schedule = saved.timings, saved.agents, saved.running, saved.schedule
+		deadlines = saved.deadlines
+		mutex.Unlock()
+	})
+}
+
+func submit(t *testing.T, expression string) string {
+	t.Helper()
+
+	body := fmt.Sprintf(`{"expression": %q}`, expression)
+	rr := httptest.NewRecorder()
+	addExpression(rr, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBufferString(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Не удалось отправить %q: %d %s", expression, rr.Code, rr.Body.String())
+	}
+	var resp map[string]string
+	json.NewDecoder(rr.Body).Decode(&resp)
+	return resp["id"]
+}
+
+// runAgent забирает задачи из очереди и отправляет результаты, пока очередь
+// не опустеет. Возвращает количество выполненных задач.
+func runAgent(t *testing.T) int {
+	t.Helper()
+
+	executed := 0
+	for {
+		rr := httptest.NewRecorder()
+		getTask(rr, httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+		if rr.Code == http.StatusNotFound {
+			return executed
+		}
+
+		var resp struct {
+			Task struct {
+				Task
+				Mat1 [][]float64 `json:"mat1"`
+				Mat2 [][]float64 `json:"mat2"`
+			} `json:"task"`
+		}
+		json.NewDecoder(rr.Body).Decode(&resp)
+		task := resp.Task.Task
+		task.Mat1, task.Mat2 = resp.Task.Mat1, resp.Task.Mat2
+
+		if isMatrixOperation(task.Operation) {
+			data, _ := json.Marshal(map[string]interface{}{"id": task.ID, "matrix": matrixResult(t, task)})
+			rr = httptest.NewRecorder()
+			completeTask(rr, httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBuffer(data)))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Результат задачи %s отклонён: %d %s", task.ID, rr.Code, rr.Body.String())
+			}
+			executed++
+			continue
+		}
+
+		if task.Mode != "" {
+			body := fmt.Sprintf(`{"id": %q, "value": %q}`, task.ID, exactResult(t, task))
+			rr = httptest.NewRecorder()
+			completeTask(rr, httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBufferString(body)))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Результат задачи %s отклонён: %d %s", task.ID, rr.Code, rr.Body.String())
+			}
+			executed++
+			continue
+		}
+
+		var result float64
+		switch task.Operation {
+		case "+":
+			result = task.Arg1 + task.Arg2
+		case "-":
+			result = task.Arg1 - task.Arg2
+		case "*":
+			result = task.Arg1 * task.Arg2
+		case "/":
+			result = task.Arg1 / task.Arg2
+		case "//":
+			result = math.Floor(task.Arg1 / task.Arg2)
+		case "%":
+			result = math.Mod(task.Arg1, task.Arg2)
+		case "^":
+			result = math.Pow(task.Arg1, task.Arg2)
+		case ">":
+			result = boolFloat(task.Arg1 > task.Arg2)
+		case "<":
+			result = boolFloat(task.Arg1 < task.Arg2)
+		case "==":
+			result = boolFloat(task.Arg1 == task.Arg2)
+		case "&&":
+			result = boolFloat(task.Arg1 != 0 && task.Arg2 != 0)
+		case "sqrt":
+			result = math.Sqrt(task.Args[0])
+		case "max":
+			result = task.Args[0]
+			for _, v := range task.Args[1:] {
+				result = math.Max(result, v)
+			}
+		case "sum":
+			for _, v := range task.Args {
+				result += v
+			}
+		case "product":
+			result = 1
+			for _, v := range task.Args {
+				result *= v
+			}
+		case "dev", "sqdev":
+			for _, v := range task.Args[1:] {
+				d := v - task.Args[0]
+				if task.Operation == "sqdev" {
+					d *= d
+				}
+				result += d
+			}
+		default:
+			t.Fatalf("Неизвестная операция %q", task.Operation)
+		}
+
+		body := fmt.Sprintf(`{"id": %q, "result": %v}`, task.ID, result)
+		rr = httptest.NewRecorder()
+		completeTask(rr, httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBufferString(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Результат задачи %s отклонён: %d %s", task.ID, rr.Code, rr.Body.String())
+		}
+		executed++
+	}
+}
+
+// exactResult вычисляет задачу со строковыми значениями для тестового
+// агента. Поддерживается только то, что нужно тестам.
+func exactResult(t *testing.T, task Task) string {
+	t.Helper()
+
+	if task.Mode == ModeComplex {
+		a, _ := strconv.ParseComplex(task.Operands[0], 128)
+		var z complex128
+		switch task.Operation {
+		case "*":
+			b, _ := strconv.ParseComplex(task.Operands[1], 128)
+			z = a * b
+		case "+":
+			b, _ := strconv.ParseComplex(task.Operands[1], 128)
+			z = a + b
+		case "-":
+			b, _ := strconv.ParseComplex(task.Operands[1], 128)
+			z = a - b
+		case "sqrt":
+			z = cmplx.Sqrt(a)
+		default:
+			t.Fatalf("Неизвестная операция %q", task.Operation)
+		}
+		return strconv.FormatComplex(z, 'g', -1, 128)
+	}
+
+	a, _ := new(big.Rat).SetString(task.Operands[0])
+	b, _ := new(big.Rat).SetString(task.Operands[len(task.Operands)-1])
+	r := new(big.Rat)
+	switch task.Operation {
+	case "sum":
+		for _, text := range task.Operands {
+			v, _ := new(big.Rat).SetString(text)
+			r.Add(r, v)
+		}
+	case "+":
+		r.Add(a, b)
+	case "-":
+		r.Sub(a, b)
+	case "*":
+		r.Mul(a, b)
+	case "/":
+		r.Quo(a, b)
+	default:
+		t.Fatalf("Неизвестная операция %q", task.Operation)
+	}
+
+	if task.Mode != ModeDecimal {
+		return r.RatString()
+	}
+	text := strings.TrimRight(r.FloatString(task.Precision), "0")
+	return strings.TrimSuffix(text, ".")
+}
+
+func expressionResult(t *testing.T, id string) (Status, float64) {
+	t.Helper()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	expr := store[id]
+	if expr.Result == nil {
+		return expr.Status, 0
+	}
+	return expr.Status, *expr.Result
+}
+
+func TestTaskGraphEvaluation(t *testing.T) {
+	isolateState(t)
+
+	tests := []struct {
+		expression string
+		expected   float64
+		tasks      int
+	}{
+		{"2 + 3 * 4", 14, 2},
+		{"(1 + 2) * (3 + 4) - 5", 16, 4},
+		{"100 / 4 / 5", 5, 2},
+		{"2 ^ 3 ^ 2", 512, 2},
+		{"-2 ^ 2 + 17 % 5", -2, 4},
+		{"-7 // 2 * 3", -12, 2},
+		{"max(2, sqrt(16)) * 3", 12, 3},
+		{"max(1, 2 + 3, sqrt(9 * 4)) - 1", 5, 5},
+	}
+
+	for _, tt := range tests {
+		id := submit(t, tt.expression)
+		if executed := runAgent(t); executed != tt.tasks {
+			t.Errorf("❌ %q: ожидалось %d задач, выполнено %d", tt.expression, tt.tasks, executed)
+		}
+		if status, result := expressionResult(t, id); status != StatusDone || result != tt.expected {
+			t.Errorf("❌ %q: ожидалось done %v, получено %s %v", tt.expression, tt.expected, status, result)
+		}
+	}
+}
+
+func TestCommonSubexpressionsDispatchedOnce(t *testing.T) {
+	isolateState(t)
+
+	id := submit(t, "(2 + 3) * (3 + 2) + (2 + 3)")
+	if executed := runAgent(t); executed != 3 {
+		t.Fatalf("❌ Ожидалось 3 задачи (2+3, произведение, сумма), выполнено %d", executed)
+	}
+	if status, result := expressionResult(t, id); status != StatusDone || result != 30 {
+		t.Fatalf("❌ Ожидалось done 30, получено %s %v", status, result)
+	}
+	if stats.Deduplicated != 2 {
+		t.Fatalf("❌ Ожидалось 2 переиспользования внутри выражения, получено %d", stats.Deduplicated)
+	}
+}
+
+func TestInflightTasksAreShared(t *testing.T) {
+	isolateState(t)
+
+	first := submit(t, "(6 * 7) + 1")
+	second := submit(t, "(7 * 6) - 2")
+
+	mutex.Lock()
+	queued := len(tasks)
+	mutex.Unlock()
+	if queued != 1 {
+		t.Fatalf("❌ Общая задача 6*7 должна попасть в очередь один раз, в очереди %d", queued)
+	}
+
+	if executed := runAgent(t); executed != 3 {
+		t.Fatalf("❌ Ожидалось 3 задачи, выполнено %d", executed)
+	}
+	if _, result := expressionResult(t, first); result != 43 {
+		t.Fatalf("❌ Первое выражение: ожидалось 43, получено %v", result)
+	}
+	if _, result := expressionResult(t, second); result != 40 {
+		t.Fatalf("❌ Второе выражение: ожидалось 40, получено %v", result)
+	}
+	if stats.InflightHits != 1 {
+		t.Fatalf("❌ Ожидалось одно переиспользование выполняющейся задачи, получено %d", stats.InflightHits)
+	}
+}
+
+func TestMemoizedResultsSkipAgents(t *testing.T) {
+	isolateState(t)
+
+	submit(t, "(1 + 2) * 3")
+	runAgent(t)
+
+	id := submit(t, "(2 + 1) * 3")
+	if status, result := expressionResult(t, id); status != StatusDone || result != 9 {
+		t.Fatalf("❌ Повторное выражение должно завершиться из кэша: %s %v", status, result)
+	}
+
+	id = submit(t, "(1 + 2) * 3 + 1")
+	if executed := runAgent(t); executed != 1 {
+		t.Fatalf("❌ Из кэша должно взяться произведение, выполнено задач %d", executed)
+	}
+	if _, result := expressionResult(t, id); result != 10 {
+		t.Fatalf("❌ Ожидалось 10, получено %v", result)
+	}
+
+	rr := httptest.NewRecorder()
+	getCacheStats(rr, httptest.NewRequest(http.MethodGet, "/api/v1/stats/cache", nil))
+	var resp map[string]float64
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp["hits"] != 2 || resp["misses"] != 3 || resp["hit_rate"] != 0.4 {
+		t.Fatalf("❌ Неожиданная статистика кэша: %v", resp)
+	}
+}
+
+func TestCancelKeepsSharedTaskAlive(t *testing.T) {
+	isolateState(t)
+
+	first := submit(t, "(5 + 5) * 2")
+	second := submit(t, "(5 + 5) * 3")
+
+	rr := httptest.NewRecorder()
+	expressionHandler(rr, httptest.NewRequest(http.MethodPost, "/api/v1/expressions/"+first+"/cancel", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("❌ Отмена не удалась: %d", rr.Code)
+	}
+
+	runAgent(t)
+
+	if status, _ := expressionResult(t, first); status != StatusCancelled {
+		t.Fatalf("❌ Первое выражение должно остаться отменённым, получено %s", status)
+	}
+	if status, result := expressionResult(t, second); status != StatusDone || result != 30 {
+		t.Fatalf("❌ Второе выражение должно получить общий результат: %s %v", status, result)
+	}
+}
+
+func TestLRUCacheEviction(t *testing.T) {
+	c := newLRUCache(2)
+	c.put("a", Value{Float: 1})
+	c.put("b", Value{Float: 2})
+	c.get("a")
+	c.put("c", Value{Float: 3})
+
+	if _, ok := c.get("b"); ok {
+		t.Fatalf("❌ Самая давно использованная запись должна быть вытеснена")
+	}
+	if v, ok := c.get("a"); !ok || v.Float != 1 {
+		t.Fatalf("❌ Запись a должна остаться в кэше")
+	}
+	if c.len() != 2 {
+		t.Fatalf("❌ Размер кэша должен быть 2, получено %d", c.len())
+	}
+}
+
+func fetchBatch(t *testing.T, query string) (int, []Task) {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	getTask(rr, httptest.NewRequest(http.MethodGet, "/internal/task"+query, nil))
+	var resp struct {
+		Tasks []Task `json:"tasks"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	return rr.Code, resp.Tasks
+}
+
+func TestBatchedTaskFetch(t *testing.T) {
+	isolateState(t)
+	saved := taskBatchLimit
+	taskBatchLimit = 3
+	t.Cleanup(func() { taskBatchLimit = saved })
+
+	submit(t, "(1 + 2) * (3 + 4) + (5 + 6) * (7 + 8)")
+
+	// Готовы четыре суммы; больше limit не выдаётся.
+	if code, batch := fetchBatch(t, "?limit=2"); code != http.StatusOK || len(batch) != 2 {
+		t.Fatalf("❌ Ожидалось 2 задачи, получено %d: %+v", code, batch)
+	}
+	if _, batch := fetchBatch(t, "?limit=10"); len(batch) != 2 {
+		t.Fatalf("❌ Ожидались 2 оставшиеся задачи, получено %+v", batch)
+	}
+	if code, _ := fetchBatch(t, "?limit=2"); code != http.StatusNotFound {
+		t.Errorf("❌ Для пустой очереди ожидался 404, получен %d", code)
+	}
+
+	submit(t, "sum([1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34, 35, 36, 37, 38, 39, 40, 41, 42, 43, 44, 45, 46, 47, 48, 49, 50, 51, 52, 53, 54, 55, 56, 57, 58, 59, 60, 61, 62, 63, 64, 65])")
+	if _, batch := fetchBatch(t, "?limit=10"); len(batch) != 3 {
+		t.Errorf("❌ Пакет ограничен TASK_BATCH_LIMIT = 3, получено %d задач", len(batch))
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=abc"} {
+		rr := httptest.NewRecorder()
+		getTask(rr, httptest.NewRequest(http.MethodGet, "/internal/task"+query, nil))
+		if apiErr := decodeAPIError(t, rr); apiErr.Code != ErrCodeInvalidQuery {
+			t.Errorf("❌ %s: ожидался код %s, получен %s", query, ErrCodeInvalidQuery, apiErr.Code)
+		}
+	}
+}
+
+func TestBatchedResults(t *testing.T) {
+	isolateState(t)
+
+	id := submit(t, "(1 + 2) * (3 + 4)")
+	_, batch := fetchBatch(t, "?limit=2")
+	if len(batch) != 2 {
+		t.Fatalf("❌ Ожидалось 2 задачи, получено %+v", batch)
+	}
+
+	body := fmt.Sprintf(`{"results": [{"id": %q, "result": %v}, {"id": "unknown", "result": 1}, {"id": %q, "result": %v, "duration_ms": 5}]}`,
+		batch[0].ID, batch[0].Arg1+batch[0].Arg2, batch[1].ID, batch[1].Arg1+batch[1].Arg2)
+	rr := httptest.NewRecorder()
+	completeTask(rr, httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBufferString(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("❌ Ожидался статус 200, получен %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Results []struct {
+			ID     string   `json:"id"`
+			Status string   `json:"status"`
+			Error  APIError `json:"error"`
+		} `json:"results"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if len(resp.Results) != 3 || resp.Results[0].Status != "done" || resp.Results[1].Error.Code != ErrCodeTaskNotFound || resp.Results[2].Status != "done" {
+		t.Fatalf("❌ Неожиданный ответ на пакет: %+v", resp.Results)
+	}
+
+	// Обе суммы приняты, готово произведение.
+	runAgent(t)
+	if status, result := expressionResult(t, id); status != StatusDone || result != 21 {
+		t.Errorf("❌ Ожидалось done 21, получено %s %v", status, result)
+	}
+
+	rr = httptest.NewRecorder()
+	completeTask(rr, httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBufferString(`{"results": []}`)))
+	if apiErr := decodeAPIError(t, rr); apiErr.Code != ErrCodeBatchTooLarge {
+		t.Errorf("❌ Для пустого пакета ожидался код %s, получен %s", ErrCodeBatchTooLarge, apiErr.Code)
+	}
+}
@@ -0,0 +1,368 @@
+package server
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Размерность — показатели степеней основных единиц СИ. Значения с единицами
+// переводятся в СИ при разборе, поэтому агенты считают уже приведённые числа.
+type dimension [7]int
+
+const (
+	dimMass = iota
+	dimLength
+	dimTime
+	dimCurrent
+	dimTemperature
+	dimAmount
+	dimLuminosity
+)
+
+// baseUnits — обозначения основных единиц в порядке индексов dimension.
+var baseUnits = [7]string{"kg", "m", "s", "A", "K", "mol", "cd"}
+
+// unit — единица измерения: множитель перевода в СИ записан точно, чтобы
+// приведение не теряло точность в точных режимах.
+type unit struct {
+	factor string
+	dim    dimension
+}
+
+var (
+	force  = dimension{dimMass: 1, dimLength: 1, dimTime: -2}
+	energy = dimension{dimMass: 1, dimLength: 2, dimTime: -2}
+	power  = dimension{dimMass: 1, dimLength: 2, dimTime: -3}
+	volume = dimension{dimLength: 3}
+)
+
+var units = map[string]unit{
+	"m":  {"1", dimension{dimLength: 1}},
+	"km": {"1000", dimension{dimLength: 1}},
+	"cm": {"0.01", dimension{dimLength: 1}},
+	"mm": {"0.001", dimension{dimLength: 1}},
+	"um": {"0.000001", dimension{dimLength: 1}},
+	"nm": {"0.000000001", dimension{dimLength: 1}},
+	"mi": {"1609.344", dimension{dimLength: 1}},
+	"yd": {"0.9144", dimension{dimLength: 1}},
+	"ft": {"0.3048", dimension{dimLength: 1}},
+	"in": {"0.0254", dimension{dimLength: 1}},
+
+	"kg": {"1", dimension{dimMass: 1}},
+	"g":  {"0.001", dimension{dimMass: 1}},
+	"mg": {"0.000001", dimension{dimMass: 1}},
+	"t":  {"1000", dimension{dimMass: 1}},
+	"lb": {"0.45359237", dimension{dimMass: 1}},
+
+	"s":   {"1", dimension{dimTime: 1}},
+	"ms":  {"0.001", dimension{dimTime: 1}},
+	"min": {"60", dimension{dimTime: 1}},
+	"h":   {"3600", dimension{dimTime: 1}},
+	"d":   {"86400", dimension{dimTime: 1}},
+
+	"A":   {"1", dimension{dimCurrent: 1}},
+	"K":   {"1", dimension{dimTemperature: 1}},
+	"mol": {"1", dimension{dimAmount: 1}},
+	"cd":  {"1", dimension{dimLuminosity: 1}},
+
+	"Hz":  {"1", dimension{dimTime: -1}},
+	"N":   {"1", force},
+	"kN":  {"1000", force},
+	"J":   {"1", energy},
+	"kJ":  {"1000", energy},
+	"kWh": {"3600000", energy},
+	"W":   {"1", power},
+	"kW":  {"1000", power},
+	"Pa":  {"1", dimension{dimMass: 1, dimLength: -1, dimTime: -2}},
+	"C":   {"1", dimension{dimCurrent: 1, dimTime: 1}},
+	"V":   {"1", dimension{dimMass: 1, dimLength: 2, dimTime: -3, dimCurrent: -1}},
+	"L":   {"0.001", volume},
+	"mL":  {"0.000001", volume},
+}
+
+func (d dimension) dimensionless() bool {
+	return d == dimension{}
+}
+
+func (d dimension) add(o dimension, sign int) dimension {
+	for i := range d {
+		d[i] += sign * o[i]
+	}
+	return d
+}
+
+func (d dimension) scale(k int) dimension {
+	for i := range d {
+		d[i] *= k
+	}
+	return d
+}
+
+// String записывает размерность в основных единицах СИ: "kg*m/s^2".
+// У безразмерной величины — пустая строка.
+func (d dimension) String() string {
+	var num, den []string
+	for i, exp := range d {
+		term := baseUnits[i]
+		if exp > 1 || exp < -1 {
+			term += "^" + strconv.Itoa(abs(exp))
+		}
+		switch {
+		case exp > 0:
+			num = append(num, term)
+		case exp < 0:
+			den = append(den, term)
+		}
+	}
+	if len(den) == 0 {
+		return strings.Join(num, "*")
+	}
+	if len(num) == 0 {
+		num = []string{"1"}
+	}
+	return strings.Join(num, "*") + "/" + strings.Join(den, "/")
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// withUnit переводит литерал с единицей измерения в СИ.
+func withUnit(n *Node, name string) {
+	u := units[name]
+	r, _ := new(big.Rat).SetString(n.Text)
+	factor, _ := new(big.Rat).SetString(u.factor)
+	r.Mul(r, factor)
+	n.Value, _ = r.Float64()
+	n.Text = r.RatString()
+	n.Unit, n.Dim = name, u.dim
+}
+
+// maxUnitExponent ограничивает степень единицы в запросе: множитель
+// перевода в СИ возводится в неё точно.
+const maxUnitExponent = 10
+
+// parseUnit разбирает единицу результата из запроса: произведение и частное
+// единиц со степенями, например "km/h" или "kg*m^2/s^2".
+func parseUnit(text string) (*big.Rat, dimension, error) {
+	factor, dim := big.NewRat(1, 1), dimension{}
+	if strings.TrimSpace(text) == "" {
+		return nil, dim, fmt.Errorf("пустая единица измерения")
+	}
+
+	sign := 1
+	rest := strings.TrimSpace(text)
+	for {
+		end := strings.IndexAny(rest, "*/")
+		if end < 0 {
+			end = len(rest)
+		}
+		term := strings.TrimSpace(rest[:end])
+		exp := 1
+		if i := strings.Index(term, "^"); i >= 0 {
+			var err error
+			if exp, err = strconv.Atoi(strings.TrimSpace(term[i+1:])); err != nil {
+				return nil, dim, fmt.Errorf("некорректная степень в единице %q", term)
+			}
+			if abs(exp) > maxUnitExponent {
+				return nil, dim, fmt.Errorf("степень в единице %q по модулю больше %d", term, maxUnitExponent)
+			}
+			term = strings.TrimSpace(term[:i])
+		}
+
+		if term != "1" {
+			u, ok := units[term]
+			if !ok {
+				return nil, dim, fmt.Errorf("неизвестная единица измерения %q", term)
+			}
+			f, _ := new(big.Rat).SetString(u.factor)
+			e := big.NewInt(int64(abs(exp)))
+			pow := new(big.Rat).SetFrac(new(big.Int).Exp(f.Num(), e, nil), new(big.Int).Exp(f.Denom(), e, nil))
+			if sign*exp > 0 {
+				factor.Mul(factor, pow)
+			} else {
+				factor.Quo(factor, pow)
+			}
+			dim = dim.add(u.dim.scale(exp), sign)
+		}
+
+		if end == len(rest) {
+			return factor, dim, nil
+		}
+		sign = 1
+		if rest[end] == '/' {
+			sign = -1
+		}
+		rest = rest[end+1:]
+	}
+}
+
+// inferDimensions вычисляет размерности узлов дерева после подстановки
+// переменных (значения переменных безразмерны) и отклоняет операции над
+// несовместимыми величинами.
+func inferDimensions(root *Node) (map[*Node]dimension, error) {
+	dims := make(map[*Node]dimension)
+	var walk func(n *Node) (dimension, error)
+	walk = func(n *Node) (dimension, error) {
+		args := make([]dimension, len(n.Args))
+		for i, arg := range n.Args {
+			d, err := walk(arg)
+			if err != nil {
+				return dimension{}, err
+			}
+			args[i] = d
+		}
+		d, err := nodeDimension(n, args)
+		if err != nil {
+			return dimension{}, err
+		}
+		dims[n] = d
+		return d, nil
+	}
+	if _, err := walk(root); err != nil {
+		return nil, err
+	}
+	return dims, nil
+}
+
+func nodeDimension(n *Node, args []dimension) (dimension, error) {
+	fail := func(format string, a ...interface{}) (dimension, error) {
+		return dimension{}, &ParseError{Message: fmt.Sprintf(format, a...), Token: n.Op, Position: n.Pos}
+	}
+	same := func() (dimension, error) {
+		for _, d := range args[1:] {
+			if d != args[0] {
+				return fail("несовместимые размерности: %s и %s", describeDimension(args[0]), describeDimension(d))
+			}
+		}
+		return args[0], nil
+	}
+	dimensionless := func() (dimension, error) {
+		for _, d := range args {
+			if !d.dimensionless() {
+				return fail("%s ожидает безразмерные аргументы, получено %s", n.Op, d)
+			}
+		}
+		return dimension{}, nil
+	}
+
+	switch n.Kind {
+	case NodeNumber:
+		return n.Dim, nil
+	case NodeVariable, NodeMatrix:
+		return dimension{}, nil
+	case NodeCall:
+		switch n.Op {
+		case "abs", "round", "floor", "ceil", "trunc", "min", "max", "hypot", "sum", "dev":
+			return same()
+		case "sqdev":
+			d, err := same()
+			return d.scale(2), err
+		case "product":
+			var out dimension
+			for _, d := range args {
+				out = out.add(d, 1)
+			}
+			return out, nil
+		case "if":
+			if !args[0].dimensionless() {
+				return fail("условие if должно быть безразмерным, получено %s", args[0])
+			}
+			if args[1] != args[2] {
+				return fail("несовместимые размерности ветвей if: %s и %s", describeDimension(args[1]), describeDimension(args[2]))
+			}
+			return args[1], nil
+		case "sign", "atan2":
+			if _, err := same(); err != nil {
+				return dimension{}, err
+			}
+			return dimension{}, nil
+		case "sqrt", "cbrt":
+			root := 2
+			if n.Op == "cbrt" {
+				root = 3
+			}
+			var out dimension
+			for i, exp := range args[0] {
+				if exp%root != 0 {
+					return fail("%s: корень из величины размерности %s не имеет целой размерности", n.Op, args[0])
+				}
+				out[i] = exp / root
+			}
+			return out, nil
+		default:
+			return dimensionless()
+		}
+	}
+
+	left, right := args[0], args[1]
+	switch n.Op {
+	case "+", "-", "%":
+		return same()
+	case "//", "==", "!=", "<", "<=", ">", ">=":
+		if _, err := same(); err != nil {
+			return dimension{}, err
+		}
+		return dimension{}, nil
+	case "&&", "||":
+		return dimensionless()
+	case "*":
+		return left.add(right, 1), nil
+	case "/":
+		return left.add(right, -1), nil
+	case "^":
+		if !right.dimensionless() {
+			return fail("показатель степени должен быть безразмерным, получено %s", right)
+		}
+		if left.dimensionless() {
+			return left, nil
+		}
+		exp := n.Args[1]
+		if exp.Kind != NodeNumber || exp.Value != float64(int(exp.Value)) {
+			return fail("величину размерности %s можно возводить только в целую степень, заданную числом", left)
+		}
+		return left.scale(int(exp.Value)), nil
+	}
+	return left, nil
+}
+
+func describeDimension(d dimension) string {
+	if d.dimensionless() {
+		return "безразмерная величина"
+	}
+	return d.String()
+}
+
+// applyResultUnit проверяет единицу результата из запроса и, если она
+// отличается от СИ, добавляет к выражению перевод в неё. dim — размерность
+// выражения. Возвращает дерево и обозначение единицы результата.
+func applyResultUnit(root *Node, dim dimension, unit, mode string) (*Node, string, error) {
+	if unit == "" {
+		return root, dim.String(), nil
+	}
+	if mode == ModeComplex {
+		return nil, "", fmt.Errorf("единицы измерения недоступны в режиме %s", ModeComplex)
+	}
+
+	factor, want, err := parseUnit(unit)
+	if err != nil {
+		return nil, "", err
+	}
+	if want != dim {
+		return nil, "", fmt.Errorf("результат имеет размерность %s, её нельзя выразить в %s", describeDimension(dim), unit)
+	}
+	if factor.Cmp(big.NewRat(1, 1)) == 0 {
+		return root, unit, nil
+	}
+	if isIntegerMode(mode) && !factor.IsInt() {
+		return nil, "", fmt.Errorf("в целочисленном режиме единица результата должна быть целым кратным единицы СИ")
+	}
+	value, _ := factor.Float64()
+	divisor := &Node{Kind: NodeNumber, Value: value, Text: factor.RatString(), Pos: -1}
+	return &Node{Kind: NodeBinary, Op: "/", Args: []*Node{root, divisor}, Pos: -1}, unit, nil
+}
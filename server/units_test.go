@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUnitLiteralsNormalized(t *testing.T) {
+	root, err := parseExpressionAST("5 km / 2 h + min(1, 2)")
+	if err != nil {
+		t.Fatalf("❌ Неожиданная ошибка: %v", err)
+	}
+	div := root.Args[0]
+	if km := div.Args[0]; km.Value != 5000 || km.Unit != "km" || km.Dim != (dimension{dimLength: 1}) {
+		t.Errorf("❌ 5 km должно стать 5000 м, получено %v %s %v", km.Value, km.Unit, km.Dim)
+	}
+	if h := div.Args[1]; h.Value != 7200 || h.Text != "7200" {
+		t.Errorf("❌ 2 h должно стать 7200 с, получено %v %q", h.Value, h.Text)
+	}
+	if root.Args[1].Kind != NodeCall {
+		t.Errorf("❌ min с круглой скобкой — функция, а не единица измерения")
+	}
+
+	if _, err := parseExpressionAST("2 x + 1"); err == nil {
+		t.Errorf("❌ Неизвестный идентификатор после числа должен отклоняться")
+	}
+	var pe *ParseError
+	if _, err := parseExpressionAST("4i m + 1"); !errors.As(err, &pe) || pe.Token != "m" {
+		t.Errorf("❌ Мнимое число с единицей должно отклоняться, получено %v", err)
+	}
+}
+
+func TestDimensionString(t *testing.T) {
+	tests := []struct {
+		dim      dimension
+		expected string
+	}{
+		{dimension{}, ""},
+		{dimension{dimLength: 1, dimTime: -1}, "m/s"},
+		{dimension{dimMass: 1, dimLength: 2, dimTime: -2}, "kg*m^2/s^2"},
+		{dimension{dimTime: -1}, "1/s"},
+	}
+	for _, tt := range tests {
+		if got := tt.dim.String(); got != tt.expected {
+			t.Errorf("❌ %v: ожидалось %q, получено %q", tt.dim, tt.expected, got)
+		}
+	}
+}
+
+func TestParseUnit(t *testing.T) {
+	factor, dim, err := parseUnit("km/h")
+	if err != nil || factor.RatString() != "5/18" || dim != (dimension{dimLength: 1, dimTime: -1}) {
+		t.Errorf("❌ km/h: получено %v %v %v", factor, dim, err)
+	}
+	factor, dim, err = parseUnit("kg*m^2/s^2")
+	if err != nil || factor.RatString() != "1" || dim != units["J"].dim {
+		t.Errorf("❌ kg*m^2/s^2: получено %v %v %v", factor, dim, err)
+	}
+	if _, _, err := parseUnit("km/parsec"); err == nil {
+		t.Errorf("❌ Неизвестная единица должна отклоняться")
+	}
+	factor, _, err = parseUnit("km^-2*h^2")
+	if err != nil || factor.RatString() != "324/25" {
+		t.Errorf("❌ km^-2*h^2: получено %v %v", factor, err)
+	}
+	if _, _, err := parseUnit("km^1000000000"); err == nil {
+		t.Errorf("❌ Слишком большая степень единицы должна отклоняться")
+	}
+}
+
+func TestDimensionErrors(t *testing.T) {
+	isolateState(t)
+
+	tests := []struct {
+		body    string
+		code    string
+		message string
+	}{
+		{`{"expression": "3 m + 2 s"}`, ErrCodeInvalidExpression, "несовместимые размерности: m и s"},
+		{`{"expression": "2 km - 1"}`, ErrCodeInvalidExpression, "несовместимые размерности: m и безразмерная величина"},
+		{`{"expression": "sin(2 m)"}`, ErrCodeInvalidExpression, "sin ожидает безразмерные аргументы, получено m"},
+		{`{"expression": "2 ^ (1 s)"}`, ErrCodeInvalidExpression, "показатель степени должен быть безразмерным, получено s"},
+		{`{"expression": "(2 m) ^ x", "variables": {"x": 0.5}}`, ErrCodeInvalidExpression, "величину размерности m можно возводить только в целую степень, заданную числом"},
+		{`{"expression": "sqrt(2 m)"}`, ErrCodeInvalidExpression, "sqrt: корень из величины размерности m не имеет целой размерности"},
+		{`{"expression": "5 km / 2 h", "unit": "kg"}`, ErrCodeInvalidUnit, "результат имеет размерность m/s, её нельзя выразить в kg"},
+		{`{"expression": "5 km / 2 h", "unit": "km/fortnight"}`, ErrCodeInvalidUnit, "неизвестная единица измерения \"fortnight\""},
+		{`{"expression": "2 m * 3i"}`, ErrCodeInvalidExpression, "мнимые числа доступны только в режиме complex"},
+		{`{"expression": "2 m * 3", "mode": "complex"}`, ErrCodeInvalidExpression, "единицы измерения недоступны в режиме complex"},
+	}
+	for _, tt := range tests {
+		rr := submitBody(tt.body)
+		apiErr := decodeAPIError(t, rr)
+		if apiErr.Code != tt.code || apiErr.Message != tt.message {
+			t.Errorf("❌ %s: ожидалось %s %q, получено %d %s %q", tt.body, tt.code, tt.message, rr.Code, apiErr.Code, apiErr.Message)
+		}
+	}
+}
+
+func TestUnitExpressionEvaluation(t *testing.T) {
+	isolateState(t)
+
+	si := responseID(submitBody(`{"expression": "5 km / 2 h"}`))
+
+	rr := httptest.NewRecorder()
+	getTask(rr, httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+	var resp struct {
+		Task map[string]interface{} `json:"task"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Task["unit"] != "m/s" || resp.Task["arg1"] != 5000.0 || resp.Task["arg2"] != 7200.0 {
+		t.Fatalf("❌ Агент должен получить значения в СИ и единицу m/s, получено %v", resp.Task)
+	}
+	completeTask(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/internal/task",
+		bytes.NewBufferString(`{"id": "`+resp.Task["id"].(string)+`", "result": 0.6944444444444444}`)))
+
+	converted := responseID(submitBody(`{"expression": "5 km / 2 h", "unit": "km/h"}`))
+	runAgent(t)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if expr := store[si]; expr.Status != StatusDone || expr.Unit != "m/s" {
+		t.Errorf("❌ Ожидался результат в m/s, получено %s %q", expr.Status, expr.Unit)
+	}
+	expr := store[converted]
+	if expr.Status != StatusDone || expr.Result == nil || *expr.Result != 2.5 || expr.Unit != "km/h" {
+		t.Fatalf("❌ Ожидалось done 2.5 km/h, получено %s %v %q", expr.Status, expr.Result, expr.Unit)
+	}
+	if root := expr.Tasks[len(expr.Tasks)-1]; root.Unit != "km/h" {
+		t.Errorf("❌ Корневая задача должна нести единицу результата, получено %q", root.Unit)
+	}
+}
+
+func TestUnitsInRationalMode(t *testing.T) {
+	isolateState(t)
+
+	id := responseID(submitBody(`{"expression": "1 mi / 1 h", "mode": "rational", "unit": "km/h"}`))
+	runAgent(t)
+
+	mutex.Lock()
+	expr := store[id]
+	mutex.Unlock()
+	if expr.Status != StatusDone || expr.Value != "25146/15625" || expr.Unit != "km/h" {
+		t.Fatalf("❌ Ожидалось done 25146/15625 km/h (1,609344), получено %s %q %q", expr.Status, expr.Value, expr.Unit)
+	}
+}
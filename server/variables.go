@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Variables — значения числовых переменных запроса. Числа хранятся в той
+// записи, в которой пришли в JSON: целые больше 2^53 и десятичные дроби
+// подставляются в точных режимах без округления до float64.
+type Variables map[string]json.Number
+
+// UnboundVariablesError — в выражении есть переменные без значения.
+// Token и Position указывают на первое вхождение первой из них.
+type UnboundVariablesError struct {
+	Names    []string
+	Token    string
+	Position int
+}
+
+func (e *UnboundVariablesError) Error() string {
+	return "не заданы значения переменных: " + strings.Join(e.Names, ", ")
+}
+
+func isIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, ch := range name {
+		if !(unicode.IsLetter(ch) || ch == '_' || (i > 0 && unicode.IsDigit(ch))) {
+			return false
+		}
+	}
+	return true
+}
+
+func validateVariables(vars Variables) error {
+	for name, value := range vars {
+		if !isIdentifier(name) {
+			return fmt.Errorf("некорректное имя переменной %q", name)
+		}
+		if v, err := strconv.ParseFloat(string(value), 64); err != nil || math.IsInf(v, 0) {
+			return fmt.Errorf("значение переменной %s не является конечным числом", name)
+		}
+	}
+	return nil
+}
+
+// bindVariables возвращает копию дерева, в которой переменные заменены их
+// значениями — числами из vars или матрицами из matrices; исходное дерево
+// не меняется. Число сохраняет запись из запроса в Text, как литерал.
+// Деление на переменную, равную нулю, отклоняется так же, как деление на
+// литерал 0.
+func bindVariables(n *Node, vars Variables, matrices map[string][][]float64) (*Node, error) {
+	var unbound []*Node
+	bound, err := bind(n, vars, matrices, &unbound)
+	if err != nil {
+		return nil, err
+	}
+	if len(unbound) == 0 {
+		return bound, nil
+	}
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, v := range unbound {
+		if !seen[v.Name] {
+			seen[v.Name] = true
+			names = append(names, v.Name)
+		}
+	}
+	sort.Strings(names)
+	first := unbound[0]
+	return nil, &UnboundVariablesError{Names: names, Token: first.Name, Position: first.Pos}
+}
+
+func bind(n *Node, vars Variables, matrices map[string][][]float64, unbound *[]*Node) (*Node, error) {
+	switch n.Kind {
+	case NodeNumber, NodeMatrix:
+		return n, nil
+	case NodeVariable:
+		if m, ok := matrices[n.Name]; ok {
+			return &Node{Kind: NodeMatrix, Name: n.Name, Matrix: m, Pos: n.Pos}, nil
+		}
+		text, ok := vars[n.Name]
+		if !ok {
+			*unbound = append(*unbound, n)
+			return n, nil
+		}
+		value, _ := strconv.ParseFloat(string(text), 64)
+		return &Node{Kind: NodeNumber, Name: n.Name, Value: value, Text: string(text), Pos: n.Pos}, nil
+	}
+
+	out := &Node{Kind: n.Kind, Op: n.Op, Pos: n.Pos, Args: make([]*Node, len(n.Args))}
+	for i, arg := range n.Args {
+		bound, err := bind(arg, vars, matrices, unbound)
+		if err != nil {
+			return nil, err
+		}
+		out.Args[i] = bound
+	}
+
+	if n.Kind == NodeBinary {
+		right := out.Args[1]
+		if info := binaryOps[n.Op]; info.zeroDivisor != "" && n.Args[1].Kind == NodeVariable && right.Kind == NodeNumber && right.Value == 0 {
+			return nil, &ParseError{Message: info.zeroDivisor, Token: n.Args[1].Name, Position: n.Args[1].Pos}
+		}
+	}
+	return out, nil
+}
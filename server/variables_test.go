@@ -0,0 +1,124 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func submitBody(body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	addExpression(rr, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBufferString(body)))
+	return rr
+}
+
+func TestBindVariables(t *testing.T) {
+	root, err := parseExpressionAST("x * (y + 1) - max(x, z)")
+	if err != nil {
+		t.Fatalf("❌ Неожиданная ошибка разбора: %v", err)
+	}
+	if got := canonical(root); got != "(- (* (+ 1 y) x) (max x z))" {
+		t.Fatalf("❌ Неожиданное дерево %s", got)
+	}
+
+	bound, err := bindVariables(root, Variables{"x": "2", "y": "3", "z": "5"}, nil)
+	if err != nil {
+		t.Fatalf("❌ Неожиданная ошибка подстановки: %v", err)
+	}
+	if got := canonical(bound); got != "(- (* (+ 1 3) 2) (max 2 5))" {
+		t.Errorf("❌ Неожиданный результат подстановки %s", got)
+	}
+	if got := canonical(root); got != "(- (* (+ 1 y) x) (max x z))" {
+		t.Errorf("❌ Исходное дерево не должно меняться, получено %s", got)
+	}
+
+	_, err = bindVariables(root, Variables{"y": "1"}, nil)
+	var unbound *UnboundVariablesError
+	if !errors.As(err, &unbound) {
+		t.Fatalf("❌ Ожидалась UnboundVariablesError, получено %v", err)
+	}
+	if !reflect.DeepEqual(unbound.Names, []string{"x", "z"}) || unbound.Token != "x" || unbound.Position != 0 {
+		t.Errorf("❌ Неожиданная ошибка %+v", unbound)
+	}
+}
+
+func TestSubmitWithVariables(t *testing.T) {
+	isolateState(t)
+
+	rr := submitBody(`{"expression": "price * (1 + tax) - discount", "variables": {"price": 100, "tax": 0.2, "discount": 15}}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("❌ Ожидался статус %d, получен %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	id := responseID(rr)
+	runAgent(t)
+
+	if status, result := expressionResult(t, id); status != StatusDone || result != 105 {
+		t.Fatalf("❌ Ожидалось done 105, получено %s %v", status, result)
+	}
+
+	rr = httptest.NewRecorder()
+	getExpression(rr, httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+id, nil))
+	var resp struct {
+		Expression struct {
+			Variables map[string]float64 `json:"variables"`
+		} `json:"expression"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if want := map[string]float64{"price": 100, "tax": 0.2, "discount": 15}; !reflect.DeepEqual(resp.Expression.Variables, want) {
+		t.Errorf("❌ Переменные не возвращены в записи выражения: %v", resp.Expression.Variables)
+	}
+}
+
+func TestSubmitVariableErrors(t *testing.T) {
+	isolateState(t)
+
+	tests := []struct {
+		name string
+		body string
+		code string
+	}{
+		{"unbound", `{"expression": "a + b", "variables": {"a": 1}}`, ErrCodeInvalidExpression},
+		{"bad name", `{"expression": "a + 1", "variables": {"a": 1, "1x": 2}}`, ErrCodeInvalidVariables},
+		{"zero divisor", `{"expression": "a / b", "variables": {"a": 1, "b": 0}}`, ErrCodeInvalidExpression},
+	}
+
+	for _, tt := range tests {
+		rr := submitBody(tt.body)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("❌ [%s] Ожидался статус %d, получен %d", tt.name, http.StatusBadRequest, rr.Code)
+			continue
+		}
+		if apiErr := decodeAPIError(t, rr); apiErr.Code != tt.code {
+			t.Errorf("❌ [%s] Ожидался код %s, получено %+v", tt.name, tt.code, apiErr)
+		} else if tt.name == "unbound" && !reflect.DeepEqual(apiErr.Details["variables"], []interface{}{"b"}) {
+			t.Errorf("❌ [%s] Ожидался список незаданных переменных, получено %v", tt.name, apiErr.Details)
+		}
+	}
+}
+
+func TestIdempotencyKeyCoversVariables(t *testing.T) {
+	isolateState(t)
+
+	send := func(x string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate",
+			bytes.NewBufferString(`{"expression": "x + 1", "variables": {"x": `+x+`}}`))
+		req.Header.Set("Idempotency-Key", "vars-key")
+		rr := httptest.NewRecorder()
+		addExpression(rr, req)
+		return rr.Code
+	}
+
+	if code := send("1"); code != http.StatusCreated {
+		t.Fatalf("❌ Ожидался статус %d, получен %d", http.StatusCreated, code)
+	}
+	if code := send("1"); code != http.StatusOK {
+		t.Fatalf("❌ Повтор с теми же переменными: ожидался статус %d, получен %d", http.StatusOK, code)
+	}
+	if code := send("2"); code != http.StatusUnprocessableEntity {
+		t.Fatalf("❌ Другие значения переменных: ожидался статус %d, получен %d", http.StatusUnprocessableEntity, code)
+	}
+}
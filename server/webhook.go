@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Delivery struct {
+	Attempt    int    `json:"attempt"`
+	Time       string `json:"time"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Success    bool   `json:"success"`
+}
+
+var (
+	webhookClient      = &http.Client{Timeout: 10 * time.Second}
+	webhookMaxAttempts int
+	webhookBackoff     time.Duration
+
+	deliveries   = make(map[string][]Delivery)
+	deliveriesMu sync.Mutex
+)
+
+// validateCallbackURL проверяет callback_url при приёме выражения. Без
+// WEBHOOK_SECRET получатель не сможет проверить подпись, поэтому такие
+// запросы отклоняются. Адреса, ведущие во внутреннюю сеть (loopback,
+// частные, link-local), запрещены, если хост не указан в
+// WEBHOOK_ALLOWED_HOSTS.
+func validateCallbackURL(raw string) error {
+	u, err := url.ParseRequestURI(raw)
+	if err != nil {
+		return fmt.Errorf("некорректный callback_url: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("callback_url должен использовать http или https")
+	}
+	if u.Host == "" {
+		return fmt.Errorf("callback_url должен содержать хост")
+	}
+	if os.Getenv("WEBHOOK_SECRET") == "" {
+		return fmt.Errorf("callback_url недоступен: на сервере не задан WEBHOOK_SECRET")
+	}
+	host := u.Hostname()
+	if webhookHostAllowed(host) {
+		return nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return fmt.Errorf("не удалось разрешить хост callback_url %s: %v", host, err)
+	}
+	for _, ip := range ips {
+		if isInternalIP(ip) {
+			return fmt.Errorf("callback_url указывает на внутренний адрес %s", ip)
+		}
+	}
+	return nil
+}
+
+// webhookHostAllowed сообщает, перечислен ли хост в WEBHOOK_ALLOWED_HOSTS
+// (через запятую, без учёта регистра).
+func webhookHostAllowed(host string) bool {
+	for _, allowed := range strings.Split(os.Getenv("WEBHOOK_ALLOWED_HOSTS"), ",") {
+		if allowed = strings.TrimSpace(allowed); allowed != "" && strings.EqualFold(allowed, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func isInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+func signPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyCallback вызывается под mutex, поэтому тело сериализуется сразу,
+// а доставка уходит в отдельную горутину.
+func notifyCallback(expr Expression) {
+	if expr.CallbackURL == "" {
+		return
+	}
+	payload, err := json.Marshal(expr)
+	if err != nil {
+		fmt.Printf("Ошибка сериализации выражения %s для webhook: %v\n", expr.ID, err)
+		return
+	}
+	go deliverWebhook(expr.ID, expr.CallbackURL, payload)
+}
+
+func deliverWebhook(exprID, callbackURL string, payload []byte) {
+	secret := os.Getenv("WEBHOOK_SECRET")
+	backoff := webhookBackoff
+
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		d := Delivery{Attempt: attempt, Time: time.Now().Format(time.RFC3339Nano)}
+
+		req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(payload))
+		if err != nil {
+			d.Error = err.Error()
+			recordDelivery(exprID, d)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Expression-ID", exprID)
+		req.Header.Set("X-Webhook-Attempt", fmt.Sprint(attempt))
+		if secret != "" {
+			req.Header.Set("X-Signature", signPayload(secret, payload))
+		}
+
+		resp, err := webhookClient.Do(req)
+		if err != nil {
+			d.Error = err.Error()
+		} else {
+			resp.Body.Close()
+			d.StatusCode = resp.StatusCode
+			d.Success = resp.StatusCode >= 200 && resp.StatusCode < 300
+		}
+		recordDelivery(exprID, d)
+
+		if d.Success {
+			fmt.Printf("Webhook для выражения %s доставлен с попытки %d\n", exprID, attempt)
+			return
+		}
+		fmt.Printf("Webhook для выражения %s не доставлен (попытка %d): статус %d %s\n", exprID, attempt, d.StatusCode, d.Error)
+
+		if attempt < webhookMaxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+func recordDelivery(exprID string, d Delivery) {
+	deliveriesMu.Lock()
+	deliveries[exprID] = append(deliveries[exprID], d)
+	deliveriesMu.Unlock()
+}
+
+func getDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/expressions/"), "/deliveries")
+	mutex.Lock()
+	_, exists := store[id]
+	mutex.Unlock()
+	if !exists {
+		writeError(w, http.StatusNotFound, ErrCodeExpressionNotFound, "выражение не найдено", map[string]interface{}{"id": id})
+		return
+	}
+
+	deliveriesMu.Lock()
+	history := append([]Delivery{}, deliveries[id]...)
+	deliveriesMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"deliveries": history})
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitForDeliveries(exprID string, n int) []Delivery {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		deliveriesMu.Lock()
+		log := append([]Delivery{}, deliveries[exprID]...)
+		deliveriesMu.Unlock()
+		if len(log) >= n {
+			return log
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func TestWebhookDeliveredWithRetryAndSignature(t *testing.T) {
+	testName := "TestWebhookDeliveredWithRetryAndSignature"
+
+	t.Setenv("WEBHOOK_SECRET", "s3cret")
+	originalBackoff := webhookBackoff
+	webhookBackoff = time.Millisecond
+	defer func() { webhookBackoff = originalBackoff }()
+
+	var calls int32
+	received := make(chan Expression, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if got, want := r.Header.Get("X-Signature"), signPayload("s3cret", body); got != want {
+			t.Errorf("❌ [%s] Неверная подпись: %s, ожидается %s", testName, got, want)
+		}
+		var expr Expression
+		json.Unmarshal(body, &expr)
+		received <- expr
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer receiver.Close()
+
+	mutex.Lock()
+	saveNewExpression(Expression{
+		ID:          "hook_expr",
+		Expr:        "2 * 4",
+		Status:      StatusQueued,
+		Tasks:       []Task{{ID: "hook_task", Arg1: 2, Arg2: 4, Operation: "*", Status: TaskDispatched}},
+		CallbackURL: receiver.URL,
+	})
+	mutex.Unlock()
+
+	req := httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBufferString(`{"id": "hook_task", "result": 8}`))
+	rr := httptest.NewRecorder()
+	completeTask(rr, req)
+
+	select {
+	case expr := <-received:
+		if expr.ID != "hook_expr" || expr.Status != "done" || expr.Result == nil || *expr.Result != 8 {
+			t.Fatalf("❌ [%s] Получено неожиданное выражение: %+v", testName, expr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("❌ [%s] Webhook не был доставлен", testName)
+	}
+
+	log := waitForDeliveries("hook_expr", 2)
+	if len(log) != 2 || log[0].Success || log[0].StatusCode != http.StatusServiceUnavailable || !log[1].Success {
+		t.Fatalf("❌ [%s] Неожиданный журнал доставки: %+v", testName, log)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/expressions/hook_expr/deliveries", nil)
+	rr = httptest.NewRecorder()
+	expressionHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("❌ [%s] Ожидался статус %d, но получен %d", testName, http.StatusOK, rr.Code)
+	}
+
+	fmt.Printf("[%s] прошел успешно!\n", testName)
+}
+
+func TestWebhookGivesUpAfterMaxAttempts(t *testing.T) {
+	testName := "TestWebhookGivesUpAfterMaxAttempts"
+
+	originalBackoff, originalAttempts := webhookBackoff, webhookMaxAttempts
+	webhookBackoff, webhookMaxAttempts = time.Millisecond, 3
+	defer func() { webhookBackoff, webhookMaxAttempts = originalBackoff, originalAttempts }()
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	deliverWebhook("hook_fail", receiver.URL, []byte(`{}`))
+
+	log := waitForDeliveries("hook_fail", 3)
+	if len(log) != 3 {
+		t.Fatalf("❌ [%s] Ожидалось 3 попытки, получено %d", testName, len(log))
+	}
+	for _, d := range log {
+		if d.Success {
+			t.Fatalf("❌ [%s] Попытка %d не должна быть успешной", testName, d.Attempt)
+		}
+	}
+
+	fmt.Printf("[%s] прошел успешно!\n", testName)
+}
+
+func TestAddExpressionRejectsInvalidCallback(t *testing.T) {
+	testName := "TestAddExpressionRejectsInvalidCallback"
+
+	reqBody := `{"expression": "2 + 3", "callback_url": "ftp://example.com/hook"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBufferString(reqBody))
+	rr := httptest.NewRecorder()
+
+	addExpression(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("❌ [%s] Ожидался статус %d, но получен %d", testName, http.StatusBadRequest, rr.Code)
+	}
+
+	fmt.Printf("[%s] прошел успешно!\n", testName)
+}
+
+func TestValidateCallbackURLRefusesInternalHosts(t *testing.T) {
+	testName := "TestValidateCallbackURLRefusesInternalHosts"
+
+	t.Setenv("WEBHOOK_SECRET", "")
+	t.Setenv("WEBHOOK_ALLOWED_HOSTS", "")
+	if err := validateCallbackURL("http://93.184.216.34/hook"); err == nil {
+		t.Fatalf("❌ [%s] Без WEBHOOK_SECRET callback_url должен отклоняться", testName)
+	}
+
+	t.Setenv("WEBHOOK_SECRET", "s3cret")
+	for _, raw := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://0.0.0.0/hook",
+	} {
+		if err := validateCallbackURL(raw); err == nil {
+			t.Fatalf("❌ [%s] Внутренний адрес %s должен отклоняться", testName, raw)
+		}
+	}
+	if err := validateCallbackURL("https://93.184.216.34/hook"); err != nil {
+		t.Fatalf("❌ [%s] Внешний адрес должен приниматься, получено: %v", testName, err)
+	}
+
+	t.Setenv("WEBHOOK_ALLOWED_HOSTS", "localhost, 127.0.0.1")
+	if err := validateCallbackURL("http://127.0.0.1:8080/hook"); err != nil {
+		t.Fatalf("❌ [%s] Хост из WEBHOOK_ALLOWED_HOSTS должен приниматься, получено: %v", testName, err)
+	}
+
+	fmt.Printf("[%s] прошел успешно!\n", testName)
+}